@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/chunker"
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/llm"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/akhenakh/qmd/internal/util"
+)
+
+func generateEmbeddings() {
+	embedder, err := getEmbedder()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer embedder.Close()
+
+	// Update variable type based on Store change
+	pending, err := globalStore.GetPendingEmbeddings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("No pending embeddings.")
+	} else {
+		embedChunks(embedder, pending)
+	}
+
+	if globalConfig.DocVectors != "" {
+		embedDocuments(embedder)
+	}
+}
+
+// embedChunks splits pending documents and embeds their chunks.
+func embedChunks(embedder llm.Embedder, pending map[string]store.PendingDoc) {
+	var err error
+	fmt.Printf("Generating embeddings for %d documents (Dim: %d)...\n", len(pending), globalConfig.EmbedDimensions)
+
+	// Vectors are cached by chunk text and model, so unchanged chunks of an
+	// edited document and chunks shared between documents are reused.
+	modelID := embeddingModelID()
+	var embedded, reused int
+
+	// Chunking strategies are configured per collection
+	chunkers := make(map[string]chunker.Chunker)
+
+	for hash, doc := range pending {
+		c, ok := chunkers[doc.Collection]
+		if !ok {
+			c, err = chunker.ForCollection(globalConfig, doc.Collection)
+			if err != nil {
+				log.Fatal(err)
+			}
+			chunkers[doc.Collection] = c
+		}
+
+		chunks, err := chunker.SplitDocument(c, doc.Title, doc.Body)
+		if err != nil {
+			log.Printf("Error splitting: %v", err)
+			continue
+		}
+
+		// Nothing is saved until all the chunks are embedded, so that a
+		// document with a failed chunk stays pending and is retried
+		type chunkVector struct {
+			key, text string
+			vec       []float32
+		}
+		var vectors []chunkVector
+		var docEmbedded, docReused int
+		failed := false
+		for _, chunk := range chunks {
+			text := chunk.Text
+			if globalConfig.ContextualChunks {
+				text = contextualChunk(doc, chunk)
+			}
+
+			key := util.HashChunk(modelID, text)
+			exists, err := globalStore.HasEmbedding(key)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			var vec []float32
+			if exists {
+				docReused++
+			} else {
+				vec, err = embedder.Embed(text, false)
+				if err != nil {
+					log.Printf("Error embedding %q of collection %s, it will be retried by the next embed: %v", doc.Title, doc.Collection, err)
+					failed = true
+					break
+				}
+				docEmbedded++
+			}
+			vectors = append(vectors, chunkVector{key: key, text: chunk.Text, vec: vec})
+		}
+		if failed {
+			continue
+		}
+
+		for i, v := range vectors {
+			if err := globalStore.SaveEmbedding(hash, i, v.key, v.text, v.vec); err != nil {
+				log.Fatal(err)
+			}
+		}
+		embedded += docEmbedded
+		reused += docReused
+		fmt.Print(".")
+	}
+
+	pruned, err := globalStore.PruneEmbeddings()
+	if err != nil {
+		log.Printf("Error pruning embeddings: %v", err)
+	}
+	fmt.Printf("\nDone. Embedded %d chunks, reused %d cached vectors, pruned %d stale vectors.\n", embedded, reused, pruned)
+}
+
+// contextualChunk prefixes a chunk with the document title, collection,
+// front matter tags and heading breadcrumb, so chunks deep in a document
+// remain retrievable by what the document is about.
+func contextualChunk(doc store.PendingDoc, chunk chunker.Chunk) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Document: %s\n", doc.Title)
+	fmt.Fprintf(&sb, "Collection: %s\n", doc.Collection)
+
+	fields, _ := util.ParseFrontMatter(doc.Body)
+	if tags := util.FrontMatterList(fields, "tags"); len(tags) > 0 {
+		fmt.Fprintf(&sb, "Tags: %s\n", strings.Join(tags, ", "))
+	}
+	if len(chunk.Headings) > 0 {
+		fmt.Fprintf(&sb, "Section: %s\n", strings.Join(chunk.Headings, " > "))
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(chunk.Text)
+	return sb.String()
+}
+
+// embedDocuments stores a document level vector for every embedded document
+// missing one: either the mean of its chunk vectors or the embedding of its
+// title and summary, depending on the doc_vectors setting.
+func embedDocuments(embedder llm.Embedder) {
+	pending, err := globalStore.GetPendingDocEmbeddings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	fmt.Printf("Generating document vectors (%s) for %d documents...\n", globalConfig.DocVectors, len(pending))
+	for hash, doc := range pending {
+		var vec []float32
+		switch globalConfig.DocVectors {
+		case config.DocVectorsSummary:
+			vec, err = embedder.Embed(documentSummary(doc), false)
+			if err != nil {
+				log.Printf("Error embedding: %v", err)
+				continue
+			}
+		default:
+			vecs, err := globalStore.GetChunkEmbeddings(hash)
+			if err != nil {
+				log.Fatal(err)
+			}
+			vec = store.MeanVector(vecs)
+		}
+		if vec == nil {
+			continue
+		}
+		if err := globalStore.SaveDocEmbedding(hash, vec); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(".")
+	}
+	fmt.Println("\nDone.")
+}
+
+// documentSummary returns the text embedded as a document level vector in
+// summary mode: the title followed by the front matter summary or
+// description, or else the beginning of the body.
+func documentSummary(doc store.PendingDoc) string {
+	fields, body := util.ParseFrontMatter(doc.Body)
+	summary := ""
+	for _, key := range []string{"summary", "description"} {
+		if v, ok := fields[key].(string); ok && strings.TrimSpace(v) != "" {
+			summary = v
+			break
+		}
+	}
+	if summary == "" {
+		summary = strings.TrimSpace(body)
+	}
+
+	runes := []rune(summary)
+	if len(runes) > globalConfig.ChunkSize {
+		summary = string(runes[:globalConfig.ChunkSize])
+	}
+	return fmt.Sprintf("# %s\n\n%s", doc.Title, summary)
+}
+
+// embeddingModelID identifies the embedding model for the vector cache.
+func embeddingModelID() string {
+	return fmt.Sprintf("%s:%d", globalConfig.ModelName, globalConfig.EmbedDimensions)
+}
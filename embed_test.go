@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyEmbedder fails its failAt-th call.
+type flakyEmbedder struct {
+	calls  int
+	failAt int
+}
+
+func (e *flakyEmbedder) Embed(text string, isQuery bool) ([]float32, error) {
+	e.calls++
+	if e.calls == e.failAt {
+		return nil, errors.New("connection reset")
+	}
+	vec := make([]float32, 768)
+	vec[0], vec[1] = 1, float32(e.calls)
+	return vec, nil
+}
+
+func (e *flakyEmbedder) Close() error { return nil }
+
+func TestEmbedChunksRetriesFailedDocuments(t *testing.T) {
+	s, err := store.NewStore(filepath.Join(t.TempDir(), "embed.sqlite"))
+	require.NoError(t, err)
+	defer s.DB.Close()
+	require.NoError(t, s.EnsureVectorTable(768))
+	globalStore, globalConfig = s, config.Default()
+
+	var body strings.Builder
+	for i := 1; i <= 4; i++ {
+		fmt.Fprintf(&body, "## Section %d\n\n%s\n\n", i, strings.Repeat(fmt.Sprintf("Words of section %d. ", i), 40))
+	}
+	require.NoError(t, s.IndexDocument("notes", "big.md", body.String()))
+
+	pending, err := s.GetPendingEmbeddings()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+
+	// A failed chunk leaves the whole document pending
+	embedChunks(&flakyEmbedder{failAt: 2}, pending)
+	retry, err := s.GetPendingEmbeddings()
+	require.NoError(t, err)
+	assert.Len(t, retry, 1)
+	for hash := range retry {
+		vecs, err := s.GetChunkEmbeddings(hash)
+		require.NoError(t, err)
+		assert.Empty(t, vecs)
+	}
+
+	embedder := &flakyEmbedder{}
+	embedChunks(embedder, retry)
+	pending, err = s.GetPendingEmbeddings()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	for hash := range retry {
+		vecs, err := s.GetChunkEmbeddings(hash)
+		require.NoError(t, err)
+		assert.Greater(t, len(vecs), 1)
+		assert.Len(t, vecs, embedder.calls)
+	}
+}
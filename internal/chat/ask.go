@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"context"
+	"strings"
+)
+
+// Answer is the answer to a one-shot question, with the sources it cites
+// and the tool calls made to find them.
+type Answer struct {
+	Question string `json:"question"`
+	Model    string `json:"model"`
+	// Answer is the text of the answer, Footer the list of the cited
+	// sources appended to it.
+	Answer    string             `json:"answer"`
+	Footer    string             `json:"-"`
+	Sources   []Source           `json:"sources"`
+	Retrieved []Source           `json:"retrieved"`
+	ToolCalls []ToolExecutionLog `json:"tool_calls"`
+}
+
+// Ask answers a question without keeping it in the conversation, running
+// the same tool calling loop as Chat.
+func (c *Client) Ask(ctx context.Context, question string, onEvent func(StreamEvent)) (*Answer, error) {
+	defer func(msgs []Message, cites citations) {
+		c.Messages, c.cites = msgs, cites
+	}(c.Messages, c.cites.clone())
+
+	content, logs, err := c.Chat(ctx, question, onEvent)
+	if err != nil {
+		return nil, err
+	}
+	footer := sourcesFooter(c.Sources)
+	a := &Answer{
+		Question:  question,
+		Model:     c.Model,
+		Answer:    strings.TrimSuffix(content, footer),
+		Footer:    footer,
+		Sources:   c.Sources,
+		Retrieved: c.Retrieved,
+		ToolCalls: logs,
+	}
+	// Empty lists rather than null in JSON
+	if a.Sources == nil {
+		a.Sources = []Source{}
+	}
+	if a.Retrieved == nil {
+		a.Retrieved = []Source{}
+	}
+	if a.ToolCalls == nil {
+		a.ToolCalls = []ToolExecutionLog{}
+	}
+	return a, nil
+}
@@ -0,0 +1,47 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/mcpserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsk(t *testing.T) {
+	s := commandStore(t)
+	backend := &scriptedBackend{replies: []Message{
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_0", Function: FunctionCall{Name: "search", Arguments: map[string]interface{}{"query": "kafka"}}}}},
+		{Role: "assistant", Content: "Groups rebalance on join [2]."},
+	}}
+	c := NewClient(backend, mcpserver.NewServer(s, nil, config.Default()))
+	c.Model = "llama3"
+
+	a, err := c.Ask(context.Background(), "When do groups rebalance?", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Groups rebalance on join [2].", a.Answer)
+	assert.Contains(t, a.Footer, "- [2] `")
+	require.Len(t, a.Sources, 1)
+	assert.Equal(t, 2, a.Sources[0].N)
+	assert.Len(t, a.Retrieved, 2)
+	assert.Equal(t, []ToolExecutionLog{{Name: "search", Args: map[string]interface{}{"query": "kafka"}}}, a.ToolCalls)
+
+	// The question is not kept in the conversation
+	assert.Len(t, c.Messages, 1)
+
+	out, err := json.Marshal(a)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"tool_calls":[{"name":"search","args":{"query":"kafka"}}]`)
+	assert.NotContains(t, string(out), "Footer")
+
+	// Without tool calls the lists are empty, not null
+	backend.replies = []Message{{Role: "assistant", Content: "No idea."}}
+	a, err = c.Ask(context.Background(), "Anything?", nil)
+	require.NoError(t, err)
+	out, err = json.Marshal(a)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"sources":[],"retrieved":[],"tool_calls":[]`)
+}
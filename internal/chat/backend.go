@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Chat providers
+const (
+	ProviderOllama = "ollama"
+	ProviderOpenAI = "openai"
+	ProviderLocal  = "local"
+)
+
+// Default settings of the chat providers
+const (
+	DefaultOllamaURL   = "http://127.0.0.1:11434"
+	DefaultOpenAIURL   = "http://127.0.0.1:8080"
+	DefaultContextSize = 8192
+)
+
+// ChatBackend sends a conversation to a model and streams back its answer.
+type ChatBackend interface {
+	// Complete returns the next assistant message, calling onContent with
+	// each chunk of its content as it arrives. Cancelling ctx aborts the
+	// request.
+	Complete(ctx context.Context, messages []Message, tools []ToolDef, onContent func(string)) (Message, error)
+}
+
+// modelSetter is implemented by backends able to switch to another model
+// of their server.
+type modelSetter interface {
+	SetModel(model string)
+}
+
+func (b *OllamaBackend) SetModel(model string) { b.Model = model }
+func (b *OpenAIBackend) SetModel(model string) { b.Model = model }
+
+// SetModel switches the model of the conversation. Local models are loaded
+// at startup and cannot be switched.
+func (c *Client) SetModel(model string) error {
+	ms, ok := c.Backend.(modelSetter)
+	if !ok {
+		return fmt.Errorf("this backend loads its model at startup, restart qmd chat to use another one")
+	}
+	ms.SetModel(model)
+	c.Model = model
+	return nil
+}
+
+// BackendConfig selects and configures a chat backend.
+type BackendConfig struct {
+	Provider string
+	URL      string
+	Model    string
+	// APIKey is sent as a bearer token by the OpenAI-compatible backend.
+	APIKey string
+	// ContextSize is the context window in tokens, requested from Ollama
+	// (num_ctx) and local models. OpenAI-compatible servers set it at
+	// startup, it must match theirs. Conversations are trimmed to fit it.
+	ContextSize int
+	// Temperature overrides the model default if not nil.
+	Temperature *float64
+
+	// ModelPath and LibPath are the GGUF model and the llama.cpp library of
+	// the local backend.
+	ModelPath string
+	LibPath   string
+}
+
+// NewBackend returns the backend of cfg.Provider, defaulting to Ollama.
+func NewBackend(cfg BackendConfig) (ChatBackend, error) {
+	// Answers stream for as long as the model generates, requests are
+	// bounded by their context. Only a server not answering times out.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 300 * time.Second
+	client := &http.Client{Transport: transport}
+	if cfg.ContextSize <= 0 {
+		cfg.ContextSize = DefaultContextSize
+	}
+	switch cfg.Provider {
+	case "", ProviderOllama:
+		if cfg.URL == "" {
+			cfg.URL = DefaultOllamaURL
+		}
+		return &OllamaBackend{
+			BaseURL:     cfg.URL,
+			Model:       cfg.Model,
+			ContextSize: cfg.ContextSize,
+			Temperature: cfg.Temperature,
+			Client:      client,
+		}, nil
+	case ProviderOpenAI:
+		if cfg.URL == "" {
+			cfg.URL = DefaultOpenAIURL
+		}
+		if cfg.APIKey == "" {
+			cfg.APIKey = os.Getenv("OPENAI_API_KEY")
+		}
+		return &OpenAIBackend{
+			BaseURL:     cfg.URL,
+			Model:       cfg.Model,
+			APIKey:      cfg.APIKey,
+			Temperature: cfg.Temperature,
+			ContextSize: cfg.ContextSize,
+			Client:      client,
+		}, nil
+	case ProviderLocal:
+		if cfg.ModelPath == "" {
+			return nil, fmt.Errorf("local chat requires a model path (--model-path)")
+		}
+		if cfg.LibPath == "" {
+			return nil, fmt.Errorf("local chat requires the llama.cpp library path (--lib-path or YZMA_LIB)")
+		}
+		return NewLocalBackend(cfg.ModelPath, cfg.LibPath, cfg.ContextSize, cfg.Temperature)
+	default:
+		return nil, fmt.Errorf("unknown chat provider %q (use %s, %s or %s)", cfg.Provider, ProviderOllama, ProviderOpenAI, ProviderLocal)
+	}
+}
@@ -0,0 +1,197 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/store"
+)
+
+// citePrompt asks the model to cite the sources numbered in tool results.
+const citePrompt = " Cite the notes supporting your answer with the source numbers listed after each tool result, e.g. [1] or [2][3]. Do not write a list of sources, it is added to your answer automatically."
+
+// Source is a document location returned by a tool, numbered for citation.
+type Source struct {
+	N        int    `json:"n"`
+	Filepath string `json:"filepath"`
+	Line     int    `json:"line,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+// String returns the location as "collection/path:line".
+func (s Source) String() string {
+	if s.Line > 0 {
+		return fmt.Sprintf("%s:%d", s.Filepath, s.Line)
+	}
+	return s.Filepath
+}
+
+// sourcesNote starts the note listing the numbered sources of a tool result.
+const sourcesNote = "\n\nSources (cite as [n]): "
+
+// citations numbers the sources of the tool results of a conversation, so
+// that a number designates the same location in all its questions.
+type citations struct {
+	sources []Source
+	index   map[string]int
+	// turn are the sources returned for the current question
+	turn []Source
+}
+
+// newTurn starts collecting the sources of a new question.
+func (c *citations) newTurn() {
+	c.turn = nil
+}
+
+// clone returns a copy of c that add does not modify.
+func (c *citations) clone() citations {
+	index := make(map[string]int, len(c.index))
+	for k, v := range c.index {
+		index[k] = v
+	}
+	return citations{
+		sources: slices.Clone(c.sources),
+		index:   index,
+		turn:    slices.Clone(c.turn),
+	}
+}
+
+// add numbers the sources a tool returned, keeping the number of locations
+// already seen, and returns the note telling the model how to cite them.
+func (c *citations) add(toolName string, args map[string]interface{}, content string) string {
+	found := toolSources(toolName, args, content)
+	if len(found) == 0 {
+		return ""
+	}
+	if c.index == nil {
+		c.index = make(map[string]int)
+	}
+
+	var refs []string
+	for _, s := range found {
+		n, ok := c.index[s.String()]
+		if !ok {
+			n = len(c.sources) + 1
+			s.N = n
+			c.sources = append(c.sources, s)
+			c.index[s.String()] = n
+		}
+		if !slices.ContainsFunc(c.turn, func(t Source) bool { return t.N == n }) {
+			c.turn = append(c.turn, c.sources[n-1])
+		}
+		refs = append(refs, fmt.Sprintf("[%d] %s", n, s))
+	}
+	return sourcesNote + strings.Join(refs, ", ")
+}
+
+// replayCitations numbers the sources of the tool results of a saved
+// conversation again, giving them the numbers the model saw.
+func replayCitations(msgs []Message) citations {
+	var c citations
+	var calls []ToolCall
+	for _, m := range msgs {
+		switch m.Role {
+		case "assistant":
+			calls = m.ToolCalls
+		case "tool":
+			// Results follow the calls of the assistant message in order
+			if len(calls) == 0 {
+				continue
+			}
+			tc := calls[0]
+			calls = calls[1:]
+			i := strings.LastIndex(m.Content, sourcesNote)
+			if i < 0 {
+				continue
+			}
+			args, _ := toolArgs(tc.Function.Arguments)
+			c.add(tc.Function.Name, args, m.Content[:i])
+		}
+	}
+	c.newTurn()
+	return c
+}
+
+// toolSources extracts the document locations of a qmd tool result: search
+// results, or the document read by get_document and get_section.
+func toolSources(toolName string, args map[string]interface{}, content string) []Source {
+	switch toolName {
+	case "search", "vsearch", "query", "similar_documents":
+		// A list of results, or a page of them when an offset was passed
+		var results []store.SearchResultJSON
+		if json.Unmarshal([]byte(content), &results) == nil {
+			return resultSources(results)
+		}
+		var page struct {
+			Results []store.SearchResultJSON `json:"results"`
+		}
+		if json.Unmarshal([]byte(content), &page) != nil {
+			return nil
+		}
+		return resultSources(page.Results)
+
+	case "get_document", "get_section":
+		path, _ := args["path"].(string)
+		var doc struct {
+			StartLine int `json:"start_line"`
+		}
+		if path == "" || json.Unmarshal([]byte(content), &doc) != nil {
+			return nil
+		}
+		return []Source{{Filepath: strings.TrimPrefix(path, "qmd://"), Line: doc.StartLine}}
+	}
+	return nil
+}
+
+func resultSources(results []store.SearchResultJSON) []Source {
+	sources := make([]Source, 0, len(results))
+	for _, r := range results {
+		sources = append(sources, Source{Filepath: r.Filepath, Line: r.Line, Title: r.Title})
+	}
+	return sources
+}
+
+// citeRe matches citations like [1], [1, 2] or [1,2,3].
+var citeRe = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// citedSources returns the sources an answer cites, in number order.
+func citedSources(answer string, sources []Source) []Source {
+	cited := make(map[int]bool)
+	for _, m := range citeRe.FindAllStringSubmatch(answer, -1) {
+		for _, part := range strings.Split(m[1], ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+				cited[n] = true
+			}
+		}
+	}
+
+	var out []Source
+	for _, s := range sources {
+		if cited[s.N] {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].N < out[j].N })
+	return out
+}
+
+// sourcesFooter formats the cited sources appended to an answer.
+func sourcesFooter(cited []Source) string {
+	if len(cited) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\n**Sources**\n")
+	for _, s := range cited {
+		fmt.Fprintf(&b, "\n- [%d] `%s`", s.N, s)
+		if s.Title != "" {
+			fmt.Fprintf(&b, " %s", s.Title)
+		}
+	}
+	return b.String()
+}
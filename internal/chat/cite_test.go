@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/mcpserver"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCitations(t *testing.T) {
+	var c citations
+	note := c.add("query", nil, `[{"filepath":"notes/kafka.md","title":"Kafka","line":12},{"filepath":"notes/pg.md","title":"Postgres"}]`)
+	assert.Equal(t, "\n\nSources (cite as [n]): [1] notes/kafka.md:12, [2] notes/pg.md", note)
+
+	// Known locations keep their number
+	note = c.add("get_section", map[string]interface{}{"path": "notes/kafka.md"}, `{"start_line":12,"end_line":20}`)
+	assert.Equal(t, "\n\nSources (cite as [n]): [1] notes/kafka.md:12", note)
+	note = c.add("get_document", map[string]interface{}{"path": "qmd://notes/ops.md"}, `{"path":"notes/ops.md","start_line":1}`)
+	assert.Equal(t, "\n\nSources (cite as [n]): [3] notes/ops.md:1", note)
+
+	note = c.add("similar_documents", nil, `{"results":[{"filepath":"notes/pg.md","title":"Postgres"}],"offset":0,"total_estimate":1}`)
+	assert.Equal(t, "\n\nSources (cite as [n]): [2] notes/pg.md", note)
+
+	assert.Empty(t, c.add("list_tags", nil, `[{"tag":"kafka","count":1}]`))
+	assert.Empty(t, c.add("query", nil, "not json"))
+	require.Len(t, c.sources, 3)
+
+	cited := citedSources("Groups rebalance [3], see also [1, 2] and [9].", c.sources)
+	require.Len(t, cited, 3)
+	assert.Equal(t, []int{1, 2, 3}, []int{cited[0].N, cited[1].N, cited[2].N})
+
+	footer := sourcesFooter(cited)
+	assert.Contains(t, footer, "- [1] `notes/kafka.md:12` Kafka")
+	assert.Contains(t, footer, "- [3] `notes/ops.md:1`")
+	assert.Empty(t, sourcesFooter(nil))
+
+	parsed := footerSources("Answer [1]." + footer)
+	require.Len(t, parsed, 3)
+	assert.Equal(t, Source{N: 1, Filepath: "notes/kafka.md", Line: 12}, parsed[0])
+	assert.Equal(t, Source{N: 2, Filepath: "notes/pg.md"}, parsed[1])
+}
+
+func TestChatCitesToolResults(t *testing.T) {
+	s, err := store.NewStore(filepath.Join(t.TempDir(), "cite.sqlite"))
+	require.NoError(t, err)
+	defer s.DB.Close()
+	require.NoError(t, s.IndexDocument("notes", "kafka.md", "# Kafka\n\nIntro\n\nConsumer groups rebalance on join.\n"))
+
+	backend := &scriptedBackend{replies: []Message{
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_0", Function: FunctionCall{Name: "search", Arguments: map[string]interface{}{"query": "rebalance"}}}}},
+		{Role: "assistant", Content: "Groups rebalance when a consumer joins [1]."},
+	}}
+	c := NewClient(backend, mcpserver.NewServer(s, nil, config.Default()))
+
+	answer, _, err := c.Chat(context.Background(), "When do groups rebalance?", nil)
+	require.NoError(t, err)
+
+	assert.Contains(t, c.Messages[0].Content, "source numbers")
+	assert.Contains(t, c.Messages[3].Content, "Sources (cite as [n]): [1] notes/kafka.md:5")
+	require.Len(t, c.Sources, 1)
+	assert.Equal(t, Source{N: 1, Filepath: "notes/kafka.md", Line: 5, Title: "Kafka"}, c.Sources[0])
+	assert.Contains(t, answer, "- [1] `notes/kafka.md:5` Kafka")
+	assert.Equal(t, answer, c.Messages[len(c.Messages)-1].Content)
+}
+
+func TestCitationsAcrossQuestions(t *testing.T) {
+	s := commandStore(t)
+	search := func(id, query string) Message {
+		return Message{Role: "assistant", ToolCalls: []ToolCall{{ID: id, Function: FunctionCall{Name: "search", Arguments: map[string]interface{}{"query": query}}}}}
+	}
+	backend := &scriptedBackend{replies: []Message{
+		search("call_0", "pods"),
+		{Role: "assistant", Content: "Pods run on nodes [1]."},
+		search("call_1", "rebalance"),
+		{Role: "assistant", Content: "Groups rebalance [2], unlike pods [1]."},
+		search("call_2", "pods"),
+		{Role: "assistant", Content: "Still nodes [1]."},
+	}}
+	c := NewClient(backend, mcpserver.NewServer(s, nil, config.Default()))
+	c.Store = s
+
+	_, _, err := c.Chat(context.Background(), "Where do pods run?", nil)
+	require.NoError(t, err)
+	answer, _, err := c.Chat(context.Background(), "And consumer groups?", nil)
+	require.NoError(t, err)
+
+	// The numbers of the first question still designate the same documents
+	assert.NotContains(t, c.Messages[len(c.Messages)-2].Content, "notes/k8s.md")
+	assert.Contains(t, answer, "- [1] `notes/k8s.md:3` Kubernetes")
+	require.Len(t, c.Sources, 2)
+	assert.Equal(t, []int{1, 2}, []int{c.Sources[0].N, c.Sources[1].N})
+	for _, r := range c.Retrieved {
+		assert.NotEqual(t, 1, r.N, "sources of earlier questions are not retrieved again")
+	}
+
+	// A resumed conversation keeps the numbering
+	require.NoError(t, c.Save())
+	r := NewClient(backend, mcpserver.NewServer(s, nil, config.Default()))
+	r.Store = s
+	require.NoError(t, r.Resume(c.SessionID))
+	_, _, err = r.Chat(context.Background(), "Pods again?", nil)
+	require.NoError(t, err)
+	assert.Contains(t, r.Messages[len(r.Messages)-2].Content, "Sources (cite as [n]): [1] notes/k8s.md:3")
+	require.Len(t, r.Sources, 1)
+	assert.Equal(t, "notes/k8s.md", r.Sources[0].Filepath)
+
+	r.Reset()
+	assert.Empty(t, r.cites.sources)
+}
@@ -1,29 +1,60 @@
 package chat
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"time"
+	"strings"
 
 	"github.com/akhenakh/qmd/internal/mcpserver"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/akhenakh/qmd/internal/util"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
 const maxTurns = 15
 
-type OllamaClient struct {
-	BaseURL  string
-	Model    string
+// cancelledNote ends the answer of a cancelled request.
+const cancelledNote = "(Cancelled)"
+
+const systemPrompt = "You are a helpful assistant with access to a knowledge base of markdown notes. Use 'query' for most questions. Search results include a 'full_file_returned' field; if true, the 'snippet' contains the complete file content, so do not call 'get_document' for that file. Always answer based on the retrieved context." + citePrompt
+
+// Client runs a conversation with a chat backend, executing the tool calls of
+// the model against the MCP server.
+type Client struct {
+	Backend  ChatBackend
 	MCP      *mcpserver.Server
 	Messages []Message
 	Tools    []ToolDef
-	Client   *http.Client
+
+	// External are the external MCP servers offering the tools of Tools
+	// not served by MCP.
+	External []*ExternalServer
+
+	// Sources are the sources cited by the last answer, Retrieved all the
+	// sources its tools returned.
+	Sources   []Source
+	Retrieved []Source
+
+	// Store saves the conversation when not nil, as SessionID once created.
+	// Provider and Model are recorded with it.
+	Store     *store.Store
+	SessionID int64
+	Provider  string
+	Model     string
+
+	// Collection scopes the tool calls to one collection if not empty.
+	Collection string
+
+	// cites numbers the sources of the conversation
+	cites citations
+
+	// saved is the number of messages already stored
+	saved int
 }
 
-func NewOllamaClient(url, model string, mcp *mcpserver.Server) *OllamaClient {
+func NewClient(backend ChatBackend, mcp *mcpserver.Server) *Client {
 	var ollamaTools []ToolDef
 	for _, t := range mcp.GetTools() {
 		ollamaTools = append(ollamaTools, ToolDef{
@@ -36,65 +67,58 @@ func NewOllamaClient(url, model string, mcp *mcpserver.Server) *OllamaClient {
 		})
 	}
 
-	return &OllamaClient{
-		BaseURL: url,
-		Model:   model,
+	return &Client{
+		Backend: backend,
 		MCP:     mcp,
 		Tools:   ollamaTools,
-		Client:  &http.Client{Timeout: 300 * time.Second},
 		Messages: []Message{
-			{Role: "system", Content: "You are a helpful assistant with access to a knowledge base of markdown notes. Use 'query' for most questions. Search results include a 'full_file_returned' field; if true, the 'snippet' contains the complete file content, so do not call 'get_document' for that file. Always answer based on the retrieved context."},
+			{Role: "system", Content: systemPrompt},
 		},
 	}
 }
 
-// Chat returns content, a list of tools executed, and error
-func (c *OllamaClient) Chat(userPrompt string) (string, []ToolExecutionLog, error) {
+// Chat returns content, a list of tools executed, and error. The answer is
+// streamed: onEvent, if not nil, receives the content chunks and tool calls
+// as they arrive. Cancelling ctx aborts the request: the error is then the
+// one of ctx, with the partial answer and the tools executed so far, which
+// stay in the conversation.
+func (c *Client) Chat(ctx context.Context, userPrompt string, onEvent func(StreamEvent)) (string, []ToolExecutionLog, error) {
+	if onEvent == nil {
+		onEvent = func(StreamEvent) {}
+	}
+
 	// 1. Append User Message
 	c.Messages = append(c.Messages, Message{Role: "user", Content: userPrompt})
 
 	var finalContent string
 	var toolCallsMade bool
 	var executionLogs []ToolExecutionLog
+	c.cites.newTurn()
+	c.Sources, c.Retrieved = nil, nil
+	budget, window := c.contextBudget()
+	warned := false
 
 	// Max turns loop
 	for i := 0; i < maxTurns; i++ {
-		reqBody := ChatRequest{
-			Model:    c.Model,
-			Messages: c.Messages,
-			Stream:   false,
-			Tools:    c.Tools,
-			Options: map[string]interface{}{
-				"num_ctx": 8192,
-			},
+		// The whole conversation is kept, only the part fitting the
+		// context window is sent
+		messages, report := fitContext(c.Messages, budget)
+		if !warned && (report.Dropped > 0 || report.Truncated > 0 || report.Tokens > budget) {
+			warned = true
+			util.Debug("Chat context: %d/%d tokens, dropped %d questions, shortened %d tool results", report.Tokens, budget, report.Dropped, report.Truncated)
+			onEvent(StreamEvent{Warning: contextWarning(report, budget, window)})
 		}
 
-		jsonBody, err := json.Marshal(reqBody)
-		if err != nil {
-			return "", nil, fmt.Errorf("marshal error: %w", err)
+		var partial strings.Builder
+		respMessage, err := c.Backend.Complete(ctx, messages, c.Tools, func(content string) {
+			partial.WriteString(content)
+			onEvent(StreamEvent{Content: content})
+		})
+		if ctx.Err() != nil {
+			return c.cancelTurn(partial.String(), executionLogs, ctx.Err())
 		}
-
-		resp, err := c.Client.Post(c.BaseURL+"/api/chat", "application/json", bytes.NewBuffer(jsonBody))
 		if err != nil {
-			return "", nil, fmt.Errorf("network error: %w", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			return "", nil, fmt.Errorf("ollama API error: %s", resp.Status)
-		}
-
-		var chatResp ChatResponse
-		if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-			return "", nil, fmt.Errorf("decode error: %w", err)
-		}
-
-		// Normalize Response
-		var respMessage Message
-		if len(chatResp.Choices) > 0 {
-			respMessage = chatResp.Choices[0].Message
-		} else {
-			respMessage = chatResp.Message
+			return "", nil, err
 		}
 
 		// 2. Append Assistant Response
@@ -110,57 +134,58 @@ func (c *OllamaClient) Chat(userPrompt string) (string, []ToolExecutionLog, erro
 				} else {
 					finalContent = "(Model returned empty response)"
 				}
-				c.Messages[len(c.Messages)-1].Content = finalContent
 			}
 
+			// Footer listing the sources the answer cites
+			c.Sources = citedSources(finalContent, c.cites.sources)
+			c.Retrieved = c.cites.turn
+			finalContent += sourcesFooter(c.Sources)
+			c.Messages[len(c.Messages)-1].Content = finalContent
+
 			return finalContent, executionLogs, nil
 		}
 
 		// 3. Handle Tool Calls
 		toolCallsMade = true
-		for _, tc := range respMessage.ToolCalls {
-			// Parse Arguments
-			var args map[string]interface{}
-			switch v := tc.Function.Arguments.(type) {
-			case string:
-				if err := json.Unmarshal([]byte(v), &args); err != nil {
-					errMsg := fmt.Sprintf("Error parsing arguments JSON: %v", err)
+		for j, tc := range respMessage.ToolCalls {
+			// Every call gets a result, also when cancelled
+			if ctx.Err() != nil {
+				for _, skipped := range respMessage.ToolCalls[j:] {
 					c.Messages = append(c.Messages, Message{
 						Role:       "tool",
-						Content:    errMsg,
-						Name:       tc.Function.Name,
-						ToolCallID: tc.ID,
+						Content:    "Cancelled by the user.",
+						Name:       skipped.Function.Name,
+						ToolCallID: skipped.ID,
 					})
-					continue
 				}
-			case map[string]interface{}:
-				args = v
-			default:
-				args = make(map[string]interface{})
+				return c.cancelTurn("", executionLogs, ctx.Err())
+			}
+
+			// Parse Arguments
+			args, err := toolArgs(tc.Function.Arguments)
+			if err != nil {
+				errMsg := fmt.Sprintf("Error parsing arguments JSON: %v", err)
+				c.Messages = append(c.Messages, Message{
+					Role:       "tool",
+					Content:    errMsg,
+					Name:       tc.Function.Name,
+					ToolCallID: tc.ID,
+				})
+				continue
 			}
 
 			// Log execution for UI
-			executionLogs = append(executionLogs, ToolExecutionLog{
+			execLog := ToolExecutionLog{
 				Name: tc.Function.Name,
 				Args: args,
-			})
-
-			// Execute Tool
-			res, err := c.MCP.CallTool(context.Background(), tc.Function.Name, args)
-
-			content := ""
-			if err != nil {
-				content = fmt.Sprintf("Error executing tool %s: %v", tc.Function.Name, err)
-			} else {
-				for _, r := range res.Content {
-					if txt, ok := r.(mcp.TextContent); ok {
-						content += txt.Text
-					}
-				}
 			}
+			executionLogs = append(executionLogs, execLog)
+			onEvent(StreamEvent{Tool: &execLog})
 
-			if content == "" {
-				content = "Tool executed successfully but returned no output."
+			// Execute Tool
+			content, ok := c.callTool(ctx, tc.Function.Name, args)
+			if ok {
+				content += c.cites.add(tc.Function.Name, args, content)
 			}
 
 			// Append Tool Result
@@ -182,3 +207,106 @@ func (c *OllamaClient) Chat(userPrompt string) (string, []ToolExecutionLog, erro
 
 	return "", nil, fmt.Errorf("unexpected chat state")
 }
+
+// toolArgs decodes the arguments of a tool call, sent as a JSON string by
+// OpenAI-compatible servers and as an object by Ollama.
+func toolArgs(arguments interface{}) (map[string]interface{}, error) {
+	switch v := arguments.(type) {
+	case string:
+		var args map[string]interface{}
+		if err := json.Unmarshal([]byte(v), &args); err != nil {
+			return nil, err
+		}
+		return args, nil
+	case map[string]interface{}:
+		return v, nil
+	}
+	return make(map[string]interface{}), nil
+}
+
+// cancelTurn ends a cancelled request with the partial answer, so that the
+// conversation can go on.
+func (c *Client) cancelTurn(partial string, logs []ToolExecutionLog, err error) (string, []ToolExecutionLog, error) {
+	util.Debug("Chat cancelled after %d tool calls, %d bytes of answer", len(logs), len(partial))
+	content := strings.TrimSpace(partial + "\n\n" + cancelledNote)
+	c.Messages = append(c.Messages, Message{Role: "assistant", Content: content})
+	return content, logs, err
+}
+
+// callTool executes a tool call and returns its text result, with ok false
+// if the call failed.
+func (c *Client) callTool(ctx context.Context, name string, args map[string]interface{}) (content string, ok bool) {
+	var res *mcp.CallToolResult
+	var err error
+	if srv, tool := c.externalTool(name); srv != nil {
+		res, err = srv.CallTool(ctx, tool, args)
+	} else {
+		if err := c.scope(name, args); err != nil {
+			return err.Error(), false
+		}
+		res, err = c.MCP.CallTool(ctx, name, args)
+	}
+	if err != nil {
+		return fmt.Sprintf("Error executing tool %s: %v", name, err), false
+	}
+	for _, r := range res.Content {
+		if txt, ok := r.(mcp.TextContent); ok {
+			content += txt.Text
+		}
+	}
+	if content == "" {
+		content = "Tool executed successfully but returned no output."
+	}
+	return content, !res.IsError
+}
+
+// scope restricts a tool call to the collection of the client: the
+// collection is set on the tools filtering by collection, and documents of
+// other collections are refused.
+func (c *Client) scope(name string, args map[string]interface{}) error {
+	if c.Collection == "" {
+		return nil
+	}
+	for _, t := range c.Tools {
+		if t.Function.Name != name {
+			continue
+		}
+		schema, ok := t.Function.Parameters.(mcp.ToolInputSchema)
+		if !ok {
+			return nil
+		}
+		if _, ok := schema.Properties["collection"]; ok {
+			args["collection"] = c.Collection
+		}
+		if path, ok := args["path"].(string); ok {
+			collection, _, _ := strings.Cut(strings.TrimPrefix(path, "qmd://"), "/")
+			if collection != c.Collection {
+				return fmt.Errorf("document %s is outside of collection %s, which this conversation is limited to", path, c.Collection)
+			}
+		}
+	}
+	return nil
+}
+
+// RunTool executes a tool on behalf of the user and adds the call and its
+// result to the conversation, as context for the next questions.
+func (c *Client) RunTool(ctx context.Context, name string, args map[string]interface{}) (string, error) {
+	content, ok := c.callTool(ctx, name, args)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if !ok {
+		return "", errors.New(content)
+	}
+
+	id := fmt.Sprintf("%s%d", userCallPrefix, len(c.Messages))
+	c.Messages = append(c.Messages,
+		Message{Role: "assistant", ToolCalls: []ToolCall{{
+			ID:       id,
+			Type:     "function",
+			Function: FunctionCall{Name: name, Arguments: args},
+		}}},
+		Message{Role: "tool", Content: content, Name: name, ToolCallID: id},
+	)
+	return content, nil
+}
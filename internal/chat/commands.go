@@ -0,0 +1,330 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxCompletions is the number of completion candidates listed.
+const maxCompletions = 8
+
+// userCallPrefix starts the ID of the tool calls run with a slash command.
+const userCallPrefix = "user_call_"
+
+// slashCommand describes a command of the chat input.
+type slashCommand struct {
+	name string
+	args string
+	help string
+}
+
+var slashCommands = []slashCommand{
+	{"/search", "<keywords>", "Search the notes by keywords and add the results to the context"},
+	{"/query", "<question>", "Search the notes (keywords and meaning) and add the results to the context"},
+	{"/get", "<collection/path>", "Add a document to the context"},
+	{"/model", "[name]", "Show the model, or switch to another one"},
+	{"/system", "[prompt|default]", "Replace the system prompt, alone it puts the current one in the input to edit"},
+	{"/collection", "[name]", "Limit the searches to a collection, alone it removes the limit"},
+	{"/clear", "", "Clear the screen, the conversation goes on"},
+	{"/new", "", "Start a new conversation"},
+	{"/save", "[file.md|file.json]", "Save the conversation, or export it to a file"},
+	{"/help", "", "List the commands"},
+}
+
+// toolResultMsg reports the result of a tool run with a slash command.
+type toolResultMsg struct {
+	name    string
+	args    map[string]interface{}
+	content string
+	err     error
+	saveErr error
+}
+
+// runCommand executes a slash command.
+func (m model) runCommand(input string) (tea.Model, tea.Cmd) {
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+	m.textInput.Reset()
+
+	switch name {
+	case "/search", "/query":
+		if arg == "" {
+			return m.status(fmt.Sprintf("Usage: %s <query>", name))
+		}
+		return m.runTool(strings.TrimPrefix(name, "/"), map[string]interface{}{"query": arg})
+
+	case "/get":
+		if arg == "" {
+			return m.status("Usage: /get <collection/path>")
+		}
+		return m.runTool("get_document", map[string]interface{}{"path": arg})
+
+	case "/model":
+		if arg == "" {
+			return m.status(fmt.Sprintf("Model: %s", m.client.Model))
+		}
+		if err := m.client.SetModel(arg); err != nil {
+			return m.status(err.Error())
+		}
+		return m.status(fmt.Sprintf("Switched to model %s", arg))
+
+	case "/system":
+		switch arg {
+		case "":
+			m.setInput("/system " + m.client.SystemPrompt())
+			return m, nil
+		case "default":
+			arg = ""
+		}
+		if err := m.client.SetSystemPrompt(arg); err != nil {
+			return m.status(fmt.Sprintf("Unable to save the system prompt: %v", err))
+		}
+		return m.status("System prompt updated")
+
+	case "/collection":
+		if arg == "" {
+			m.client.Collection = ""
+			return m.status("Searching all collections")
+		}
+		if len(m.collections) > 0 && !slices.Contains(m.collections, arg) {
+			return m.status(fmt.Sprintf("Unknown collection %q (%s)", arg, strings.Join(m.collections, ", ")))
+		}
+		m.client.Collection = arg
+		return m.status(fmt.Sprintf("Searching collection %s only", arg))
+
+	case "/clear":
+		m.messages = nil
+		m.rebuildView()
+		return m, nil
+
+	case "/new":
+		m.client.Reset()
+		m.messages = nil
+		m.lastAnswer = ""
+		m.sources = nil
+		m.rebuildView()
+		return m.status("Started a new conversation")
+
+	case "/save":
+		if err := m.client.Save(); err != nil {
+			return m.status(fmt.Sprintf("Unable to save the conversation: %v", err))
+		}
+		if m.client.SessionID == 0 {
+			return m.status("Nothing to save before the first question")
+		}
+		if arg == "" {
+			return m.status(fmt.Sprintf("Conversation saved as %d", m.client.SessionID))
+		}
+		if err := m.exportTo(arg); err != nil {
+			return m.status(fmt.Sprintf("Unable to export the conversation: %v", err))
+		}
+		return m.status(fmt.Sprintf("Conversation exported to %s", arg))
+
+	case "/help":
+		msg := ChatMessage{Role: "Help", Text: commandHelp(), Markdown: true}
+		m.messages = append(m.messages, msg)
+		m.appendView(msg)
+		return m, nil
+	}
+	return m.status(fmt.Sprintf("Unknown command %s, /help lists the commands", name))
+}
+
+// status shows a message in the status line for a few seconds.
+func (m model) status(text string) (tea.Model, tea.Cmd) {
+	m.statusMsg = text
+	return m, tea.Tick(time.Second*3, func(_ time.Time) tea.Msg { return statusClearMsg{} })
+}
+
+// runTool executes a tool in the background, its result is added to the
+// conversation as context for the next questions.
+func (m model) runTool(name string, args map[string]interface{}) (tea.Model, tea.Cmd) {
+	ctx := m.startRequest()
+	m.statusMsg = ""
+	m.runningCmd = name
+	client := m.client
+	run := func() tea.Msg {
+		content, err := client.RunTool(ctx, name, args)
+		msg := toolResultMsg{name: name, args: args, content: content, err: err}
+		if err == nil {
+			msg.saveErr = client.Save()
+		}
+		return msg
+	}
+	return m, tea.Batch(m.spinner.Tick, run)
+}
+
+// toolResult shows the result of a tool run with a slash command.
+func (m model) toolResult(msg toolResultMsg) (tea.Model, tea.Cmd) {
+	m.endRequest()
+	m.runningCmd = ""
+	if errors.Is(msg.err, context.Canceled) {
+		return m.status(fmt.Sprintf("%s cancelled", msg.name))
+	}
+
+	var out ChatMessage
+	if msg.err != nil {
+		out = ChatMessage{Role: "Error", Text: msg.err.Error(), IsError: true}
+	} else {
+		out = contextMessage(msg.name, msg.args, msg.content)
+	}
+	m.messages = append(m.messages, out)
+	m.appendView(out)
+	if msg.saveErr != nil {
+		m.statusMsg = fmt.Sprintf("Unable to save the conversation: %v", msg.saveErr)
+	}
+	return m, nil
+}
+
+// contextMessage summarizes a tool result added to the context.
+func contextMessage(name string, args map[string]interface{}, content string) ChatMessage {
+	var b strings.Builder
+	switch name {
+	case "get_document":
+		var doc struct {
+			Path       string `json:"path"`
+			StartLine  int    `json:"start_line"`
+			EndLine    int    `json:"end_line"`
+			TotalLines int    `json:"total_lines"`
+		}
+		json.Unmarshal([]byte(content), &doc)
+		fmt.Fprintf(&b, "Added `%s` (lines %d-%d of %d) to the context.", doc.Path, doc.StartLine, doc.EndLine, doc.TotalLines)
+	default:
+		sources := toolSources(name, args, content)
+		fmt.Fprintf(&b, "Added the %s results for `%v` to the context:\n", name, args["query"])
+		for _, s := range sources {
+			fmt.Fprintf(&b, "\n- `%s` %s", s, s.Title)
+		}
+	}
+	return ChatMessage{Role: "Context", Text: b.String(), Markdown: true}
+}
+
+// commandHelp lists the slash commands as markdown.
+func commandHelp() string {
+	var b strings.Builder
+	b.WriteString("| Command | Description |\n| --- | --- |\n")
+	for _, c := range slashCommands {
+		fmt.Fprintf(&b, "| `%s` | %s |\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+	b.WriteString("\nTab completes commands, collections and document paths.")
+	return b.String()
+}
+
+// exportTo writes the saved conversation to a file, as JSON for a .json
+// file and markdown otherwise.
+func (m model) exportTo(path string) error {
+	format := ExportMarkdown
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = ExportJSON
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Export(f, m.client.Store, m.client.SessionID, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// complete completes the command, collection or document path being typed.
+func (m model) complete() (tea.Model, tea.Cmd) {
+	input := m.textInput.Value()
+	if !strings.HasPrefix(input, "/") {
+		return m, nil
+	}
+
+	name, arg, hasArg := strings.Cut(input, " ")
+	var candidates []string
+	prefix := name + " "
+	switch {
+	case !hasArg:
+		for _, c := range slashCommands {
+			if strings.HasPrefix(c.name, name) {
+				candidates = append(candidates, c.name+" ")
+			}
+		}
+		prefix = ""
+	case name == "/collection":
+		for _, c := range m.collections {
+			if strings.HasPrefix(c, arg) {
+				candidates = append(candidates, c)
+			}
+		}
+	case name == "/get":
+		candidates = m.documentCompletions(arg)
+	default:
+		return m, nil
+	}
+
+	switch len(candidates) {
+	case 0:
+		return m, nil
+	case 1:
+		m.setInput(prefix + candidates[0])
+		return m, nil
+	}
+	m.setInput(prefix + commonPrefix(candidates))
+	var shown []string
+	for _, c := range candidates[:min(len(candidates), maxCompletions)] {
+		shown = append(shown, strings.TrimSpace(c))
+	}
+	if len(candidates) > maxCompletions {
+		shown = append(shown, fmt.Sprintf("… %d more", len(candidates)-maxCompletions))
+	}
+	m.statusMsg = strings.Join(shown, "  ")
+	return m, nil
+}
+
+// documentCompletions returns the collections, then the documents of a
+// collection, whose name starts with arg.
+func (m model) documentCompletions(arg string) []string {
+	collection, path, ok := strings.Cut(arg, "/")
+	if !ok {
+		var out []string
+		for _, c := range m.collections {
+			if strings.HasPrefix(c, arg) {
+				out = append(out, c+"/")
+			}
+		}
+		return out
+	}
+	if m.client.Store == nil {
+		return nil
+	}
+	docs, err := m.client.Store.ListDocuments(collection, path)
+	if err != nil {
+		return nil
+	}
+	out := make([]string, 0, len(docs))
+	for _, d := range docs {
+		out = append(out, d.Collection+"/"+d.Path)
+	}
+	return out
+}
+
+// commonPrefix returns the longest prefix shared by the strings.
+func commonPrefix(strs []string) string {
+	sorted := append([]string(nil), strs...)
+	sort.Strings(sorted)
+	first, last := sorted[0], sorted[len(sorted)-1]
+	i := 0
+	for i < len(first) && i < len(last) && first[i] == last[i] {
+		i++
+	}
+	for i > 0 && i < len(first) && !utf8.RuneStart(first[i]) {
+		i--
+	}
+	return first[:i]
+}
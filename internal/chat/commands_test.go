@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/mcpserver"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commandStore(t *testing.T) *store.Store {
+	s, err := store.NewStore(filepath.Join(t.TempDir(), "commands.sqlite"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.DB.Close() })
+	require.NoError(t, s.IndexDocument("notes", "kafka.md", "# Kafka\n\nConsumer groups rebalance on join.\n"))
+	require.NoError(t, s.IndexDocument("notes", "k8s.md", "# Kubernetes\n\nPods are scheduled on nodes.\n"))
+	require.NoError(t, s.IndexDocument("work", "kafka.md", "# Kafka at work\n\nOur consumer groups rebalance nightly.\n"))
+	return s
+}
+
+func TestRunToolScoped(t *testing.T) {
+	s := commandStore(t)
+	backend := &scriptedBackend{replies: []Message{{Role: "assistant", Content: "They rebalance on join [1]."}}}
+	c := NewClient(backend, mcpserver.NewServer(s, nil, config.Default()))
+	c.Store = s
+	c.Collection = "notes"
+
+	content, err := c.RunTool(context.Background(), "search", map[string]interface{}{"query": "rebalance"})
+	require.NoError(t, err)
+	assert.Contains(t, content, "notes/kafka.md")
+	assert.NotContains(t, content, "work/kafka.md")
+
+	// The call and its result become context for the next question
+	require.Len(t, c.Messages, 3)
+	assert.Equal(t, "search", c.Messages[1].ToolCalls[0].Function.Name)
+	assert.Equal(t, c.Messages[1].ToolCalls[0].ID, c.Messages[2].ToolCallID)
+	assert.Equal(t, content, c.Messages[2].Content)
+
+	_, err = c.RunTool(context.Background(), "get_document", map[string]interface{}{"path": "work/kafka.md"})
+	assert.ErrorContains(t, err, "outside of collection notes")
+	assert.Len(t, c.Messages, 3)
+
+	_, _, err = c.Chat(context.Background(), "When do groups rebalance?", nil)
+	require.NoError(t, err)
+	require.NoError(t, c.Save())
+
+	// The system prompt is replaced in the saved conversation too
+	require.NoError(t, c.SetSystemPrompt("Answer in French."))
+	r := NewClient(backend, c.MCP)
+	r.Store = s
+	require.NoError(t, r.Resume(c.SessionID))
+	assert.Equal(t, "Answer in French.", r.SystemPrompt())
+	r.Reset()
+	assert.Equal(t, "Answer in French.", r.SystemPrompt())
+	require.NoError(t, r.SetSystemPrompt(""))
+	assert.Equal(t, systemPrompt, r.SystemPrompt())
+
+	history := historyMessages(c.Messages)
+	require.Len(t, history, 3)
+	assert.Equal(t, "Context", history[0].Role)
+	assert.Contains(t, history[0].Text, "`notes/kafka.md:3` Kafka")
+	assert.Equal(t, "You", history[1].Role)
+	assert.Empty(t, history[2].ToolLogs)
+}
+
+func TestSetModel(t *testing.T) {
+	c := NewClient(&OllamaBackend{Model: "llama3"}, &mcpserver.Server{})
+	require.NoError(t, c.SetModel("qwen3"))
+	assert.Equal(t, "qwen3", c.Backend.(*OllamaBackend).Model)
+	assert.Equal(t, "qwen3", c.Model)
+
+	c = NewClient(&scriptedBackend{}, &mcpserver.Server{})
+	assert.Error(t, c.SetModel("qwen3"))
+}
+
+func TestComplete(t *testing.T) {
+	s := commandStore(t)
+	c := NewClient(&scriptedBackend{}, &mcpserver.Server{})
+	c.Store = s
+	m := initialModel(c, nil, []string{"notes", "work"})
+
+	complete := func(input string) model {
+		m.setInput(input)
+		next, _ := m.complete()
+		return next.(model)
+	}
+
+	assert.Equal(t, "/collection ", complete("/co").textInput.Value())
+	assert.Equal(t, "/collection work", complete("/collection w").textInput.Value())
+	assert.Equal(t, "/get notes/", complete("/get n").textInput.Value())
+	assert.Equal(t, "/get notes/kafka.md", complete("/get notes/ka").textInput.Value())
+	assert.Equal(t, "plain text", complete("plain text").textInput.Value())
+
+	// Several candidates complete their common prefix and are listed
+	res := complete("/s")
+	assert.Equal(t, "/s", res.textInput.Value())
+	assert.Equal(t, "/search  /system  /save", res.statusMsg)
+	res = complete("/get notes/k")
+	assert.Equal(t, "/get notes/k", res.textInput.Value())
+	assert.Equal(t, "notes/k8s.md  notes/kafka.md", res.statusMsg)
+}
+
+func TestRunCommand(t *testing.T) {
+	c := NewClient(&OllamaBackend{Model: "llama3"}, &mcpserver.Server{})
+	m := initialModel(c, nil, []string{"notes"})
+
+	run := func(input string) model {
+		next, _ := m.runCommand(input)
+		m = next.(model)
+		return m
+	}
+
+	assert.Equal(t, "Switched to model qwen3", run("/model qwen3").statusMsg)
+	assert.Contains(t, run("/collection work").statusMsg, "Unknown collection")
+	run("/collection notes")
+	assert.Equal(t, "notes", c.Collection)
+	run("/collection")
+	assert.Empty(t, c.Collection)
+
+	assert.Equal(t, "/system "+systemPrompt, run("/system").textInput.Value())
+	run("/system Be brief.")
+	assert.Equal(t, "Be brief.", c.SystemPrompt())
+
+	assert.Equal(t, "Help", run("/help").messages[0].Role)
+	assert.Empty(t, run("/clear").messages)
+	assert.Contains(t, run("/nope").statusMsg, "Unknown command")
+	assert.Equal(t, "Usage: /search <query>", run("/search").statusMsg)
+}
@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Token estimates are approximate: about 4 bytes of English text per token,
+// plus the per message formatting of chat templates.
+const (
+	bytesPerToken   = 4
+	messageOverhead = 4
+
+	// maxAnswerReserve caps the part of the context window kept free for
+	// the answer.
+	maxAnswerReserve = 2048
+	// truncatedToolBytes is the size old tool results are shortened to.
+	truncatedToolBytes = 600
+)
+
+// contextWindow is implemented by backends knowing the size of their
+// context window in tokens.
+type contextWindow interface {
+	ContextWindow() int
+}
+
+func (b *OllamaBackend) ContextWindow() int { return b.ContextSize }
+func (b *OpenAIBackend) ContextWindow() int { return b.ContextSize }
+func (b *LocalBackend) ContextWindow() int  { return b.Model.NCtx }
+
+// estimateTokens returns the approximate number of tokens of s.
+func estimateTokens(s string) int {
+	return (len(s) + bytesPerToken - 1) / bytesPerToken
+}
+
+// messageTokens returns the approximate number of tokens of a message.
+func messageTokens(m Message) int {
+	n := messageOverhead + estimateTokens(m.Content)
+	for _, tc := range m.ToolCalls {
+		args, _ := json.Marshal(tc.Function.Arguments)
+		n += estimateTokens(tc.Function.Name) + estimateTokens(string(args))
+	}
+	return n
+}
+
+func messagesTokens(msgs []Message) int {
+	n := 0
+	for _, m := range msgs {
+		n += messageTokens(m)
+	}
+	return n
+}
+
+// contextBudget returns the number of tokens the messages sent to the model
+// may use: the context window minus the tool definitions, sent with every
+// request, and room for the answer.
+func (c *Client) contextBudget() (budget, window int) {
+	window = DefaultContextSize
+	if cw, ok := c.Backend.(contextWindow); ok && cw.ContextWindow() > 0 {
+		window = cw.ContextWindow()
+	}
+	tools, _ := json.Marshal(c.Tools)
+	return window - min(window/4, maxAnswerReserve) - estimateTokens(string(tools)), window
+}
+
+// contextReport describes how a conversation was fitted in its budget.
+type contextReport struct {
+	Tokens    int // estimated tokens of the messages sent
+	Dropped   int // earlier questions dropped with their answers
+	Truncated int // tool results shortened
+}
+
+// fitContext returns the messages of a conversation that fit in budget
+// tokens, leaving msgs untouched. Tool results of earlier questions are
+// shortened first, then the oldest questions are dropped with their answers,
+// and last the tool results of the current question are shortened. The
+// system prompt and the current question are always kept, so the result may
+// still exceed the budget.
+func fitContext(msgs []Message, budget int) ([]Message, contextReport) {
+	out := append([]Message(nil), msgs...)
+	report := contextReport{Tokens: messagesTokens(out)}
+	if report.Tokens <= budget {
+		return out, report
+	}
+
+	// The current question starts at the last user message
+	current := len(out)
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i].Role == "user" {
+			current = i
+			break
+		}
+	}
+
+	shorten := func(from, to int) {
+		for i := from; i < to && report.Tokens > budget; i++ {
+			if out[i].Role != "tool" || len(out[i].Content) <= truncatedToolBytes {
+				continue
+			}
+			before := messageTokens(out[i])
+			out[i].Content = truncateToolResult(out[i].Content)
+			report.Tokens -= before - messageTokens(out[i])
+			report.Truncated++
+		}
+	}
+
+	shorten(0, current)
+
+	// Drop the oldest questions, each with the answers and tool calls
+	// following it, so that tool calls and results stay paired
+	for report.Tokens > budget {
+		start := 0
+		for start < current && out[start].Role != "user" {
+			start++
+		}
+		if start >= current {
+			break
+		}
+		end := start + 1
+		for end < current && out[end].Role != "user" {
+			end++
+		}
+		report.Tokens -= messagesTokens(out[start:end])
+		out = append(out[:start], out[end:]...)
+		current -= end - start
+		report.Dropped++
+	}
+
+	shorten(current, len(out))
+	return out, report
+}
+
+// contextWarning explains how a conversation was trimmed.
+func contextWarning(r contextReport, budget, window int) string {
+	if r.Tokens > budget {
+		return fmt.Sprintf("Context window exceeded: the question needs ~%d tokens, %d are available with num_ctx %d. Start a new conversation with /new or raise --num-ctx", r.Tokens, budget, window)
+	}
+	return fmt.Sprintf("Context window full (num_ctx %d): dropped %d earlier questions and shortened %d tool results. Start a new conversation with /new or raise --num-ctx", window, r.Dropped, r.Truncated)
+}
+
+// truncateToolResult shortens a tool result to its beginning.
+func truncateToolResult(content string) string {
+	cut := truncatedToolBytes
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s\n[... %d bytes of this tool result were removed to fit the context window]", content[:cut], len(content)-cut)
+}
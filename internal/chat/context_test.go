@@ -0,0 +1,111 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// turn returns a question answered after a tool call with a result of size
+// bytes.
+func turn(question string, size int) []Message {
+	return []Message{
+		{Role: "user", Content: question},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_0", Function: FunctionCall{Name: "query", Arguments: map[string]interface{}{"query": question}}}}},
+		{Role: "tool", Name: "query", ToolCallID: "call_0", Content: strings.Repeat("x", size)},
+		{Role: "assistant", Content: "answer to " + question},
+	}
+}
+
+func TestFitContext(t *testing.T) {
+	system := Message{Role: "system", Content: "Be brief."}
+	msgs := append([]Message{system}, turn("first", 4000)...)
+	msgs = append(msgs, turn("second", 4000)...)
+	msgs = append(msgs, Message{Role: "user", Content: "third"})
+	total := messagesTokens(msgs)
+
+	// Within budget: unchanged
+	out, report := fitContext(msgs, total)
+	assert.Equal(t, msgs, out)
+	assert.Zero(t, report.Dropped+report.Truncated)
+
+	// Shortening the earlier tool results is enough
+	out, report = fitContext(msgs, total-1500)
+	require.Len(t, out, len(msgs))
+	assert.Equal(t, 2, report.Truncated)
+	assert.Zero(t, report.Dropped)
+	assert.Contains(t, out[3].Content, "bytes of this tool result were removed")
+	assert.LessOrEqual(t, report.Tokens, total-1500)
+	assert.Equal(t, messagesTokens(out), report.Tokens)
+	// The conversation itself is not modified
+	assert.Len(t, msgs[3].Content, 4000)
+
+	// Dropping the oldest question with its tool calls
+	out, report = fitContext(msgs, messagesTokens(out)-100)
+	assert.Equal(t, 1, report.Dropped)
+	assert.Equal(t, system, out[0])
+	assert.Equal(t, "second", out[1].Content)
+	assert.Equal(t, "third", out[len(out)-1].Content)
+	assert.Equal(t, messagesTokens(out), report.Tokens)
+
+	// The system prompt and current question are kept even over budget
+	out, report = fitContext(msgs, 10)
+	assert.Equal(t, []Message{system, {Role: "user", Content: "third"}}, out)
+	assert.Greater(t, report.Tokens, 10)
+	assert.Contains(t, contextWarning(report, 10, 8192), "exceeded")
+
+	// Results of the current question are shortened last
+	current := append([]Message{system}, turn("only", 8000)...)
+	current = current[:len(current)-1]
+	out, report = fitContext(current, 500)
+	assert.Equal(t, 1, report.Truncated)
+	assert.Len(t, out, len(current))
+	assert.Less(t, len(out[3].Content), 1000)
+}
+
+func TestTruncateToolResult(t *testing.T) {
+	s := strings.Repeat("é", truncatedToolBytes)
+	out := truncateToolResult(s)
+	head, _, _ := strings.Cut(out, "\n")
+	assert.True(t, strings.HasPrefix(s, head))
+	assert.Len(t, head, truncatedToolBytes)
+}
+
+// smallBackend is a scripted backend with a small context window, recording
+// the messages it receives.
+type smallBackend struct {
+	scriptedBackend
+	sent [][]Message
+}
+
+func (b *smallBackend) ContextWindow() int { return 1024 }
+
+func (b *smallBackend) Complete(ctx context.Context, messages []Message, tools []ToolDef, onContent func(string)) (Message, error) {
+	b.sent = append(b.sent, messages)
+	return b.scriptedBackend.Complete(ctx, messages, tools, onContent)
+}
+
+func TestChatTrimsContext(t *testing.T) {
+	backend := &smallBackend{scriptedBackend: scriptedBackend{replies: []Message{{Role: "assistant", Content: "ok"}}}}
+	c := &Client{Backend: backend, Messages: append([]Message{{Role: "system", Content: "Be brief."}}, turn("first", 6000)...)}
+
+	var warnings []string
+	_, _, err := c.Chat(context.Background(), "second", func(e StreamEvent) {
+		if e.Warning != "" {
+			warnings = append(warnings, e.Warning)
+		}
+	})
+	require.NoError(t, err)
+
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "num_ctx 1024")
+	assert.Contains(t, warnings[0], "shortened 1 tool results")
+	// The model got the earlier tool result shortened, the history is kept
+	require.Len(t, backend.sent, 1)
+	require.Len(t, backend.sent[0], 6)
+	assert.Less(t, len(backend.sent[0][3].Content), 1000)
+	assert.Len(t, c.Messages[3].Content, 6000)
+}
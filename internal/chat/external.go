@@ -0,0 +1,220 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/util"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// toolSeparator joins the name of an external server and of its tools, as
+// in "git__git_log". Tool names sent to the model may only contain letters,
+// digits, '_' and '-'.
+const toolSeparator = "__"
+
+// serverNameRe matches the valid names of external servers.
+var serverNameRe = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
+// ExternalServer is a connection to an external MCP server whose tools are
+// offered to the model, prefixed with the server name.
+type ExternalServer struct {
+	Name   string
+	Tools  []mcp.Tool
+	client *client.Client
+}
+
+// ParseServerSpec parses an external server given on the command line as
+// "name=command arg..." or "name=http(s)://url".
+func ParseServerSpec(spec string) (config.MCPServer, error) {
+	name, target, ok := strings.Cut(spec, "=")
+	fields := strings.Fields(target)
+	if !ok || len(fields) == 0 {
+		return config.MCPServer{}, fmt.Errorf("invalid MCP server %q, expected name=command [args...] or name=URL", spec)
+	}
+	srv := config.MCPServer{Name: strings.TrimSpace(name)}
+	if IsServerURL(fields[0]) && len(fields) == 1 {
+		srv.URL = fields[0]
+	} else {
+		srv.Command, srv.Args = fields[0], fields[1:]
+	}
+	return srv, ValidateServer(srv)
+}
+
+// IsServerURL reports whether target is the URL of an HTTP server rather
+// than a command.
+func IsServerURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// ValidateServer checks the configuration of an external server.
+func ValidateServer(srv config.MCPServer) error {
+	if !serverNameRe.MatchString(srv.Name) {
+		return fmt.Errorf("invalid MCP server name %q: use letters, digits and '-'", srv.Name)
+	}
+	if (srv.Command == "") == (srv.URL == "") {
+		return fmt.Errorf("MCP server %s needs either a command or a URL", srv.Name)
+	}
+	for _, kv := range srv.Env {
+		if !strings.Contains(kv, "=") {
+			return fmt.Errorf("invalid environment variable %q of MCP server %s, expected KEY=VALUE", kv, srv.Name)
+		}
+	}
+	return nil
+}
+
+// ConnectServer starts or connects to an external MCP server and lists its
+// tools. The stderr of commands goes to the debug log.
+func ConnectServer(ctx context.Context, srv config.MCPServer) (*ExternalServer, error) {
+	if err := ValidateServer(srv); err != nil {
+		return nil, err
+	}
+	srv, err := expandServer(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *client.Client
+	if srv.URL != "" {
+		c, err = client.NewStreamableHttpClient(srv.URL, transport.WithHTTPHeaders(srv.Headers))
+		if err == nil {
+			err = c.Start(ctx)
+		}
+	} else {
+		logger := transport.WithCommandLogger(debugLogger{srv.Name})
+		c, err = client.NewStdioMCPClientWithOptions(srv.Command, srv.Env, srv.Args, logger)
+		if err == nil {
+			if stderr, ok := client.GetStderr(c); ok {
+				go func() {
+					scanner := bufio.NewScanner(stderr)
+					for scanner.Scan() {
+						util.Debug("MCP [%s] %s", srv.Name, scanner.Text())
+					}
+				}()
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("MCP server %s: %w", srv.Name, err)
+	}
+
+	init := mcp.InitializeRequest{}
+	init.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	init.Params.ClientInfo = mcp.Implementation{Name: "qmd", Version: "1.0.0"}
+	if _, err := c.Initialize(ctx, init); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("MCP server %s: initialize: %w", srv.Name, err)
+	}
+	tools, err := c.ListTools(ctx, mcp.ListToolsRequest{})
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("MCP server %s: list tools: %w", srv.Name, err)
+	}
+	util.Debug("MCP [%s] %d tools", srv.Name, len(tools.Tools))
+	return &ExternalServer{Name: srv.Name, Tools: tools.Tools, client: c}, nil
+}
+
+// debugLogger sends the logs of the stdio transport, such as the read
+// error when the server stops, to the debug log.
+type debugLogger struct{ name string }
+
+func (l debugLogger) Infof(format string, v ...any) {
+	util.Debug("MCP [%s] %s", l.name, fmt.Sprintf(format, v...))
+}
+
+func (l debugLogger) Errorf(format string, v ...any) {
+	util.Debug("MCP [%s] %s", l.name, fmt.Sprintf(format, v...))
+}
+
+// envRefRe matches the ${VAR} references expanded by expandServer.
+var envRefRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandServer expands the ${VAR} references in the environment variables
+// and headers of a server. Other $ signs are kept as they are, and a
+// reference to an unset variable is an error.
+func expandServer(srv config.MCPServer) (config.MCPServer, error) {
+	var env []string
+	for _, kv := range srv.Env {
+		key, value, _ := strings.Cut(kv, "=")
+		value, err := expandEnvRefs(value)
+		if err != nil {
+			return srv, fmt.Errorf("MCP server %s: env %s: %w", srv.Name, key, err)
+		}
+		env = append(env, key+"="+value)
+	}
+	if srv.Headers != nil {
+		headers := make(map[string]string, len(srv.Headers))
+		for k, v := range srv.Headers {
+			value, err := expandEnvRefs(v)
+			if err != nil {
+				return srv, fmt.Errorf("MCP server %s: header %s: %w", srv.Name, k, err)
+			}
+			headers[k] = value
+		}
+		srv.Headers = headers
+	}
+	srv.Env = env
+	return srv, nil
+}
+
+// expandEnvRefs replaces the ${VAR} references of s with the value of the
+// environment variables.
+func expandEnvRefs(s string) (string, error) {
+	var err error
+	expanded := envRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRefRe.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// CallTool calls a tool of the server by its own name.
+func (s *ExternalServer) CallTool(ctx context.Context, name string, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	req := mcp.CallToolRequest{}
+	req.Params.Name = name
+	req.Params.Arguments = args
+	return s.client.CallTool(ctx, req)
+}
+
+// Close disconnects from the server, stopping its command.
+func (s *ExternalServer) Close() error {
+	return s.client.Close()
+}
+
+// AddServer offers the tools of an external server to the model, named
+// "server__tool".
+func (c *Client) AddServer(s *ExternalServer) {
+	for _, t := range s.Tools {
+		c.Tools = append(c.Tools, ToolDef{
+			Type: "function",
+			Function: ToolFunc{
+				Name:        s.Name + toolSeparator + t.Name,
+				Description: fmt.Sprintf("[%s] %s", s.Name, t.Description),
+				Parameters:  t.InputSchema,
+			},
+		})
+	}
+	c.External = append(c.External, s)
+}
+
+// externalTool returns the server and the own name of a namespaced tool,
+// nil for qmd's own tools.
+func (c *Client) externalTool(name string) (*ExternalServer, string) {
+	for _, s := range c.External {
+		if tool, ok := strings.CutPrefix(name, s.Name+toolSeparator); ok {
+			return s, tool
+		}
+	}
+	return nil, ""
+}
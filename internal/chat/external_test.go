@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/mcpserver"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServerSpec(t *testing.T) {
+	srv, err := ParseServerSpec("git=uvx mcp-server-git --repository /notes")
+	require.NoError(t, err)
+	assert.Equal(t, config.MCPServer{Name: "git", Command: "uvx", Args: []string{"mcp-server-git", "--repository", "/notes"}}, srv)
+
+	srv, err = ParseServerSpec("issues=https://example.com/mcp")
+	require.NoError(t, err)
+	assert.Equal(t, config.MCPServer{Name: "issues", URL: "https://example.com/mcp"}, srv)
+
+	for _, spec := range []string{"git", "git=", "my_git=git", "=git"} {
+		_, err := ParseServerSpec(spec)
+		assert.Error(t, err, spec)
+	}
+	assert.Error(t, ValidateServer(config.MCPServer{Name: "fs", Command: "fs", Env: []string{"ROOT"}}))
+	assert.Error(t, ValidateServer(config.MCPServer{Name: "fs", Command: "fs", URL: "http://localhost"}))
+}
+
+func TestExpandServer(t *testing.T) {
+	t.Setenv("QMD_TEST_TOKEN", "secret")
+	srv := config.MCPServer{
+		Name:    "issues",
+		Env:     []string{"TOKEN=${QMD_TEST_TOKEN}", "PASSWORD=pa$$word", "HOME_DIR=$HOME"},
+		Headers: map[string]string{"Authorization": "Bearer ${QMD_TEST_TOKEN}"},
+	}
+
+	expanded, err := expandServer(srv)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"TOKEN=secret", "PASSWORD=pa$$word", "HOME_DIR=$HOME"}, expanded.Env)
+	assert.Equal(t, "Bearer secret", expanded.Headers["Authorization"])
+	assert.Equal(t, "Bearer ${QMD_TEST_TOKEN}", srv.Headers["Authorization"], "the configuration is left unchanged")
+
+	srv.Headers["Authorization"] = "Bearer ${QMD_TEST_UNSET}"
+	_, err = expandServer(srv)
+	assert.ErrorContains(t, err, "QMD_TEST_UNSET is not set")
+}
+
+func TestExternalServer(t *testing.T) {
+	ext := server.NewMCPServer("echo", "1.0.0")
+	ext.AddTool(mcp.NewTool("echo",
+		mcp.WithDescription("Echo a message"),
+		mcp.WithString("message", mcp.Required()),
+	), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("echo: " + request.GetString("message", "")), nil
+	})
+	httpSrv := server.NewTestStreamableHTTPServer(ext)
+	defer httpSrv.Close()
+
+	srv, err := ConnectServer(context.Background(), config.MCPServer{Name: "ext", URL: httpSrv.URL + "/mcp"})
+	require.NoError(t, err)
+	defer srv.Close()
+	require.Len(t, srv.Tools, 1)
+
+	backend := &scriptedBackend{replies: []Message{
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_0", Function: FunctionCall{Name: "ext__echo", Arguments: map[string]interface{}{"message": "hi"}}}}},
+		{Role: "assistant", Content: "Done"},
+	}}
+	c := NewClient(backend, mcpserver.NewServer(commandStore(t), nil, config.Default()))
+	c.Collection = "notes"
+	own := len(c.Tools)
+	c.AddServer(srv)
+
+	require.Len(t, c.Tools, own+1)
+	assert.Equal(t, "ext__echo", c.Tools[own].Function.Name)
+	assert.Equal(t, "[ext] Echo a message", c.Tools[own].Function.Description)
+
+	answer, _, err := c.Chat(context.Background(), "say hi", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Done", answer)
+	assert.Equal(t, "echo: hi", c.Messages[3].Content)
+	assert.Equal(t, "ext__echo", c.Messages[3].Name)
+}
@@ -0,0 +1,268 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/akhenakh/qmd/internal/store"
+)
+
+// Export formats of a saved conversation
+const (
+	ExportMarkdown = "md"
+	ExportJSON     = "json"
+)
+
+// Save stores the messages added since the last save in the conversation
+// of the client, creating it on the first exchange. Nothing is saved
+// without a store or before the first user message.
+func (c *Client) Save() error {
+	if c.Store == nil || c.saved >= len(c.Messages) {
+		return nil
+	}
+	if c.SessionID == 0 {
+		if !hasUserMessage(c.Messages[c.saved:]) {
+			return nil
+		}
+		id, err := c.Store.CreateChatSession(c.Provider, c.Model)
+		if err != nil {
+			return err
+		}
+		c.SessionID = id
+	}
+
+	msgs := make([]store.ChatMessage, 0, len(c.Messages)-c.saved)
+	for _, m := range c.Messages[c.saved:] {
+		sm, err := toStoreMessage(m)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, sm)
+	}
+	if err := c.Store.AppendChatMessages(c.SessionID, msgs); err != nil {
+		return err
+	}
+	c.saved = len(c.Messages)
+	return nil
+}
+
+// Resume loads a saved conversation, which later messages extend.
+func (c *Client) Resume(id int64) error {
+	if c.Store == nil {
+		return fmt.Errorf("no store to resume the conversation from")
+	}
+	if _, err := c.Store.GetChatSession(id); err != nil {
+		return err
+	}
+	saved, err := c.Store.GetChatMessages(id)
+	if err != nil {
+		return err
+	}
+
+	msgs := make([]Message, 0, len(saved))
+	for _, sm := range saved {
+		m, err := fromStoreMessage(sm)
+		if err != nil {
+			return fmt.Errorf("message %d of chat session %d: %w", sm.Seq, id, err)
+		}
+		msgs = append(msgs, m)
+	}
+	c.Messages = msgs
+	c.SessionID = id
+	c.saved = len(msgs)
+	c.cites = replayCitations(msgs)
+	return nil
+}
+
+// Reset starts a new conversation, keeping the system prompt. The previous
+// conversation stays saved.
+func (c *Client) Reset() {
+	c.Messages = []Message{{Role: "system", Content: c.SystemPrompt()}}
+	c.SessionID = 0
+	c.saved = 0
+	c.cites = citations{}
+}
+
+// SystemPrompt returns the system prompt of the conversation.
+func (c *Client) SystemPrompt() string {
+	if len(c.Messages) > 0 && c.Messages[0].Role == "system" {
+		return c.Messages[0].Content
+	}
+	return systemPrompt
+}
+
+// SetSystemPrompt replaces the system prompt of the conversation, also in
+// its saved copy. An empty prompt restores the default one.
+func (c *Client) SetSystemPrompt(prompt string) error {
+	if prompt == "" {
+		prompt = systemPrompt
+	}
+	if len(c.Messages) == 0 || c.Messages[0].Role != "system" {
+		return fmt.Errorf("the conversation has no system prompt")
+	}
+	c.Messages[0].Content = prompt
+	if c.Store != nil && c.SessionID != 0 && c.saved > 0 {
+		return c.Store.UpdateChatMessage(c.SessionID, 0, prompt)
+	}
+	return nil
+}
+
+func hasUserMessage(msgs []Message) bool {
+	for _, m := range msgs {
+		if m.Role == "user" {
+			return true
+		}
+	}
+	return false
+}
+
+func toStoreMessage(m Message) (store.ChatMessage, error) {
+	sm := store.ChatMessage{
+		Role:       m.Role,
+		Content:    m.Content,
+		ToolCallID: m.ToolCallID,
+		Name:       m.Name,
+	}
+	if len(m.ToolCalls) > 0 {
+		calls, err := json.Marshal(m.ToolCalls)
+		if err != nil {
+			return sm, err
+		}
+		sm.ToolCalls = calls
+	}
+	return sm, nil
+}
+
+func fromStoreMessage(sm store.ChatMessage) (Message, error) {
+	m := Message{
+		Role:       sm.Role,
+		Content:    sm.Content,
+		ToolCallID: sm.ToolCallID,
+		Name:       sm.Name,
+	}
+	if len(sm.ToolCalls) > 0 {
+		if err := json.Unmarshal(sm.ToolCalls, &m.ToolCalls); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// toolLog returns the UI log of a tool call.
+func toolLog(tc ToolCall) ToolExecutionLog {
+	var args map[string]interface{}
+	switch v := tc.Function.Arguments.(type) {
+	case string:
+		json.Unmarshal([]byte(v), &args)
+	case map[string]interface{}:
+		args = v
+	}
+	return ToolExecutionLog{Name: tc.Function.Name, Args: args}
+}
+
+// historyMessages rebuilds the UI history of a conversation: the tool calls
+// of a turn are attached to its answer, the tools run with slash commands
+// show the context they added.
+func historyMessages(msgs []Message) []ChatMessage {
+	var out []ChatMessage
+	var logs []ToolExecutionLog
+	userCalls := make(map[string]ToolExecutionLog)
+	for _, m := range msgs {
+		switch m.Role {
+		case "user":
+			out = append(out, ChatMessage{Role: "You", Text: m.Content})
+			logs = nil
+		case "assistant":
+			for _, tc := range m.ToolCalls {
+				if strings.HasPrefix(tc.ID, userCallPrefix) {
+					userCalls[tc.ID] = toolLog(tc)
+				} else {
+					logs = append(logs, toolLog(tc))
+				}
+			}
+			if len(m.ToolCalls) == 0 {
+				cancelled := strings.HasSuffix(m.Content, cancelledNote)
+				out = append(out, ChatMessage{Role: "qmd", Text: m.Content, ToolLogs: logs, Cancelled: cancelled})
+				logs = nil
+			}
+		case "tool":
+			if call, ok := userCalls[m.ToolCallID]; ok {
+				out = append(out, contextMessage(call.Name, call.Args, m.Content))
+			}
+		}
+	}
+	return out
+}
+
+// Export writes a saved conversation as markdown or JSON.
+func Export(w io.Writer, s *store.Store, id int64, format string) error {
+	session, err := s.GetChatSession(id)
+	if err != nil {
+		return err
+	}
+	msgs, err := s.GetChatMessages(id)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case ExportJSON:
+		if msgs == nil {
+			msgs = []store.ChatMessage{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			*store.ChatSession
+			Messages []store.ChatMessage `json:"messages"`
+		}{session, msgs})
+	case ExportMarkdown:
+		return exportMarkdown(w, session, msgs)
+	}
+	return fmt.Errorf("unknown export format %q (valid: %s, %s)", format, ExportMarkdown, ExportJSON)
+}
+
+// exportMarkdown writes the conversation as a markdown document, with the
+// tool calls and results of each turn in collapsed sections.
+func exportMarkdown(w io.Writer, session *store.ChatSession, msgs []store.ChatMessage) error {
+	var b strings.Builder
+	title := session.Title
+	if title == "" {
+		title = fmt.Sprintf("Chat %d", session.ID)
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "- Session: %d\n- Model: %s (%s)\n- Started: %s\n- Updated: %s\n",
+		session.ID, session.Model, session.Provider,
+		session.CreatedAt.Local().Format(time.DateTime), session.UpdatedAt.Local().Format(time.DateTime))
+
+	for _, sm := range msgs {
+		m, err := fromStoreMessage(sm)
+		if err != nil {
+			return err
+		}
+		at := sm.CreatedAt.Local().Format(time.DateTime)
+		switch m.Role {
+		case "system":
+			fmt.Fprintf(&b, "\n<details>\n<summary>System prompt</summary>\n\n%s\n\n</details>\n", m.Content)
+		case "user":
+			fmt.Fprintf(&b, "\n## You (%s)\n\n%s\n", at, m.Content)
+		case "assistant":
+			if len(m.ToolCalls) == 0 {
+				fmt.Fprintf(&b, "\n## qmd (%s)\n\n%s\n", at, m.Content)
+				continue
+			}
+			for _, tc := range m.ToolCalls {
+				args, _ := json.Marshal(toolLog(tc).Args)
+				fmt.Fprintf(&b, "\n> 🔨 `%s(%s)`\n", tc.Function.Name, args)
+			}
+		case "tool":
+			fmt.Fprintf(&b, "\n<details>\n<summary>Result of %s</summary>\n\n````\n%s\n````\n\n</details>\n", m.Name, m.Content)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/mcpserver"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedBackend answers with the given messages in turn.
+type scriptedBackend struct {
+	replies []Message
+}
+
+func (b *scriptedBackend) Complete(ctx context.Context, messages []Message, tools []ToolDef, onContent func(string)) (Message, error) {
+	reply := b.replies[0]
+	b.replies = b.replies[1:]
+	return reply, nil
+}
+
+func TestSaveResumeExport(t *testing.T) {
+	s, err := store.NewStore(filepath.Join(t.TempDir(), "chat.sqlite"))
+	require.NoError(t, err)
+	defer s.DB.Close()
+
+	backend := &scriptedBackend{replies: []Message{
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_0", Function: FunctionCall{Name: "missing", Arguments: map[string]interface{}{"query": "kafka"}}}}},
+		{Role: "assistant", Content: "Kafka is a log."},
+		{Role: "assistant", Content: "Partitions."},
+	}}
+	c := NewClient(backend, &mcpserver.Server{})
+	c.Store, c.Provider, c.Model = s, ProviderOllama, "llama3"
+
+	// Nothing is saved before the first question
+	require.NoError(t, c.Save())
+	assert.Zero(t, c.SessionID)
+
+	_, _, err = c.Chat(context.Background(), "What is kafka?", nil)
+	require.NoError(t, err)
+	require.NoError(t, c.Save())
+	require.NotZero(t, c.SessionID)
+	id := c.SessionID
+
+	// Resume in another client and continue the conversation
+	r := NewClient(backend, &mcpserver.Server{})
+	r.Store = s
+	require.NoError(t, r.Resume(id))
+	assert.Equal(t, c.Messages, r.Messages)
+
+	_, _, err = r.Chat(context.Background(), "And scaling?", nil)
+	require.NoError(t, err)
+	require.NoError(t, r.Save())
+
+	saved, err := s.GetChatMessages(id)
+	require.NoError(t, err)
+	assert.Len(t, saved, len(r.Messages))
+
+	history := historyMessages(r.Messages)
+	require.Len(t, history, 4)
+	assert.Equal(t, "qmd", history[1].Role)
+	assert.Equal(t, "Kafka is a log.", history[1].Text)
+	require.Len(t, history[1].ToolLogs, 1)
+	assert.Equal(t, "missing", history[1].ToolLogs[0].Name)
+	assert.Empty(t, history[3].ToolLogs)
+
+	var md bytes.Buffer
+	require.NoError(t, Export(&md, s, id, ExportMarkdown))
+	assert.Contains(t, md.String(), "# What is kafka?")
+	assert.Contains(t, md.String(), "🔨 `missing({\"query\":\"kafka\"})`")
+	assert.Contains(t, md.String(), "<summary>Result of missing</summary>")
+	assert.Contains(t, md.String(), "Partitions.")
+
+	var js bytes.Buffer
+	require.NoError(t, Export(&js, s, id, ExportJSON))
+	var exported struct {
+		ID       int64               `json:"id"`
+		Model    string              `json:"model"`
+		Messages []store.ChatMessage `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal(js.Bytes(), &exported))
+	assert.Equal(t, id, exported.ID)
+	assert.Equal(t, "llama3", exported.Model)
+	assert.Len(t, exported.Messages, len(saved))
+
+	assert.Error(t, Export(&js, s, id, "html"))
+
+	// A new conversation keeps the system prompt only
+	r.Reset()
+	assert.Zero(t, r.SessionID)
+	require.Len(t, r.Messages, 1)
+	assert.Equal(t, "system", r.Messages[0].Role)
+}
@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/akhenakh/qmd/internal/llm"
+	"github.com/akhenakh/qmd/internal/util"
+	"github.com/hybridgroup/yzma/pkg/llama"
+	"github.com/hybridgroup/yzma/pkg/template"
+	"github.com/nikolalohinski/gonja/v2"
+	"github.com/nikolalohinski/gonja/v2/config"
+	"github.com/nikolalohinski/gonja/v2/exec"
+	"github.com/nikolalohinski/gonja/v2/loaders"
+)
+
+// toolPrompt describes the tools to models whose chat template does not
+// render them, asking for the Hermes call format.
+const toolPrompt = `
+
+You have access to the following tools:
+
+%s
+
+To call a tool, answer with one or more tool calls in this format, and nothing else:
+<tool_call>
+{"name": "tool_name", "arguments": {"arg": "value"}}
+</tool_call>`
+
+// LocalBackend generates answers in-process with a GGUF model through
+// llama.cpp, formatting the conversation with the chat template of the model.
+type LocalBackend struct {
+	Model *llm.LocalChatModel
+	// Template is the Jinja chat template of the model, "" if it has none.
+	Template string
+}
+
+func NewLocalBackend(modelPath, libPath string, contextSize int, temperature *float64) (*LocalBackend, error) {
+	model, err := llm.NewLocalChatModel(modelPath, libPath, llm.LocalChatOptions{
+		ContextSize: contextSize,
+		Temperature: temperature,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LocalBackend{Model: model, Template: model.ChatTemplate()}, nil
+}
+
+func (b *LocalBackend) Complete(ctx context.Context, messages []Message, tools []ToolDef, onContent func(string)) (Message, error) {
+	prompt, err := b.render(messages, tools)
+	if err != nil {
+		return Message{}, err
+	}
+
+	filter := &toolCallFilter{emit: onContent}
+	text, err := b.Model.Generate(ctx, prompt, filter.write)
+	if err != nil {
+		return Message{}, err
+	}
+
+	content, calls := parseToolCalls(text)
+	if len(calls) == 0 {
+		filter.flush()
+		content = strings.TrimSpace(text)
+	}
+	return Message{Role: "assistant", Content: content, ToolCalls: calls}, nil
+}
+
+func (b *LocalBackend) Close() error {
+	return b.Model.Close()
+}
+
+// render formats the conversation with the Jinja template of the model, or
+// with the llama.cpp built-in templates when it cannot be rendered.
+func (b *LocalBackend) render(messages []Message, tools []ToolDef) (string, error) {
+	// Templates that ignore the tools variable get them in the system prompt
+	toolsInTemplate := strings.Contains(b.Template, "tools")
+	if len(tools) > 0 && !toolsInTemplate {
+		messages = withToolPrompt(messages, tools)
+	}
+
+	if b.Template != "" {
+		prompt, err := renderJinja(b.Template, messages, tools, b.Model.BOS(), b.Model.EOS())
+		if err == nil {
+			return prompt, nil
+		}
+		util.Debug("LLM [Local] Chat template failed, using llama.cpp templates: %v", err)
+		if len(tools) > 0 && toolsInTemplate {
+			messages = withToolPrompt(messages, tools)
+		}
+	}
+	return renderBuiltin(b.Template, messages)
+}
+
+// withToolPrompt appends the tool descriptions to the system prompt.
+func withToolPrompt(messages []Message, tools []ToolDef) []Message {
+	defs, _ := json.MarshalIndent(tools, "", "  ")
+	out := append([]Message(nil), messages...)
+	if len(out) == 0 || out[0].Role != "system" {
+		out = append([]Message{{Role: "system"}}, out...)
+	}
+	out[0].Content += fmt.Sprintf(toolPrompt, defs)
+	return out
+}
+
+// renderJinja renders a Hugging Face style chat template.
+func renderJinja(tmpl string, messages []Message, tools []ToolDef, bos, eos string) (string, error) {
+	// Chat templates are rendered with trim_blocks and lstrip_blocks
+	cfg := config.New()
+	cfg.TrimBlocks = true
+	cfg.LeftStripBlocks = true
+
+	// No filesystem access from templates
+	loader, err := loaders.NewShiftedLoader("chat_template", strings.NewReader(tmpl), &template.NoFSLoader{})
+	if err != nil {
+		return "", err
+	}
+	t, err := exec.NewTemplate("chat_template", cfg, loader, gonja.DefaultEnvironment)
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]interface{}{
+		"messages":              jinjaValue(messages),
+		"add_generation_prompt": true,
+		"bos_token":             bos,
+		"eos_token":             eos,
+		"raise_exception": func(_ *exec.Evaluator, params *exec.VarArgs) (string, error) {
+			return "", fmt.Errorf("chat template: %s", params.First().String())
+		},
+		"strftime_now": func(_ *exec.Evaluator, params *exec.VarArgs) string {
+			return strftime(time.Now(), params.First().String())
+		},
+	}
+	if len(tools) > 0 {
+		data["tools"] = jinjaValue(tools)
+	}
+	return t.ExecuteToString(exec.NewContext(data))
+}
+
+// jinjaValue converts v to the maps and slices templates expect, through
+// its JSON form. Tool call arguments are decoded to objects.
+func jinjaValue(v interface{}) interface{} {
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(v)
+	var out interface{}
+	json.Unmarshal(buf.Bytes(), &out)
+
+	if msgs, ok := out.([]interface{}); ok {
+		for _, m := range msgs {
+			calls, _ := m.(map[string]interface{})["tool_calls"].([]interface{})
+			for _, c := range calls {
+				fn, _ := c.(map[string]interface{})["function"].(map[string]interface{})
+				if s, ok := fn["arguments"].(string); ok {
+					var args map[string]interface{}
+					if json.Unmarshal([]byte(s), &args) == nil {
+						fn["arguments"] = args
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// renderBuiltin formats the conversation with the llama.cpp template
+// matching tmpl, falling back to ChatML. These templates only know roles and
+// content, so tool calls and results are written as Hermes style text.
+func renderBuiltin(tmpl string, messages []Message) (string, error) {
+	if tmpl == "" {
+		tmpl = "chatml"
+	}
+
+	chat := make([]llama.ChatMessage, 0, len(messages))
+	for _, m := range messages {
+		content := m.Content
+		for _, tc := range m.ToolCalls {
+			args, _ := json.Marshal(tc.Function.Arguments)
+			if s, ok := tc.Function.Arguments.(string); ok {
+				args = []byte(s)
+			}
+			content += fmt.Sprintf("\n%s\n{\"name\": %q, \"arguments\": %s}\n%s", hermesCallStart, tc.Function.Name, args, hermesCallEnd)
+		}
+		if m.Role == "tool" {
+			content = "<tool_response>\n" + content + "\n</tool_response>"
+		}
+		chat = append(chat, llama.NewChatMessage(m.Role, content))
+	}
+
+	for _, t := range []string{tmpl, "chatml"} {
+		buf := make([]byte, 4096)
+		n := llama.ChatApplyTemplate(t, chat, true, buf)
+		if n > int32(len(buf)) {
+			buf = make([]byte, n)
+			n = llama.ChatApplyTemplate(t, chat, true, buf)
+		}
+		if n > 0 {
+			return string(buf[:n]), nil
+		}
+	}
+	return "", fmt.Errorf("unable to apply chat template")
+}
+
+// strftime formats t with the Python directives used by chat templates.
+func strftime(t time.Time, format string) string {
+	r := strings.NewReplacer(
+		"%Y", t.Format("2006"),
+		"%m", t.Format("01"),
+		"%d", t.Format("02"),
+		"%b", t.Format("Jan"),
+		"%B", t.Format("January"),
+		"%a", t.Format("Mon"),
+		"%A", t.Format("Monday"),
+		"%H", t.Format("15"),
+		"%M", t.Format("04"),
+		"%S", t.Format("05"),
+		"%%", "%",
+	)
+	return r.Replace(format)
+}
@@ -0,0 +1,161 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseToolCalls(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		content string
+		calls   []string // name:arg
+	}{
+		{
+			name:    "hermes",
+			text:    "Let me look.\n<tool_call>\n{\"name\": \"query\", \"arguments\": {\"query\": \"kafka\"}}\n</tool_call>\n<tool_call>{\"name\": \"list_tags\", \"arguments\": {}}</tool_call>",
+			content: "Let me look.",
+			calls:   []string{"query:kafka", "list_tags:"},
+		},
+		{
+			name:  "hermes without closing tag",
+			text:  "<tool_call>\n{\"name\": \"query\", \"arguments\": {\"query\": \"kafka\"}}",
+			calls: []string{"query:kafka"},
+		},
+		{
+			name:  "mistral array",
+			text:  "[TOOL_CALLS] [{\"name\": \"query\", \"arguments\": {\"query\": \"kafka\"}}]",
+			calls: []string{"query:kafka"},
+		},
+		{
+			name:  "mistral args",
+			text:  "[TOOL_CALLS]query[ARGS]{\"query\": \"kafka\"}",
+			calls: []string{"query:kafka"},
+		},
+		{
+			name:  "llama json with parameters",
+			text:  "<|python_tag|>{\"name\": \"query\", \"parameters\": {\"query\": \"kafka\"}}",
+			calls: []string{"query:kafka"},
+		},
+		{
+			name:  "fenced json with string arguments",
+			text:  "```json\n{\"name\": \"query\", \"arguments\": \"{\\\"query\\\": \\\"kafka\\\"}\"}\n```",
+			calls: []string{"query:kafka"},
+		},
+		{
+			name:    "plain answer",
+			text:    "Kafka groups rebalance when a consumer joins.",
+			content: "Kafka groups rebalance when a consumer joins.",
+		},
+		{
+			name:    "json that is not a call",
+			text:    `{"answer": 42}`,
+			content: `{"answer": 42}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, calls := parseToolCalls(tt.text)
+			assert.Equal(t, tt.content, content)
+
+			var got []string
+			for _, c := range calls {
+				args := c.Function.Arguments.(map[string]interface{})
+				q, _ := args["query"].(string)
+				got = append(got, c.Function.Name+":"+q)
+				assert.NotEmpty(t, c.ID)
+			}
+			assert.Equal(t, tt.calls, got)
+		})
+	}
+}
+
+func TestToolCallFilter(t *testing.T) {
+	stream := func(pieces ...string) string {
+		var out strings.Builder
+		f := &toolCallFilter{emit: func(s string) { out.WriteString(s) }}
+		for _, p := range pieces {
+			f.write(p)
+		}
+		text := f.text.String()
+		if _, calls := parseToolCalls(text); len(calls) == 0 {
+			f.flush()
+		}
+		return out.String()
+	}
+
+	assert.Equal(t, "Hello world", stream("Hel", "lo ", "world"))
+	assert.Equal(t, "Let me look.\n", stream("Let me look.\n", "<tool", "_call>{\"name\":\"q\",\"arguments\":{}}", "</tool_call>"))
+	assert.Equal(t, "", stream("{\"name\"", ":\"q\",\"arguments\":{}}"))
+	// Held back text that is not a call is emitted at the end
+	assert.Equal(t, "a < b", stream("a <", " b"))
+	assert.Equal(t, "{not a call}", stream("{not", " a call}"))
+}
+
+func TestRenderJinja(t *testing.T) {
+	// A reduced Qwen style template, rendering tools and tool calls
+	tmpl := `{%- if tools %}
+<|im_start|>system
+{{ messages[0].content }}
+{%- for tool in tools %}
+{{ tool.function.name }}: {{ tool.function.description }}
+{%- endfor %}<|im_end|>
+{%- endif %}
+{%- for message in messages[1:] %}
+{%- if message.role == "assistant" and message.tool_calls %}
+<|im_start|>assistant
+{%- for tc in message.tool_calls %}
+<tool_call>{{ tc.function.name }} {{ tc.function.arguments | tojson }}</tool_call>
+{%- endfor %}<|im_end|>
+{%- else %}
+<|im_start|>{{ message.role }}
+{{ message.content }}<|im_end|>
+{%- endif %}
+{%- endfor %}
+{%- if add_generation_prompt %}
+<|im_start|>assistant
+{%- endif %}`
+
+	messages := []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "What about kafka?"},
+		{Role: "assistant", ToolCalls: []ToolCall{{Function: FunctionCall{Name: "query", Arguments: `{"query":"kafka"}`}}}},
+		{Role: "tool", Content: "[results]", Name: "query"},
+	}
+	tools := []ToolDef{{Type: "function", Function: ToolFunc{Name: "query", Description: "Hybrid search"}}}
+
+	prompt, err := renderJinja(tmpl, messages, tools, "", "")
+	require.NoError(t, err)
+
+	assert.Contains(t, prompt, "Be brief.")
+	assert.Contains(t, prompt, "query: Hybrid search<|im_end|>")
+	assert.Contains(t, prompt, "<|im_start|>user\nWhat about kafka?<|im_end|>")
+	// Arguments are passed to the template as an object, not a string
+	assert.Contains(t, prompt, `<tool_call>query {"query":"kafka"}</tool_call>`)
+	assert.Contains(t, prompt, "<|im_start|>tool\n[results]<|im_end|>")
+	assert.True(t, strings.HasSuffix(prompt, "<|im_start|>assistant"))
+
+	_, err = renderJinja(`{{ raise_exception("roles must alternate") }}`, messages, nil, "", "")
+	assert.ErrorContains(t, err, "roles must alternate")
+}
+
+func TestWithToolPrompt(t *testing.T) {
+	tools := []ToolDef{{Type: "function", Function: ToolFunc{Name: "query"}}}
+
+	out := withToolPrompt([]Message{{Role: "user", Content: "hi"}}, tools)
+	require.Len(t, out, 2)
+	assert.Equal(t, "system", out[0].Role)
+	assert.Contains(t, out[0].Content, `"name": "query"`)
+	assert.Contains(t, out[0].Content, "<tool_call>")
+
+	in := []Message{{Role: "system", Content: "Base."}, {Role: "user", Content: "hi"}}
+	out = withToolPrompt(in, tools)
+	require.Len(t, out, 2)
+	assert.True(t, strings.HasPrefix(out[0].Content, "Base."))
+	assert.Equal(t, "Base.", in[0].Content)
+}
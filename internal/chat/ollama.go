@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// OllamaBackend talks to the Ollama /api/chat endpoint.
+type OllamaBackend struct {
+	BaseURL     string
+	Model       string
+	ContextSize int
+	Temperature *float64
+	Client      *http.Client
+}
+
+func (b *OllamaBackend) Complete(ctx context.Context, messages []Message, tools []ToolDef, onContent func(string)) (Message, error) {
+	options := map[string]interface{}{
+		"num_ctx": b.ContextSize,
+	}
+	if b.Temperature != nil {
+		options["temperature"] = *b.Temperature
+	}
+	reqBody := ChatRequest{
+		Model:    b.Model,
+		Messages: messages,
+		Stream:   true,
+		Tools:    tools,
+		Options:  options,
+	}
+
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return Message{}, fmt.Errorf("marshal error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.BaseURL+"/api/chat", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return Message{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return Message{}, fmt.Errorf("network error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return Message{}, fmt.Errorf("ollama API error: %s", resp.Status)
+	}
+
+	return readStream(resp, onContent)
+}
@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAIBackend talks to an OpenAI-compatible /v1/chat/completions endpoint,
+// as served by llama-server, vLLM or LM Studio.
+type OpenAIBackend struct {
+	BaseURL     string
+	Model       string
+	APIKey      string
+	Temperature *float64
+	// ContextSize is the context window of the server, used to budget the
+	// conversation.
+	ContextSize int
+	Client      *http.Client
+}
+
+type openAIRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Stream      bool      `json:"stream"`
+	Tools       []ToolDef `json:"tools,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+}
+
+func (b *OpenAIBackend) Complete(ctx context.Context, messages []Message, tools []ToolDef, onContent func(string)) (Message, error) {
+	reqBody := openAIRequest{
+		Model:       b.Model,
+		Messages:    openAIMessages(messages),
+		Stream:      true,
+		Tools:       tools,
+		Temperature: b.Temperature,
+	}
+
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return Message{}, fmt.Errorf("marshal error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint(), bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return Message{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if b.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+b.APIKey)
+	}
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return Message{}, fmt.Errorf("network error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Message{}, fmt.Errorf("chat API error: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return readStream(resp, onContent)
+}
+
+// endpoint accepts a server root or a URL already ending in /v1.
+func (b *OpenAIBackend) endpoint() string {
+	url := strings.TrimSuffix(b.BaseURL, "/")
+	if !strings.HasSuffix(url, "/v1") {
+		url += "/v1"
+	}
+	return url + "/chat/completions"
+}
+
+// openAIMessages adapts the conversation to the OpenAI format, where tool
+// call arguments are a JSON string rather than an object.
+func openAIMessages(messages []Message) []Message {
+	out := make([]Message, len(messages))
+	for i, msg := range messages {
+		if len(msg.ToolCalls) > 0 {
+			calls := make([]ToolCall, len(msg.ToolCalls))
+			for j, tc := range msg.ToolCalls {
+				if _, ok := tc.Function.Arguments.(string); !ok {
+					args, _ := json.Marshal(tc.Function.Arguments)
+					tc.Function.Arguments = string(args)
+				}
+				if tc.Type == "" {
+					tc.Type = "function"
+				}
+				calls[j] = tc
+			}
+			msg.ToolCalls = calls
+		}
+		out[i] = msg
+	}
+	return out
+}
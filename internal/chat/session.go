@@ -9,20 +9,57 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 )
 
+// SessionOptions configures a chat session.
+type SessionOptions struct {
+	// Provider and Model are recorded with the conversation.
+	Provider string
+	Model    string
+	// ResumeID continues a saved conversation if not 0.
+	ResumeID int64
+	// Resolve returns the file of a "collection/path" document to open in
+	// $EDITOR, "" if it has none (archives).
+	Resolve func(docPath string) string
+	// Collections are the names of the collections, completed by the
+	// slash commands.
+	Collections []string
+	// External are connected external MCP servers whose tools are offered
+	// to the model.
+	External []*ExternalServer
+}
+
 type Session struct {
-	Program *tea.Program
+	client *Client
+	model  model
 }
 
-func NewSession(url, modelName string, s *store.Store, mcp *mcpserver.Server) (*Session, error) {
-	client := NewOllamaClient(url, modelName, mcp)
-	m := initialModel(client)
-	p := tea.NewProgram(m, tea.WithAltScreen()) // AltScreen for full terminal UI
-	return &Session{Program: p}, nil
+func NewSession(backend ChatBackend, s *store.Store, mcp *mcpserver.Server, opts SessionOptions) (*Session, error) {
+	client := NewClient(backend, mcp)
+	client.Store = s
+	client.Provider = opts.Provider
+	client.Model = opts.Model
+	for _, srv := range opts.External {
+		client.AddServer(srv)
+	}
+	if opts.ResumeID != 0 {
+		if err := client.Resume(opts.ResumeID); err != nil {
+			return nil, err
+		}
+	}
+
+	m := initialModel(client, opts.Resolve, opts.Collections)
+	return &Session{client: client, model: m}, nil
 }
 
+// Start runs the chat UI until the user quits. Cancelling ctx stops the UI
+// and the request in flight.
 func (s *Session) Start(ctx context.Context) error {
-	if _, err := s.Program.Run(); err != nil {
+	s.model.ctx = ctx
+	p := tea.NewProgram(s.model, tea.WithAltScreen(), tea.WithContext(ctx)) // AltScreen for full terminal UI
+	if _, err := p.Run(); err != nil {
 		return fmt.Errorf("error running chat UI: %w", err)
 	}
+	if id := s.client.SessionID; id != 0 {
+		fmt.Printf("Conversation saved, resume it with: qmd chat --resume %d\n", id)
+	}
 	return nil
 }
@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/tui"
+	"github.com/akhenakh/qmd/internal/util"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// sourcePreviewLines is the number of lines shown around a cited line.
+const sourcePreviewLines = 40
+
+var (
+	sourceSelectedStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("6"))
+	sourceLineStyle     = lipgloss.NewStyle().Bold(true)
+)
+
+// editorMsg reports the end of an editor opened on a source.
+type editorMsg struct {
+	err     error
+	tmpFile string
+}
+
+// footerRe matches a source of the footer added to answers.
+var footerRe = regexp.MustCompile("(?m)^- \\[(\\d+)\\] `([^`]+)`")
+
+// footerSources parses the sources footer of an answer, used for answers
+// loaded from a saved conversation.
+func footerSources(answer string) []Source {
+	var sources []Source
+	for _, m := range footerRe.FindAllStringSubmatch(answer, -1) {
+		n, _ := strconv.Atoi(m[1])
+		s := Source{N: n, Filepath: m[2]}
+		if i := strings.LastIndex(m[2], ":"); i >= 0 {
+			if line, err := strconv.Atoi(m[2][i+1:]); err == nil {
+				s.Filepath, s.Line = m[2][:i], line
+			}
+		}
+		sources = append(sources, s)
+	}
+	return sources
+}
+
+// updateSourcePane handles the keys of the sources pane.
+func (m model) updateSourcePane(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc, tea.KeyCtrlS:
+		m.sourcePane = false
+		m.rebuildView()
+		return m, nil
+	case tea.KeyUp, tea.KeyShiftTab:
+		m.sourceIdx = (m.sourceIdx + len(m.sources) - 1) % len(m.sources)
+	case tea.KeyDown, tea.KeyTab:
+		m.sourceIdx = (m.sourceIdx + 1) % len(m.sources)
+	case tea.KeyPgUp, tea.KeyPgDown:
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return m, cmd
+	case tea.KeyEnter:
+		return m, m.openSource(m.sources[m.sourceIdx])
+	case tea.KeyRunes:
+		n, err := strconv.Atoi(string(msg.Runes))
+		if err != nil {
+			return m, nil
+		}
+		for i, s := range m.sources {
+			if s.N == n {
+				m.sourceIdx = i
+			}
+		}
+	default:
+		return m, nil
+	}
+	m.sourceView()
+	return m, nil
+}
+
+// sourceView shows the cited sources with a preview of the selected one.
+func (m *model) sourceView() {
+	var b strings.Builder
+	b.WriteString(statusStyle.Render("Sources of the last answer (↑/↓ or number: select, Enter: open in $EDITOR, Esc: close)"))
+	b.WriteString("\n\n")
+	for i, s := range m.sources {
+		line := fmt.Sprintf("[%d] %s %s", s.N, s, s.Title)
+		if i == m.sourceIdx {
+			b.WriteString(sourceSelectedStyle.Render("> "+line) + "\n")
+		} else {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	b.WriteString(dividerStyle.Render(strings.Repeat("─", m.width/2)) + "\n")
+
+	s := m.sources[m.sourceIdx]
+	content, err := m.sourceContent(s)
+	if err != nil {
+		b.WriteString(errorStyle.Render(err.Error()))
+	} else {
+		b.WriteString(sourceExcerpt(content, s.Line))
+	}
+	m.viewport.SetContent(b.String())
+	m.viewport.GotoTop()
+}
+
+// sourceExcerpt returns the numbered lines around line, highlighting it.
+func sourceExcerpt(content string, line int) string {
+	if line < 1 {
+		line = 1
+	}
+	start := max(line-sourcePreviewLines/4, 1)
+	end := start + sourcePreviewLines - 1
+	lines := strings.Split(util.NumberLines(util.LineRange(content, start, end), start), "\n")
+	if i := line - start; i < len(lines) {
+		lines[i] = sourceLineStyle.Render(lines[i])
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m *model) sourceContent(s Source) (string, error) {
+	if m.client.Store == nil {
+		return "", fmt.Errorf("no store to read %s from", s.Filepath)
+	}
+	collection, path, err := util.SplitDocPath(s.Filepath)
+	if err != nil {
+		return "", err
+	}
+	return m.client.Store.GetDocument(collection, path)
+}
+
+// openSource opens a source in $EDITOR at its line. Documents without a
+// file on disk (archives) are opened from a temporary copy.
+func (m model) openSource(s Source) tea.Cmd {
+	path := ""
+	if m.resolve != nil {
+		path = m.resolve(s.Filepath)
+	}
+
+	var tmpFile string
+	if _, err := os.Stat(path); path == "" || err != nil {
+		content, err := m.sourceContent(s)
+		if err != nil {
+			return func() tea.Msg { return editorMsg{err: err} }
+		}
+		f, err := os.CreateTemp("", "qmd-*-"+filepath.Base(s.Filepath))
+		if err != nil {
+			return func() tea.Msg { return editorMsg{err: err} }
+		}
+		_, err = f.WriteString(content)
+		f.Close()
+		if err != nil {
+			return func() tea.Msg { return editorMsg{err: err, tmpFile: f.Name()} }
+		}
+		path, tmpFile = f.Name(), f.Name()
+	}
+
+	line := max(s.Line, 1)
+	cmd := tui.EditorCommand(os.Getenv("EDITOR"), path, line)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorMsg{err: err, tmpFile: tmpFile}
+	})
+}
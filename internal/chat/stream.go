@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// readStream decodes a streamed chat response, either Ollama NDJSON or
+// OpenAI server-sent events, calling onContent with each content chunk. It
+// returns the complete assistant message, tool calls included.
+func readStream(resp *http.Response, onContent func(string)) (Message, error) {
+	b := &messageBuilder{onContent: onContent}
+	var err error
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		err = readSSE(resp.Body, b)
+	} else {
+		err = readNDJSON(resp.Body, b)
+	}
+	if err != nil {
+		return Message{}, err
+	}
+	return b.message(), nil
+}
+
+// readNDJSON reads a sequence of JSON objects. A server that ignored the
+// stream flag sends a single object, which is handled the same way.
+func readNDJSON(r io.Reader, b *messageBuilder) error {
+	dec := json.NewDecoder(r)
+	for {
+		var chunk ChatResponse
+		if err := dec.Decode(&chunk); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decode error: %w", err)
+		}
+		if err := b.add(chunk); err != nil {
+			return err
+		}
+		if chunk.Done {
+			return nil
+		}
+	}
+}
+
+// readSSE reads server-sent events until the "[DONE]" event or the end of
+// the body.
+func readSSE(r io.Reader, b *messageBuilder) error {
+	br := bufio.NewReader(r)
+	var data []string
+	for {
+		line, err := br.ReadString('\n')
+		eof := errors.Is(err, io.EOF)
+		if err != nil && !eof {
+			return fmt.Errorf("read error: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if field, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(field, " "))
+		}
+
+		// A blank line, or the end of the body, dispatches the event.
+		if (line == "" || eof) && len(data) > 0 {
+			payload := strings.Join(data, "\n")
+			data = nil
+			if payload == "[DONE]" {
+				return nil
+			}
+			var chunk ChatResponse
+			if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+				return fmt.Errorf("decode error: %w", err)
+			}
+			if err := b.add(chunk); err != nil {
+				return err
+			}
+		}
+
+		if eof {
+			return nil
+		}
+	}
+}
+
+// messageBuilder assembles a message from streamed chunks.
+type messageBuilder struct {
+	onContent func(string)
+	content   strings.Builder
+	toolCalls []ToolCall
+
+	// deltas holds the OpenAI tool call fragments by index.
+	deltas map[int]*ToolCall
+	args   map[int]*strings.Builder
+}
+
+func (b *messageBuilder) add(chunk ChatResponse) error {
+	if chunk.Error != "" {
+		return fmt.Errorf("chat API error: %s", chunk.Error)
+	}
+
+	if len(chunk.Choices) == 0 {
+		b.addContent(chunk.Message.Content)
+		b.toolCalls = append(b.toolCalls, chunk.Message.ToolCalls...)
+		return nil
+	}
+
+	choice := chunk.Choices[0]
+	b.addContent(choice.Message.Content)
+	b.toolCalls = append(b.toolCalls, choice.Message.ToolCalls...)
+	b.addContent(choice.Delta.Content)
+	for _, d := range choice.Delta.ToolCalls {
+		b.addToolCallDelta(d)
+	}
+	return nil
+}
+
+func (b *messageBuilder) addContent(s string) {
+	if s == "" {
+		return
+	}
+	b.content.WriteString(s)
+	if b.onContent != nil {
+		b.onContent(s)
+	}
+}
+
+func (b *messageBuilder) addToolCallDelta(d ToolCallDelta) {
+	if b.deltas == nil {
+		b.deltas = make(map[int]*ToolCall)
+		b.args = make(map[int]*strings.Builder)
+	}
+	tc, ok := b.deltas[d.Index]
+	if !ok {
+		tc = &ToolCall{Type: "function"}
+		b.deltas[d.Index] = tc
+		b.args[d.Index] = &strings.Builder{}
+	}
+	if d.ID != "" {
+		tc.ID = d.ID
+	}
+	if d.Type != "" {
+		tc.Type = d.Type
+	}
+	tc.Function.Name += d.Function.Name
+	b.args[d.Index].WriteString(d.Function.Arguments)
+}
+
+func (b *messageBuilder) message() Message {
+	msg := Message{Role: "assistant", Content: b.content.String(), ToolCalls: b.toolCalls}
+
+	indexes := make([]int, 0, len(b.deltas))
+	for i := range b.deltas {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		tc := *b.deltas[i]
+		args := b.args[i].String()
+		if args == "" {
+			args = "{}"
+		}
+		tc.Function.Arguments = args
+		msg.ToolCalls = append(msg.ToolCalls, tc)
+	}
+	return msg
+}
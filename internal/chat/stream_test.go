@@ -0,0 +1,217 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/mcpserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func streamResponse(contentType, body string) *http.Response {
+	return &http.Response{
+		Header: http.Header{"Content-Type": []string{contentType}},
+		Body:   io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestReadStreamNDJSON(t *testing.T) {
+	body := `{"message":{"role":"assistant","content":"Hel"},"done":false}
+{"message":{"role":"assistant","content":"lo"},"done":false}
+{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"query","arguments":{"query":"kafka"}}}]},"done":false}
+{"message":{"role":"assistant","content":""},"done":true}
+`
+	var chunks []string
+	msg, err := readStream(streamResponse("application/x-ndjson", body), func(s string) { chunks = append(chunks, s) })
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Hel", "lo"}, chunks)
+	assert.Equal(t, "Hello", msg.Content)
+	require.Len(t, msg.ToolCalls, 1)
+	assert.Equal(t, "query", msg.ToolCalls[0].Function.Name)
+	assert.Equal(t, map[string]interface{}{"query": "kafka"}, msg.ToolCalls[0].Function.Arguments)
+}
+
+func TestReadStreamNDJSONError(t *testing.T) {
+	_, err := readStream(streamResponse("application/x-ndjson", `{"error":"model not found"}`), nil)
+	assert.ErrorContains(t, err, "model not found")
+}
+
+func TestReadStreamSSE(t *testing.T) {
+	body := `data: {"choices":[{"delta":{"content":"Let me "}}]}
+
+data: {"choices":[{"delta":{"content":"check."}}]}
+
+: keep-alive
+
+data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"query","arguments":"{\"que"}}]}}]}
+
+data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ry\":\"kafka\"}"}}]}}]}
+
+data: {"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_2","function":{"name":"list_tags"}}]}}]}
+
+data: [DONE]
+
+`
+	msg, err := readStream(streamResponse("text/event-stream; charset=utf-8", body), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Let me check.", msg.Content)
+	require.Len(t, msg.ToolCalls, 2)
+	assert.Equal(t, "call_1", msg.ToolCalls[0].ID)
+	assert.Equal(t, "query", msg.ToolCalls[0].Function.Name)
+	assert.Equal(t, `{"query":"kafka"}`, msg.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, "list_tags", msg.ToolCalls[1].Function.Name)
+	assert.Equal(t, "{}", msg.ToolCalls[1].Function.Arguments)
+}
+
+func TestReadStreamUnstreamed(t *testing.T) {
+	body := `{
+  "choices": [{"message": {"role": "assistant", "content": "Done"}}]
+}`
+	msg, err := readStream(streamResponse("application/json", body), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Done", msg.Content)
+}
+
+func TestChatStreamsAcrossToolTurns(t *testing.T) {
+	turn := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.True(t, req.Stream)
+
+		turn++
+		if turn == 1 {
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Searching"},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"missing","arguments":{}}}]},"done":true}`)
+			return
+		}
+		assert.Equal(t, "tool", req.Messages[len(req.Messages)-1].Role)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"The "},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"answer"},"done":true}`)
+	}))
+	defer srv.Close()
+
+	backend, err := NewBackend(BackendConfig{URL: srv.URL, Model: "test"})
+	require.NoError(t, err)
+	c := NewClient(backend, &mcpserver.Server{})
+
+	var events []StreamEvent
+	answer, logs, err := c.Chat(context.Background(), "question", func(e StreamEvent) { events = append(events, e) })
+	require.NoError(t, err)
+
+	assert.Equal(t, "The answer", answer)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "missing", logs[0].Name)
+	require.Len(t, events, 4)
+	assert.Equal(t, "Searching", events[0].Content)
+	require.NotNil(t, events[1].Tool)
+	assert.Equal(t, "missing", events[1].Tool.Name)
+	assert.Equal(t, "The ", events[2].Content)
+}
+
+func TestOpenAIBackend(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		var req openAIRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.True(t, req.Stream)
+		require.NotNil(t, req.Temperature)
+		assert.Equal(t, 0.2, *req.Temperature)
+		// Tool call arguments are sent back as a JSON string.
+		assert.Equal(t, `{"query":"kafka"}`, req.Messages[1].ToolCalls[0].Function.Arguments)
+		assert.Equal(t, "function", req.Messages[1].ToolCalls[0].Type)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	temperature := 0.2
+	backend, err := NewBackend(BackendConfig{Provider: ProviderOpenAI, URL: srv.URL + "/v1/", Model: "m", APIKey: "secret", Temperature: &temperature})
+	require.NoError(t, err)
+
+	messages := []Message{
+		{Role: "user", Content: "question"},
+		{Role: "assistant", ToolCalls: []ToolCall{{Function: FunctionCall{Name: "query", Arguments: map[string]interface{}{"query": "kafka"}}}}},
+	}
+	msg, err := backend.Complete(context.Background(), messages, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", msg.Content)
+
+	_, err = NewBackend(BackendConfig{Provider: "nope"})
+	assert.Error(t, err)
+}
+
+func TestChatCancel(t *testing.T) {
+	// The server streams the start of an answer, then hangs
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Partial"},"done":false}`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	backend, err := NewBackend(BackendConfig{URL: srv.URL, Model: "test"})
+	require.NoError(t, err)
+	c := NewClient(backend, &mcpserver.Server{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	answer, _, err := c.Chat(ctx, "question", func(e StreamEvent) { cancel() })
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "Partial\n\n(Cancelled)", answer)
+	require.Len(t, c.Messages, 3)
+	assert.Equal(t, Message{Role: "assistant", Content: answer}, c.Messages[2])
+
+	// Cancelled between tool calls, every call still gets a result
+	s := commandStore(t)
+	c = NewClient(&scriptedBackend{replies: []Message{
+		{Role: "assistant", ToolCalls: []ToolCall{
+			{ID: "call_0", Function: FunctionCall{Name: "search", Arguments: map[string]interface{}{"query": "kafka"}}},
+			{ID: "call_1", Function: FunctionCall{Name: "search", Arguments: map[string]interface{}{"query": "pods"}}},
+		}},
+	}}, mcpserver.NewServer(s, nil, config.Default()))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	answer, logs, err := c.Chat(ctx, "question", func(e StreamEvent) {
+		if e.Tool != nil {
+			cancel()
+		}
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "(Cancelled)", answer)
+	assert.Equal(t, []ToolExecutionLog{{Name: "search", Args: map[string]interface{}{"query": "kafka"}}}, logs)
+	require.Len(t, c.Messages, 6)
+	assert.Contains(t, c.Messages[3].Content, "context canceled")
+	assert.Equal(t, "call_1", c.Messages[4].ToolCallID)
+	assert.Equal(t, "Cancelled by the user.", c.Messages[4].Content)
+	assert.Equal(t, "assistant", c.Messages[5].Role)
+
+	history := historyMessages(c.Messages)
+	require.Len(t, history, 2)
+	assert.True(t, history[1].Cancelled)
+}
+
+func TestSessionContextCancelsRequest(t *testing.T) {
+	s, err := NewSession(&scriptedBackend{}, nil, &mcpserver.Server{}, SessionOptions{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.model.ctx = ctx
+	req := s.model.startRequest()
+	assert.NoError(t, req.Err())
+
+	cancel()
+	assert.ErrorIs(t, req.Err(), context.Canceled)
+}
@@ -0,0 +1,196 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Markers of the tool call formats emitted by local models.
+const (
+	hermesCallStart = "<tool_call>" // Hermes, Qwen
+	hermesCallEnd   = "</tool_call>"
+	mistralCalls    = "[TOOL_CALLS]" // Mistral
+	mistralArgs     = "[ARGS]"
+	pythonTag       = "<|python_tag|>" // Llama 3.1+
+)
+
+// parseToolCalls extracts the tool calls written as text by a model, in the
+// Hermes/Qwen (<tool_call>{...}</tool_call>), Mistral ([TOOL_CALLS][...]) or
+// Llama 3 (a JSON object, optionally after <|python_tag|>) formats. It returns
+// the remaining text.
+func parseToolCalls(text string) (string, []ToolCall) {
+	var calls []ToolCall
+	var content strings.Builder
+
+	switch {
+	case strings.Contains(text, hermesCallStart):
+		rest := text
+		for {
+			start := strings.Index(rest, hermesCallStart)
+			if start < 0 {
+				content.WriteString(rest)
+				break
+			}
+			content.WriteString(rest[:start])
+			rest = rest[start+len(hermesCallStart):]
+
+			// The closing tag may be missing when generation stopped at it
+			body := rest
+			if end := strings.Index(rest, hermesCallEnd); end >= 0 {
+				body, rest = rest[:end], rest[end+len(hermesCallEnd):]
+			} else {
+				rest = ""
+			}
+			calls = append(calls, decodeToolCalls(body)...)
+		}
+
+	case strings.Contains(text, mistralCalls):
+		i := strings.Index(text, mistralCalls)
+		content.WriteString(text[:i])
+		rest := strings.TrimSpace(text[i+len(mistralCalls):])
+		if strings.HasPrefix(rest, "[") {
+			calls = decodeToolCalls(rest)
+		} else {
+			// Newer format: name[ARGS]{...}, repeated after each marker
+			for _, part := range strings.Split(rest, mistralCalls) {
+				name, args, ok := strings.Cut(part, mistralArgs)
+				if !ok {
+					continue
+				}
+				calls = append(calls, decodeToolCalls(fmt.Sprintf(`{"name":%q,"arguments":%s}`, strings.TrimSpace(name), strings.TrimSpace(args)))...)
+			}
+		}
+
+	default:
+		// The whole answer is a JSON call, possibly fenced
+		body := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), pythonTag))
+		if fenced, ok := strings.CutPrefix(body, "```json"); ok {
+			body = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(fenced), "```"))
+		}
+		if strings.HasPrefix(body, "{") || strings.HasPrefix(body, "[") {
+			calls = decodeToolCalls(body)
+		}
+		if len(calls) == 0 {
+			return text, nil
+		}
+	}
+
+	for i := range calls {
+		calls[i].ID = fmt.Sprintf("call_%d", i)
+	}
+	return strings.TrimSpace(content.String()), calls
+}
+
+// rawToolCall is a call as written by a model: Llama names the arguments
+// "parameters", and some models encode them as a JSON string.
+type rawToolCall struct {
+	Name       string          `json:"name"`
+	Arguments  json.RawMessage `json:"arguments"`
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+// decodeToolCalls decodes a JSON call or array of calls. Invalid JSON
+// yields no call.
+func decodeToolCalls(s string) []ToolCall {
+	s = strings.TrimSpace(s)
+	var raws []rawToolCall
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &raws); err != nil {
+			return nil
+		}
+	} else {
+		var raw rawToolCall
+		if err := json.Unmarshal([]byte(s), &raw); err != nil {
+			return nil
+		}
+		raws = []rawToolCall{raw}
+	}
+
+	var calls []ToolCall
+	for _, raw := range raws {
+		if raw.Name == "" {
+			continue
+		}
+		argsJSON := raw.Arguments
+		if len(argsJSON) == 0 {
+			argsJSON = raw.Parameters
+		}
+		args := make(map[string]interface{})
+		var encoded string
+		if json.Unmarshal(argsJSON, &encoded) == nil {
+			argsJSON = json.RawMessage(encoded)
+		}
+		if len(argsJSON) > 0 {
+			if err := json.Unmarshal(argsJSON, &args); err != nil {
+				continue
+			}
+		}
+		calls = append(calls, ToolCall{
+			Type:     "function",
+			Function: FunctionCall{Name: raw.Name, Arguments: args},
+		})
+	}
+	return calls
+}
+
+// toolCallFilter streams generated text while holding back tool call markup,
+// which is parsed once generation ends instead of being shown.
+type toolCallFilter struct {
+	emit func(string)
+	text strings.Builder
+	sent int
+	held bool
+}
+
+func (f *toolCallFilter) write(s string) {
+	f.text.WriteString(s)
+	if f.held || f.emit == nil {
+		return
+	}
+	text := f.text.String()
+
+	// An answer starting with JSON may be a Llama style call: wait for the
+	// end of generation
+	if f.sent == 0 {
+		lead := strings.TrimSpace(text)
+		for _, prefix := range []string{"{", "[", pythonTag, "```json"} {
+			if strings.HasPrefix(lead, prefix) {
+				f.held = true
+				return
+			}
+			if strings.HasPrefix(prefix, lead) {
+				return
+			}
+		}
+	}
+
+	safe := len(text)
+	for _, marker := range []string{hermesCallStart, mistralCalls} {
+		if i := strings.Index(text[f.sent:], marker); i >= 0 {
+			f.held = true
+			safe = min(safe, f.sent+i)
+			continue
+		}
+		// Keep back a partial marker at the end
+		for k := min(len(marker)-1, len(text)-f.sent); k > 0; k-- {
+			if strings.HasSuffix(text, marker[:k]) {
+				safe = min(safe, len(text)-k)
+				break
+			}
+		}
+	}
+	if safe > f.sent {
+		f.emit(text[f.sent:safe])
+		f.sent = safe
+	}
+}
+
+// flush emits the text held back when it turned out not to be a tool call.
+func (f *toolCallFilter) flush() {
+	text := f.text.String()
+	if f.emit != nil && f.sent < len(text) {
+		f.emit(text[f.sent:])
+		f.sent = len(text)
+	}
+}
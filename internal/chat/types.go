@@ -27,8 +27,8 @@ type FunctionCall struct {
 
 // ToolExecutionLog contains details about a tool execution for UI display
 type ToolExecutionLog struct {
-	Name string
-	Args map[string]interface{}
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
 }
 
 type ChatRequest struct {
@@ -54,9 +54,46 @@ type ChatResponse struct {
 	Model   string  `json:"model"`
 	Message Message `json:"message"`
 	Done    bool    `json:"done"`
+	Error   string  `json:"error,omitempty"`
 
 	// Support for OpenAI-compatible responses (used by some llama-server versions)
-	Choices []struct {
-		Message Message `json:"message"`
-	} `json:"choices"`
+	Choices []Choice `json:"choices"`
+}
+
+// Choice is an OpenAI-compatible completion choice. Streamed responses fill
+// Delta instead of Message.
+type Choice struct {
+	Message Message `json:"message"`
+	Delta   Delta   `json:"delta"`
+}
+
+// Delta is an increment of a streamed OpenAI-compatible message.
+type Delta struct {
+	Content   string          `json:"content"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta is a fragment of a streamed tool call. Fragments with the
+// same Index belong to the same call, and their arguments are concatenated.
+type ToolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments,omitempty"`
+	} `json:"function"`
+}
+
+// StreamEvent is an incremental update of an answer, delivered while it is
+// generated.
+type StreamEvent struct {
+	// Content is the next chunk of the answer text.
+	Content string
+	// Tool is set when the model calls a tool. Content streamed before it
+	// belonged to an intermediate turn, the answer starts over after it.
+	Tool *ToolExecutionLog
+	// Warning is set when the conversation had to be trimmed to fit the
+	// context window.
+	Warning string
 }
@@ -1,7 +1,9 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -58,10 +60,15 @@ type ChatMessage struct {
 	Text     string
 	ToolLogs []ToolExecutionLog
 	IsError  bool
+	// Markdown renders Text as markdown, as answers are.
+	Markdown bool
+	// Cancelled answers show the tool calls made before they were
+	// cancelled.
+	Cancelled bool
 }
 
 type model struct {
-	client       *OllamaClient
+	client       *Client
 	textInput    textinput.Model
 	viewport     viewport.Model
 	spinner      spinner.Model
@@ -81,12 +88,43 @@ type model struct {
 	history      []string
 	historyIndex int
 	historyDraft string
+
+	// Streaming answer
+	stream     chan tea.Msg
+	partial    string
+	liveLogs   []ToolExecutionLog
+	renderer   *glamour.TermRenderer
+	rendererW  int
+	runningCmd string
+	warning    string
+
+	// ctx is the context of the session, requests are derived from it
+	ctx context.Context
+	// cancel aborts the request in flight
+	cancel     context.CancelFunc
+	cancelling bool
+
+	// Sources cited by the last answer, browsed in the sources pane
+	sources    []Source
+	sourcePane bool
+	sourceIdx  int
+	resolve    func(docPath string) string
+
+	// collections completes the arguments of slash commands
+	collections []string
 }
 
 type responseMsg struct {
 	content  string
 	toolLogs []ToolExecutionLog
+	sources  []Source
 	err      error
+	saveErr  error
+}
+
+// streamMsg carries an increment of the answer being generated.
+type streamMsg struct {
+	event StreamEvent
 }
 
 type statusClearMsg struct{}
@@ -95,9 +133,9 @@ type statusClearMsg struct{}
 type clipboardMsg struct{}
 type clipboardErrMsg struct{ err error }
 
-func initialModel(client *OllamaClient) model {
+func initialModel(client *Client, resolve func(string) string, collections []string) model {
 	ti := textinput.New()
-	ti.Placeholder = "Ask about your notes... (Ctrl+O: Toggle Tools, Ctrl+P: Copy)"
+	ti.Placeholder = "Ask about your notes... (Ctrl+O: Toggle Tools, Ctrl+P: Copy, Ctrl+S: Sources, /help: Commands)"
 	ti.Focus()
 	ti.CharLimit = 4096
 	ti.Width = 50
@@ -109,16 +147,27 @@ func initialModel(client *OllamaClient) model {
 	vp := viewport.New(0, 0)
 	vp.Style = lipgloss.NewStyle().PaddingRight(2)
 
-	return model{
+	m := model{
+		ctx:          context.Background(),
 		client:       client,
 		textInput:    ti,
 		viewport:     vp,
 		spinner:      s,
 		history:      []string{},
 		historyIndex: 0,
-		messages:     []ChatMessage{},
+		messages:     historyMessages(client.Messages),
 		showToolLogs: false,
+		resolve:      resolve,
+		collections:  collections,
+	}
+	if client.SessionID != 0 {
+		m.statusMsg = fmt.Sprintf("Resumed conversation %d", client.SessionID)
+		if n := len(m.messages); n > 0 && m.messages[n-1].Role == "qmd" {
+			m.lastAnswer = m.messages[n-1].Text
+			m.sources = footerSources(m.lastAnswer)
+		}
 	}
+	return m
 }
 
 func (m model) Init() tea.Cmd {
@@ -132,6 +181,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmd   tea.Cmd
 	)
 
+	if km, ok := msg.(tea.KeyMsg); ok && m.sourcePane {
+		return m.updateSourcePane(km)
+	}
+
 	m.textInput, tiCmd = m.textInput.Update(msg)
 	m.viewport, vpCmd = m.viewport.Update(msg)
 
@@ -148,12 +201,26 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.viewport.Height = vpHeight
 		// Re-render whole view on resize to fix word wrapping
 		m.rebuildView()
+		if m.sourcePane {
+			m.sourceView()
+		}
 
 	case tea.KeyMsg:
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		case tea.KeyCtrlC:
 			return m, tea.Quit
 
+		// Esc: Cancel the request in flight, or quit
+		case tea.KeyEsc:
+			if !m.isLoading {
+				return m, tea.Quit
+			}
+			if m.cancel != nil {
+				m.cancel()
+				m.cancelling = true
+			}
+			return m, nil
+
 		// Ctrl+O: Toggle Tool Logs
 		case tea.KeyCtrlO:
 			m.showToolLogs = !m.showToolLogs
@@ -172,6 +239,20 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, copyToClipboardCmd(m.lastAnswer)
 			}
 
+		// Ctrl+S: Browse the sources of the last answer
+		case tea.KeyCtrlS:
+			if m.isLoading {
+				return m, nil
+			}
+			if len(m.sources) == 0 {
+				m.statusMsg = "The last answer cites no sources"
+				return m, tea.Tick(time.Second*2, func(_ time.Time) tea.Msg { return statusClearMsg{} })
+			}
+			m.sourcePane = true
+			m.sourceIdx = 0
+			m.sourceView()
+			return m, nil
+
 		case tea.KeyEnter:
 			if m.isLoading {
 				return m, nil
@@ -186,6 +267,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.historyIndex = len(m.history)
 			m.historyDraft = ""
 
+			if strings.HasPrefix(input, "/") {
+				return m.runCommand(input)
+			}
+
 			// Add to Chat Messages
 			userMsg := ChatMessage{Role: "You", Text: input}
 			m.messages = append(m.messages, userMsg)
@@ -194,16 +279,33 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.appendView(userMsg)
 
 			m.textInput.Reset()
-			m.isLoading = true
 			m.statusMsg = ""
-
-			return m, tea.Batch(
-				m.spinner.Tick,
-				func() tea.Msg {
-					resp, logs, err := m.client.Chat(input)
-					return responseMsg{content: resp, toolLogs: logs, err: err}
-				},
-			)
+			m.partial = ""
+			m.liveLogs = nil
+			m.runningCmd = ""
+			m.warning = ""
+
+			// The answer is produced in the background and delivered
+			// through the stream channel, one message at a time.
+			stream := make(chan tea.Msg, 64)
+			m.stream = stream
+			client := m.client
+			ctx := m.startRequest()
+			go func() {
+				resp, logs, err := client.Chat(ctx, input, func(e StreamEvent) {
+					stream <- streamMsg{event: e}
+				})
+				saveErr := client.Save()
+				stream <- responseMsg{content: resp, toolLogs: logs, sources: client.Sources, err: err, saveErr: saveErr}
+				close(stream)
+			}()
+
+			return m, tea.Batch(m.spinner.Tick, waitForStream(stream))
+
+		case tea.KeyTab:
+			if !m.isLoading {
+				return m.complete()
+			}
 
 		case tea.KeyUp:
 			if m.historyIndex > 0 {
@@ -225,22 +327,70 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+	case streamMsg:
+		if msg.event.Warning != "" {
+			m.warning = msg.event.Warning
+			return m, waitForStream(m.stream)
+		}
+		if msg.event.Tool != nil {
+			// A tool call ends an intermediate turn, the answer starts over
+			m.partial = ""
+			m.liveLogs = append(m.liveLogs, *msg.event.Tool)
+			m.runningCmd = msg.event.Tool.Name
+		} else {
+			m.partial += msg.event.Content
+			m.runningCmd = ""
+		}
+		m.streamView()
+		return m, waitForStream(m.stream)
+
 	case responseMsg:
-		m.isLoading = false
+		m.endRequest()
+		m.stream = nil
+		m.partial = ""
+		m.liveLogs = nil
+		m.runningCmd = ""
 		var botMsg ChatMessage
 
-		if msg.err != nil {
+		switch {
+		case errors.Is(msg.err, context.Canceled):
+			// The partial answer and the tools run so far
+			botMsg = ChatMessage{Role: "qmd", Text: msg.content, ToolLogs: msg.toolLogs, Cancelled: true}
+			m.lastAnswer = msg.content
+			m.sources = nil
+			m.warning = "Request cancelled"
+		case msg.err != nil:
 			botMsg = ChatMessage{Role: "Error", Text: msg.err.Error(), IsError: true}
-		} else {
+		default:
 			botMsg = ChatMessage{Role: "qmd", Text: msg.content, ToolLogs: msg.toolLogs}
 			m.lastAnswer = msg.content
+			m.sources = msg.sources
 		}
 
 		m.messages = append(m.messages, botMsg)
 		m.appendView(botMsg)
 		m.textInput.Focus()
+		if m.warning != "" {
+			m.statusMsg = m.warning
+		}
+		if msg.saveErr != nil {
+			m.statusMsg = fmt.Sprintf("Unable to save the conversation: %v", msg.saveErr)
+		}
 		return m, textinput.Blink
 
+	case toolResultMsg:
+		return m.toolResult(msg)
+
+	case editorMsg:
+		if msg.tmpFile != "" {
+			os.Remove(msg.tmpFile)
+		}
+		if msg.err != nil {
+			m.statusMsg = fmt.Sprintf("Editor error: %v", msg.err)
+			return m, tea.Tick(time.Second*3, func(_ time.Time) tea.Msg { return statusClearMsg{} })
+		}
+		return m, nil
+
 	case clipboardMsg:
 		m.statusMsg = "Copied to clipboard!"
 		return m, tea.Tick(time.Second*2, func(_ time.Time) tea.Msg { return statusClearMsg{} })
@@ -262,6 +412,26 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(tiCmd, vpCmd)
 }
 
+// startRequest marks a request in flight and returns its context, cancelled
+// with Esc or with the session.
+func (m *model) startRequest() context.Context {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.isLoading = true
+	m.cancel = cancel
+	m.cancelling = false
+	return ctx
+}
+
+// endRequest marks the end of the request in flight.
+func (m *model) endRequest() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.isLoading = false
+	m.cancel = nil
+	m.cancelling = false
+}
+
 func (m *model) setInput(s string) {
 	m.textInput.SetValue(s)
 	m.textInput.SetCursor(len(s))
@@ -305,7 +475,7 @@ func (m *model) renderMessageToView(msg ChatMessage) {
 	body := ""
 
 	// Render Tool Logs if enabled
-	if m.showToolLogs && len(msg.ToolLogs) > 0 {
+	if (m.showToolLogs || msg.Cancelled) && len(msg.ToolLogs) > 0 {
 		var toolText strings.Builder
 		toolText.WriteString("\n")
 		for _, log := range msg.ToolLogs {
@@ -316,29 +486,18 @@ func (m *model) renderMessageToView(msg ChatMessage) {
 	}
 
 	// Render Content
-	if msg.Role == "qmd" {
+	if msg.Role == "qmd" || msg.Markdown {
 		width := m.width - 4
 		if width < 20 {
 			width = 20
 		}
 
 		if msg.Text == "" {
-			body += "(No content)\n"
-		} else {
-			renderer, err := glamour.NewTermRenderer(
-				glamour.WithStandardStyle("dark"),
-				glamour.WithWordWrap(width),
-			)
-			if err == nil {
-				rendered, err := renderer.Render(msg.Text)
-				if err == nil {
-					body += strings.TrimSpace(rendered) + "\n"
-				} else {
-					body += msg.Text + "\n"
-				}
-			} else {
-				body += msg.Text + "\n"
+			if !m.isLoading {
+				body += "(No content)\n"
 			}
+		} else {
+			body += m.renderMarkdown(msg.Text, width) + "\n"
 		}
 	} else {
 		body += fmt.Sprintf("\n%s\n", msg.Text)
@@ -347,12 +506,68 @@ func (m *model) renderMessageToView(msg ChatMessage) {
 	m.renderedView += fmt.Sprintf("%s\n%s", roleStr, body)
 }
 
+// renderMarkdown renders text with glamour, reusing the renderer while the
+// width does not change. Unrenderable text is returned as is.
+func (m *model) renderMarkdown(text string, width int) string {
+	if m.renderer == nil || m.rendererW != width {
+		renderer, err := glamour.NewTermRenderer(
+			glamour.WithStandardStyle("dark"),
+			glamour.WithWordWrap(width),
+		)
+		if err != nil {
+			return text
+		}
+		m.renderer, m.rendererW = renderer, width
+	}
+	rendered, err := m.renderer.Render(text)
+	if err != nil {
+		return text
+	}
+	return strings.TrimSpace(rendered)
+}
+
+// streamView shows the history followed by the answer being generated.
+func (m *model) streamView() {
+	saved := m.renderedView
+	m.renderedView += "\n" + dividerStyle.Render(strings.Repeat("─", m.width/2)) + "\n"
+	m.renderMessageToView(ChatMessage{Role: "qmd", Text: closeFences(m.partial), ToolLogs: m.liveLogs})
+	m.viewport.SetContent(m.renderedView)
+	m.viewport.GotoBottom()
+	m.renderedView = saved
+}
+
+// closeFences closes a code block left open by a partial answer, so that the
+// rest of the answer is not rendered as code until the block ends.
+func closeFences(text string) string {
+	if strings.Count(text, "```")%2 == 1 {
+		return text + "\n```"
+	}
+	return text
+}
+
+// waitForStream delivers the next message of an answer being generated.
+func waitForStream(stream chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-stream
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
+
 func (m model) View() string {
 	spin := " "
-	if m.isLoading {
-		spin = m.spinner.View() + " Thinking..."
+	if m.isLoading && m.cancelling {
+		spin = m.spinner.View() + " Cancelling..."
+	} else if m.isLoading && m.runningCmd != "" {
+		spin = m.spinner.View() + fmt.Sprintf(" Running %s... (Esc: Cancel)", m.runningCmd)
+	} else if m.isLoading {
+		spin = m.spinner.View() + " Thinking... (Esc: Cancel)"
 	} else if m.statusMsg != "" {
 		spin = statusStyle.Render(m.statusMsg)
+	} else if m.client.Collection != "" {
+		spin = statusStyle.Render("Searching collection " + m.client.Collection)
 	}
 
 	return fmt.Sprintf(
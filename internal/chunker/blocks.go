@@ -0,0 +1,138 @@
+package chunker
+
+import (
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/util"
+)
+
+type blockKind int
+
+const (
+	blockText blockKind = iota
+	blockHeading
+	blockCode
+	blockTable
+)
+
+type heading struct {
+	level int
+	text  string
+}
+
+// block is a structural element of a markdown document.
+type block struct {
+	kind   blockKind
+	text   string
+	level  int       // Heading level, for heading blocks
+	offset int       // Byte offset of the block in the document
+	crumbs []heading // Heading breadcrumb in effect, including the block itself if a heading
+}
+
+// parseBlocks splits markdown into headings, paragraphs, fenced code blocks
+// and tables. Blank lines separate paragraphs.
+func parseBlocks(text string) []block {
+	var blocks []block
+	var crumbs []heading
+
+	var current []string
+	currentKind := blockText
+	currentOffset := 0
+
+	flush := func() {
+		if len(current) > 0 {
+			blocks = append(blocks, block{
+				kind:   currentKind,
+				text:   strings.Join(current, "\n"),
+				offset: currentOffset,
+				crumbs: crumbs,
+			})
+		}
+		current = nil
+		currentKind = blockText
+	}
+
+	for ml := range util.MarkdownLines(text) {
+		line, lineOffset := ml.Text, ml.Offset
+		trimmed := strings.TrimSpace(line)
+
+		// A fenced block is kept verbatim until the closing fence
+		if ml.FenceOpen {
+			flush()
+			currentKind = blockCode
+			currentOffset = lineOffset
+		}
+		if ml.Code {
+			current = append(current, line)
+			if ml.FenceClose {
+				flush()
+			}
+			continue
+		}
+
+		if level, text, ok := util.ParseHeading(trimmed); ok {
+			flush()
+			next := make([]heading, 0, level)
+			for _, h := range crumbs {
+				if h.level < level {
+					next = append(next, h)
+				}
+			}
+			crumbs = append(next, heading{level: level, text: text})
+			blocks = append(blocks, block{kind: blockHeading, text: line, level: level, offset: lineOffset, crumbs: crumbs})
+			continue
+		}
+
+		if trimmed == "" {
+			flush()
+			continue
+		}
+
+		isTable := strings.HasPrefix(trimmed, "|")
+		if len(current) > 0 && isTable != (currentKind == blockTable) {
+			flush()
+		}
+		if len(current) == 0 {
+			currentOffset = lineOffset
+			if isTable {
+				currentKind = blockTable
+			}
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return blocks
+}
+
+// breadcrumbAt returns the block containing offset and the heading
+// breadcrumb in effect there.
+func breadcrumbAt(blocks []block, offset int) (block, []string) {
+	var found block
+	for _, b := range blocks {
+		if b.offset > offset {
+			break
+		}
+		found = b
+	}
+	return found, headingTexts(found.crumbs)
+}
+
+func headingTexts(crumbs []heading) []string {
+	var texts []string
+	for _, h := range crumbs {
+		texts = append(texts, h.text)
+	}
+	return texts
+}
+
+func renderBreadcrumb(crumbs []heading) string {
+	var sb strings.Builder
+	for _, h := range crumbs {
+		sb.WriteString(strings.Repeat("#", h.level))
+		sb.WriteString(" ")
+		sb.WriteString(h.text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
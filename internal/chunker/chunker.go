@@ -0,0 +1,295 @@
+package chunker
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/akhenakh/qmd/internal/util"
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// Available chunking strategies
+const (
+	StrategyMarkdown  = "markdown"  // LangChainGo markdown splitter (default)
+	StrategyHeading   = "heading"   // One chunk per H1-H3 section, capped in size
+	StrategyParagraph = "paragraph" // Paragraph windows, long paragraphs split by sentence
+	StrategyCode      = "code"      // Sections at every heading level, never cutting fenced code blocks or tables
+	StrategyWhole     = "whole"     // The whole document as a single chunk
+)
+
+// Strategies lists the valid strategy names.
+var Strategies = []string{StrategyMarkdown, StrategyHeading, StrategyParagraph, StrategyCode, StrategyWhole}
+
+// Chunk is a piece of a document embedded as one vector.
+type Chunk struct {
+	Text     string
+	Headings []string // Heading breadcrumb of the section the chunk belongs to
+}
+
+// Chunker splits markdown text into chunks.
+type Chunker interface {
+	Split(text string) ([]Chunk, error)
+}
+
+// New returns the chunker for a strategy. An empty strategy selects the default.
+func New(strategy string, size, overlap int) (Chunker, error) {
+	switch strategy {
+	case "", StrategyMarkdown:
+		return markdownChunker{splitter: textsplitter.NewMarkdownTextSplitter(
+			textsplitter.WithChunkSize(size),
+			textsplitter.WithChunkOverlap(overlap),
+			textsplitter.WithHeadingHierarchy(true),
+		)}, nil
+	case StrategyHeading:
+		return sectionChunker{maxLevel: 3, size: size}, nil
+	case StrategyCode:
+		return sectionChunker{maxLevel: 6, size: size, overlap: overlap, atomicBlocks: true}, nil
+	case StrategyParagraph:
+		return paragraphChunker{size: size, overlap: overlap}, nil
+	case StrategyWhole:
+		return wholeChunker{}, nil
+	}
+	return nil, fmt.Errorf("unknown chunking strategy %q (valid: %s)", strategy, strings.Join(Strategies, ", "))
+}
+
+// ForCollection returns the chunker configured for a collection, so the
+// query-time chunk reconstruction matches what was embedded.
+func ForCollection(cfg *config.Config, collection string) (Chunker, error) {
+	return New(cfg.ChunkerFor(collection), cfg.ChunkSize, cfg.ChunkOverlap)
+}
+
+// SplitDocument splits a document, making sure it starts with its title as
+// H1 so the title is part of the heading breadcrumb of every chunk.
+func SplitDocument(c Chunker, title, body string) ([]Chunk, error) {
+	contentToSplit := body
+	titleHeader := fmt.Sprintf("# %s", title)
+	if !strings.Contains(body, titleHeader) {
+		contentToSplit = fmt.Sprintf("%s\n\n%s", titleHeader, body)
+	}
+	return c.Split(contentToSplit)
+}
+
+// MatchedChunk returns the text of the chunk a vector result matched.
+// Vectors stored without their chunk text fall back to re-splitting the
+// document with the collection's chunking strategy.
+func MatchedChunk(cfg *config.Config, r store.SearchResult) (string, bool) {
+	if r.Chunk != "" {
+		return r.Chunk, true
+	}
+
+	collection, _, _ := strings.Cut(r.Filepath, "/")
+	c, err := ForCollection(cfg, collection)
+	if err != nil {
+		return "", false
+	}
+
+	chunks, err := SplitDocument(c, r.Title, r.Body)
+	if err != nil || r.Seq >= len(chunks) {
+		return "", false
+	}
+	return chunks[r.Seq].Text, true
+}
+
+type markdownChunker struct {
+	splitter textsplitter.TextSplitter
+}
+
+func (c markdownChunker) Split(text string) ([]Chunk, error) {
+	texts, err := c.splitter.SplitText(text)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]Chunk, len(texts))
+	for i, t := range texts {
+		chunks[i] = Chunk{Text: t, Headings: util.LeadingHeadings(t)}
+	}
+	return chunks, nil
+}
+
+type wholeChunker struct{}
+
+func (wholeChunker) Split(text string) ([]Chunk, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	_, headings := breadcrumbAt(parseBlocks(text), 0)
+	return []Chunk{{Text: text, Headings: headings}}, nil
+}
+
+// sectionChunker emits the content of each section (started by a heading of
+// at most maxLevel) as one chunk, prefixed by its heading breadcrumb. Sections
+// larger than size are packed into several chunks without cutting code
+// blocks or tables.
+type sectionChunker struct {
+	maxLevel     int
+	size         int
+	overlap      int
+	atomicBlocks bool
+}
+
+func (c sectionChunker) Split(text string) ([]Chunk, error) {
+	blocks := parseBlocks(text)
+
+	var chunks []Chunk
+	var section []block
+	var crumbs []heading
+
+	flush := func() {
+		pieces := toPieces(section, c.size, c.atomicBlocks)
+		if len(pieces) == 0 {
+			return
+		}
+		prefix := renderBreadcrumb(crumbs)
+		for _, p := range pack(pieces, c.size, c.overlap) {
+			chunks = append(chunks, Chunk{Text: prefix + p, Headings: headingTexts(crumbs)})
+		}
+	}
+
+	for _, b := range blocks {
+		if b.kind == blockHeading && b.level <= c.maxLevel {
+			flush()
+			section = nil
+			crumbs = b.crumbs
+			continue
+		}
+		section = append(section, b)
+	}
+	flush()
+
+	// A document made of headings only still gets one chunk
+	if len(chunks) == 0 && strings.TrimSpace(text) != "" {
+		return wholeChunker{}.Split(text)
+	}
+	return chunks, nil
+}
+
+// paragraphChunker packs paragraphs into overlapping windows, ignoring the
+// document structure.
+type paragraphChunker struct {
+	size    int
+	overlap int
+}
+
+func (c paragraphChunker) Split(text string) ([]Chunk, error) {
+	blocks := parseBlocks(text)
+	var chunks []Chunk
+	for _, p := range pack(toPieces(blocks, c.size, false), c.size, c.overlap) {
+		chunks = append(chunks, Chunk{Text: p})
+	}
+
+	// Attach the breadcrumb in effect where each window starts
+	for i := range chunks {
+		idx := strings.Index(text, firstLine(chunks[i].Text))
+		if idx == -1 {
+			continue
+		}
+		_, chunks[i].Headings = breadcrumbAt(blocks, idx)
+	}
+	return chunks, nil
+}
+
+// pack groups pieces into chunks of at most size runes. Trailing pieces
+// totalling up to overlap runes are repeated at the start of the next chunk.
+// A single piece larger than size becomes its own chunk.
+func pack(pieces []string, size, overlap int) []string {
+	const sep = "\n\n"
+	var chunks []string
+	var current []string
+	currentLen := 0
+
+	for _, p := range pieces {
+		pLen := runeLen(p)
+		if len(current) > 0 && currentLen+pLen > size {
+			chunks = append(chunks, strings.Join(current, sep))
+
+			var carried []string
+			carriedLen := 0
+			for i := len(current) - 1; i >= 0; i-- {
+				l := runeLen(current[i]) + len(sep)
+				if carriedLen+l > overlap {
+					break
+				}
+				carried = append([]string{current[i]}, carried...)
+				carriedLen += l
+			}
+			if carriedLen+pLen > size {
+				carried, carriedLen = nil, 0
+			}
+			current, currentLen = carried, carriedLen
+		}
+		current = append(current, p)
+		currentLen += pLen + len(sep)
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, strings.Join(current, sep))
+	}
+	return chunks
+}
+
+// toPieces turns blocks into packable pieces. Text longer than size is split
+// into sentences (and hard-split if a sentence is still too long). Code blocks
+// and tables are kept whole when atomic is set.
+func toPieces(blocks []block, size int, atomic bool) []string {
+	var pieces []string
+	for _, b := range blocks {
+		if strings.TrimSpace(b.text) == "" {
+			continue
+		}
+		if runeLen(b.text) <= size || (atomic && (b.kind == blockCode || b.kind == blockTable)) {
+			pieces = append(pieces, b.text)
+			continue
+		}
+		for _, sentence := range splitSentences(b.text) {
+			pieces = append(pieces, hardSplit(sentence, size)...)
+		}
+	}
+	return pieces
+}
+
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	runes := []rune(text)
+	for i := 0; i < len(runes)-1; i++ {
+		if (runes[i] == '.' || runes[i] == '!' || runes[i] == '?' || runes[i] == '\n') && (runes[i+1] == ' ' || runes[i+1] == '\n') {
+			if s := strings.TrimSpace(string(runes[start : i+1])); s != "" {
+				sentences = append(sentences, s)
+			}
+			start = i + 1
+		}
+	}
+	if s := strings.TrimSpace(string(runes[start:])); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+func hardSplit(text string, size int) []string {
+	if size <= 0 || runeLen(text) <= size {
+		return []string{text}
+	}
+	var parts []string
+	runes := []rune(text)
+	for len(runes) > size {
+		parts = append(parts, string(runes[:size]))
+		runes = runes[size:]
+	}
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+func runeLen(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+func firstLine(s string) string {
+	if idx := strings.Index(s, "\n"); idx != -1 {
+		return s[:idx]
+	}
+	return s
+}
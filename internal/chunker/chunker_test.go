@@ -0,0 +1,95 @@
+package chunker_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/chunker"
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const doc = "# Billing\n\nIntro text.\n\n## Deploy\n\nHow we deploy.\n\n### Rollback\n\nRun `make rollback`.\n\n```sh\nmake deploy\n\nmake verify\n```\n\n| env | host |\n|-----|------|\n| prod | a |\n"
+
+func TestHeadingStrategy(t *testing.T) {
+	c, err := chunker.New(chunker.StrategyHeading, 1000, 0)
+	require.NoError(t, err)
+
+	chunks, err := c.Split(doc)
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+
+	assert.Equal(t, []string{"Billing", "Deploy", "Rollback"}, chunks[2].Headings)
+	assert.True(t, strings.HasPrefix(chunks[2].Text, "# Billing\n## Deploy\n### Rollback\n"))
+	assert.Contains(t, chunks[2].Text, "make deploy\n\nmake verify")
+	assert.Contains(t, chunks[2].Text, "| env | host |")
+}
+
+func TestCodeStrategyKeepsBlocksWhole(t *testing.T) {
+	c, err := chunker.New(chunker.StrategyCode, 20, 0)
+	require.NoError(t, err)
+
+	chunks, err := c.Split(doc)
+	require.NoError(t, err)
+
+	var sawCode, sawTable bool
+	for _, ch := range chunks {
+		if strings.Contains(ch.Text, "```sh") {
+			sawCode = true
+			assert.Contains(t, ch.Text, "make deploy\n\nmake verify\n```")
+		}
+		if strings.Contains(ch.Text, "| env | host |") {
+			sawTable = true
+			assert.Contains(t, ch.Text, "| prod | a |")
+		}
+	}
+	assert.True(t, sawCode, "code block should be emitted")
+	assert.True(t, sawTable, "table should be emitted")
+}
+
+func TestParagraphStrategyOverlap(t *testing.T) {
+	c, err := chunker.New(chunker.StrategyParagraph, 40, 20)
+	require.NoError(t, err)
+
+	chunks, err := c.Split("First paragraph.\n\nSecond one.\n\nThird paragraph here.")
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "First paragraph.\n\nSecond one.", chunks[0].Text)
+	assert.Equal(t, "Second one.\n\nThird paragraph here.", chunks[1].Text)
+}
+
+func TestWholeStrategy(t *testing.T) {
+	c, err := chunker.New(chunker.StrategyWhole, 10, 0)
+	require.NoError(t, err)
+
+	chunks, err := c.Split(doc)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, doc, chunks[0].Text)
+}
+
+func TestUnknownStrategy(t *testing.T) {
+	_, err := chunker.New("nope", 10, 0)
+	assert.Error(t, err)
+}
+
+func TestMatchedChunk(t *testing.T) {
+	cfg := config.Default()
+	cfg.Collections = []config.Collection{{Name: "notes", Chunker: chunker.StrategyHeading}}
+
+	chunk, ok := chunker.MatchedChunk(cfg, store.SearchResult{Filepath: "notes/billing.md", Chunk: "stored"})
+	assert.True(t, ok)
+	assert.Equal(t, "stored", chunk)
+
+	// Without stored text the document is split again
+	r := store.SearchResult{Filepath: "notes/billing.md", Title: "Billing", Body: doc, Seq: 1}
+	chunk, ok = chunker.MatchedChunk(cfg, r)
+	require.True(t, ok)
+	assert.Contains(t, chunk, "How we deploy.")
+
+	r.Seq = 10
+	_, ok = chunker.MatchedChunk(cfg, r)
+	assert.False(t, ok)
+}
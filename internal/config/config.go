@@ -1,11 +1,16 @@
 package config
 
+import "strings"
+
 type Collection struct {
 	Name    string            `json:"name"`
 	Path    string            `json:"path"`
 	Pattern string            `json:"pattern"`
 	Exclude []string          `json:"exclude"`
 	Context map[string]string `json:"context"`
+
+	// Chunker overrides the default chunking strategy for this collection
+	Chunker string `json:"chunker,omitempty"`
 }
 
 type Config struct {
@@ -20,8 +25,30 @@ type Config struct {
 	LocalLibPath   string `json:"local_lib_path"`
 
 	// Chunking Settings
-	ChunkSize    int `json:"chunk_size"`
-	ChunkOverlap int `json:"chunk_overlap"`
+	ChunkSize    int    `json:"chunk_size"`
+	ChunkOverlap int    `json:"chunk_overlap"`
+	Chunker      string `json:"chunker"`
+
+	// ContextualChunks prefixes every chunk sent to the embedder with the
+	// document title, collection, tags and heading breadcrumb.
+	ContextualChunks bool `json:"contextual_chunks"`
+
+	// DocVectors enables document level vectors ("mean" of chunk vectors or
+	// embedded title + "summary"), empty when disabled.
+	DocVectors string `json:"doc_vectors,omitempty"`
+
+	// Chat Settings: "ollama", "openai" or "local" provider, an empty URL
+	// selects the provider default.
+	ChatProvider    string   `json:"chat_provider"`
+	ChatURL         string   `json:"chat_url,omitempty"`
+	ChatModel       string   `json:"chat_model"`
+	ChatContextSize int      `json:"chat_context_size"`
+	ChatTemperature *float64 `json:"chat_temperature,omitempty"`
+	// ChatModelPath is the GGUF model of the "local" provider
+	ChatModelPath string `json:"chat_model_path,omitempty"`
+	// ChatMCPServers are external MCP servers whose tools are offered to
+	// the chat model next to qmd's own.
+	ChatMCPServers []MCPServer `json:"chat_mcp_servers,omitempty"`
 
 	// State
 	EmbeddingsConfigured bool `json:"embeddings_configured"`
@@ -30,6 +57,58 @@ type Config struct {
 	Collections []Collection `json:"collections"`
 }
 
+// MCPServer is an external MCP server: a command speaking MCP over stdio,
+// or the URL of a streamable HTTP server. ${VAR} references in the values
+// of Env and Headers are expanded from the environment when connecting, so
+// that secrets need not be stored.
+type MCPServer struct {
+	Name    string            `json:"name"`
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     []string          `json:"env,omitempty"` // KEY=VALUE, added to the environment of the command
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"` // Sent with the HTTP requests
+}
+
+// redactedValue replaces the values that may hold secrets in outputs.
+const redactedValue = "<redacted>"
+
+// Redacted returns a copy of the server whose environment variables and
+// headers only show their names.
+func (s MCPServer) Redacted() MCPServer {
+	var env []string
+	for _, kv := range s.Env {
+		key, _, _ := strings.Cut(kv, "=")
+		env = append(env, key+"="+redactedValue)
+	}
+	s.Env = env
+	if s.Headers != nil {
+		headers := make(map[string]string, len(s.Headers))
+		for k := range s.Headers {
+			headers[k] = redactedValue
+		}
+		s.Headers = headers
+	}
+	return s
+}
+
+// Redacted returns a copy of the configuration safe to print, with the
+// external MCP servers redacted.
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.ChatMCPServers = nil
+	for _, s := range c.ChatMCPServers {
+		out.ChatMCPServers = append(out.ChatMCPServers, s.Redacted())
+	}
+	return &out
+}
+
+// Document level vector modes
+const (
+	DocVectorsMean    = "mean"
+	DocVectorsSummary = "summary"
+)
+
 // Default settings
 func Default() *Config {
 	return &Config{
@@ -38,8 +117,22 @@ func Default() *Config {
 		EmbedDimensions:      768,
 		ChunkSize:            1000,
 		ChunkOverlap:         200,
+		Chunker:              "markdown",
 		Collections:          make([]Collection, 0),
 		UseLocal:             false,
+		ChatProvider:         "ollama",
+		ChatModel:            "llama3",
+		ChatContextSize:      8192,
 		EmbeddingsConfigured: false,
 	}
 }
+
+// ChunkerFor returns the chunking strategy used for a collection.
+func (c *Config) ChunkerFor(collection string) string {
+	for _, col := range c.Collections {
+		if col.Name == collection && col.Chunker != "" {
+			return col.Chunker
+		}
+	}
+	return c.Chunker
+}
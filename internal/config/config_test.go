@@ -0,0 +1,29 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedacted(t *testing.T) {
+	srv := config.MCPServer{
+		Name:    "issues",
+		URL:     "https://mcp.example.com/mcp",
+		Env:     []string{"TOKEN=${ISSUES_TOKEN}", "MODE=plain"},
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	}
+
+	redacted := srv.Redacted()
+	assert.Equal(t, []string{"TOKEN=<redacted>", "MODE=<redacted>"}, redacted.Env)
+	assert.Equal(t, map[string]string{"Authorization": "<redacted>"}, redacted.Headers)
+	assert.Equal(t, srv.URL, redacted.URL)
+	assert.Equal(t, "Bearer secret", srv.Headers["Authorization"], "the server is left unchanged")
+
+	cfg := config.Default()
+	cfg.ChatMCPServers = []config.MCPServer{srv}
+	out := cfg.Redacted()
+	assert.Equal(t, redacted, out.ChatMCPServers[0])
+	assert.Equal(t, srv, cfg.ChatMCPServers[0], "the configuration is left unchanged")
+}
@@ -0,0 +1,246 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/akhenakh/qmd/internal/util"
+	"github.com/hybridgroup/yzma/pkg/llama"
+)
+
+// LocalChatOptions configures a local chat model.
+type LocalChatOptions struct {
+	// ContextSize is the context window in tokens, capped at the model's
+	// training context.
+	ContextSize int
+	// Temperature overrides the default sampling temperature if not nil.
+	Temperature *float64
+}
+
+// LocalChatModel generates text in-process with llama.cpp.
+type LocalChatModel struct {
+	ModelFile string
+	Model     llama.Model
+	Context   llama.Context
+	Vocab     llama.Vocab
+	Sampler   llama.Sampler
+	NCtx      int
+	NBatch    int
+
+	// tokens mirrors the KV cache, so that a prompt extending the previous
+	// one (the usual case in a conversation) only decodes its new tokens.
+	tokens []llama.Token
+}
+
+func NewLocalChatModel(modelFile, libPath string, opts LocalChatOptions) (*LocalChatModel, error) {
+	if _, err := os.Stat(modelFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("model file not found: %s", modelFile)
+	}
+
+	if err := loadLlama(libPath); err != nil {
+		return nil, err
+	}
+	// llama.cpp logs to stderr, which would garble the chat UI
+	llama.LogSet(llama.LogSilent())
+
+	model, err := llama.ModelLoadFromFile(modelFile, llama.ModelDefaultParams())
+	if err != nil {
+		return nil, fmt.Errorf("unable to load model: %v", err)
+	}
+
+	nCtx := opts.ContextSize
+	if train := int(llama.ModelNCtxTrain(model)); train > 0 && (nCtx <= 0 || nCtx > train) {
+		nCtx = train
+	}
+	if nCtx <= 0 {
+		nCtx = 4096
+	}
+	nBatch := min(nCtx, 2048)
+
+	ctxParams := llama.ContextDefaultParams()
+	ctxParams.NCtx = uint32(nCtx)
+	ctxParams.NBatch = uint32(nBatch)
+	ctxParams.NUbatch = uint32(nBatch)
+
+	lctx, err := llama.InitFromModel(model, ctxParams)
+	if err != nil {
+		llama.ModelFree(model)
+		return nil, fmt.Errorf("unable to initialize context: %v", err)
+	}
+
+	sp := llama.DefaultSamplerParams()
+	if opts.Temperature != nil {
+		sp.Temp = float32(*opts.Temperature)
+	}
+	sampler := llama.NewSampler(model, llama.DefaultSamplers, sp)
+
+	util.Debug("LLM [Local] Chat model initialized. Model: %s, Context: %d", modelFile, nCtx)
+
+	return &LocalChatModel{
+		ModelFile: modelFile,
+		Model:     model,
+		Context:   lctx,
+		Vocab:     llama.ModelGetVocab(model),
+		Sampler:   sampler,
+		NCtx:      nCtx,
+		NBatch:    nBatch,
+	}, nil
+}
+
+// ChatTemplate returns the Jinja chat template stored in the model metadata,
+// or "" if the model has none.
+func (m *LocalChatModel) ChatTemplate() string {
+	return llama.ModelChatTemplate(m.Model, "")
+}
+
+// BOS and EOS return the text of the begin and end of sequence tokens, used
+// by chat templates.
+func (m *LocalChatModel) BOS() string { return m.piece(llama.VocabBOS(m.Vocab)) }
+func (m *LocalChatModel) EOS() string { return m.piece(llama.VocabEOS(m.Vocab)) }
+
+func (m *LocalChatModel) piece(token llama.Token) string {
+	if token == llama.TokenNull {
+		return ""
+	}
+	buf := make([]byte, 256)
+	n := llama.TokenToPiece(m.Vocab, token, buf, 0, true)
+	if n < 0 {
+		buf = make([]byte, -n)
+		n = llama.TokenToPiece(m.Vocab, token, buf, 0, true)
+	}
+	if n <= 0 {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+// Generate completes a rendered prompt until an end of generation token or
+// the end of the context window, calling onText with the text as it is
+// produced. Cancelling ctx stops the generation, returning the text so far.
+func (m *LocalChatModel) Generate(ctx context.Context, prompt string, onText func(string)) (string, error) {
+	util.Debug("LLM [Local] Chat Prompt:\n%s", prompt)
+
+	// Templates usually emit the BOS token themselves
+	bos := m.BOS()
+	tokens := llama.Tokenize(m.Vocab, prompt, bos == "" || !strings.HasPrefix(prompt, bos), true)
+	if len(tokens) == 0 {
+		return "", fmt.Errorf("empty prompt")
+	}
+	if len(tokens) >= m.NCtx {
+		return "", fmt.Errorf("prompt of %d tokens exceeds the context window of %d tokens", len(tokens), m.NCtx)
+	}
+
+	if err := m.decodePrompt(tokens); err != nil {
+		return "", err
+	}
+
+	llama.SamplerReset(m.Sampler)
+
+	var out strings.Builder
+	var pending []byte
+	for len(m.tokens) < m.NCtx {
+		if err := ctx.Err(); err != nil {
+			return out.String(), err
+		}
+		token := llama.SamplerSample(m.Sampler, m.Context, -1)
+		if llama.VocabIsEOG(m.Vocab, token) {
+			break
+		}
+
+		// Pieces may split multi-byte characters, only emit complete ones
+		pending = append(pending, m.piece(token)...)
+		if n := completeUTF8(pending); n > 0 {
+			text := string(pending[:n])
+			pending = pending[n:]
+			out.WriteString(text)
+			if onText != nil {
+				onText(text)
+			}
+		}
+
+		if err := m.decode([]llama.Token{token}); err != nil {
+			return out.String(), err
+		}
+	}
+	out.Write(pending)
+
+	if len(m.tokens) >= m.NCtx {
+		return out.String(), fmt.Errorf("context window of %d tokens is full", m.NCtx)
+	}
+	return out.String(), nil
+}
+
+// decodePrompt evaluates the prompt, keeping the part of the KV cache it
+// shares with the previous prompt and answer.
+func (m *LocalChatModel) decodePrompt(tokens []llama.Token) error {
+	common := 0
+	for common < len(m.tokens) && common < len(tokens) && m.tokens[common] == tokens[common] {
+		common++
+	}
+	// At least one token must be decoded to get the logits to sample from
+	if common == len(tokens) {
+		common--
+	}
+
+	mem, err := llama.GetMemory(m.Context)
+	if err != nil {
+		return fmt.Errorf("unable to access the KV cache: %w", err)
+	}
+	if ok, err := llama.MemorySeqRm(mem, 0, llama.Pos(common), -1); err != nil || !ok {
+		llama.MemoryClear(mem, true)
+		common = 0
+	}
+	m.tokens = m.tokens[:common]
+	util.Debug("LLM [Local] Reusing %d cached tokens of %d", common, len(tokens))
+
+	for start := common; start < len(tokens); start += m.NBatch {
+		end := min(start+m.NBatch, len(tokens))
+		if err := m.decode(tokens[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *LocalChatModel) decode(tokens []llama.Token) error {
+	ret, err := llama.Decode(m.Context, llama.BatchGetOne(tokens))
+	if err != nil {
+		return fmt.Errorf("llama decode failed: %w", err)
+	}
+	if ret != 0 {
+		return fmt.Errorf("llama decode failed with code %d", ret)
+	}
+	m.tokens = append(m.tokens, tokens...)
+	return nil
+}
+
+// completeUTF8 returns the length of the longest prefix of b that does not
+// end with an incomplete UTF-8 sequence.
+func completeUTF8(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(b[i]) {
+			continue
+		}
+		if utf8.FullRune(b[i:]) {
+			return len(b)
+		}
+		return i
+	}
+	return len(b)
+}
+
+func (m *LocalChatModel) Close() error {
+	if m.Sampler != 0 {
+		llama.SamplerFree(m.Sampler)
+	}
+	if m.Context != 0 {
+		llama.Free(m.Context)
+	}
+	if m.Model != 0 {
+		llama.ModelFree(m.Model)
+	}
+	return nil
+}
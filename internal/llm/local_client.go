@@ -6,11 +6,30 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/akhenakh/qmd/internal/util"
 	"github.com/hybridgroup/yzma/pkg/llama"
 )
 
+var (
+	llamaOnce sync.Once
+	llamaErr  error
+)
+
+// loadLlama loads the llama.cpp library and initializes its backend once per
+// process, as the embedder and the chat model may both run locally.
+func loadLlama(libPath string) error {
+	llamaOnce.Do(func() {
+		if err := llama.Load(libPath); err != nil {
+			llamaErr = fmt.Errorf("unable to load llama library from %s: %w", libPath, err)
+			return
+		}
+		llama.Init()
+	})
+	return llamaErr
+}
+
 type LocalClient struct {
 	ModelFile string
 	LibPath   string
@@ -26,14 +45,11 @@ func NewLocalClient(modelFile, libPath string, targetDim int) (*LocalClient, err
 		return nil, fmt.Errorf("model file not found: %s", modelFile)
 	}
 
-	// Load the shared library (llama.cpp)
-	if err := llama.Load(libPath); err != nil {
-		return nil, fmt.Errorf("unable to load llama library from %s: %w", libPath, err)
+	// Load the shared library (llama.cpp) and initialize the backend
+	if err := loadLlama(libPath); err != nil {
+		return nil, err
 	}
 
-	// Initialize backend
-	llama.Init()
-
 	// Load Model
 	model, err := llama.ModelLoadFromFile(modelFile, llama.ModelDefaultParams())
 	if err != nil {
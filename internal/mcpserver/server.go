@@ -4,13 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 
+	"github.com/akhenakh/qmd/internal/chunker"
 	"github.com/akhenakh/qmd/internal/config"
 	"github.com/akhenakh/qmd/internal/llm"
 	"github.com/akhenakh/qmd/internal/store"
 	"github.com/akhenakh/qmd/internal/util"
-	"github.com/tmc/langchaingo/textsplitter"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -31,14 +33,48 @@ type Server struct {
 }
 
 // Internal structures for JSON responses
-type searchResultJSON struct {
-	Filepath         string   `json:"filepath"`
-	Title            string   `json:"title"`
-	Score            float64  `json:"score"`
-	Size             int      `json:"size"`
-	Snippet          string   `json:"snippet,omitempty"`
-	Matches          []string `json:"matches,omitempty"`
-	FullFileReturned bool     `json:"full_file_returned,omitempty"`
+type linkJSON struct {
+	Source    string `json:"source"`
+	Line      int    `json:"line"`
+	Kind      string `json:"kind"`
+	Target    string `json:"target"`
+	Heading   string `json:"heading,omitempty"`
+	Alias     string `json:"alias,omitempty"`
+	Resolved  string `json:"resolved,omitempty"`
+	Broken    bool   `json:"broken,omitempty"`
+	Ambiguous bool   `json:"ambiguous,omitempty"`
+}
+
+type searchPageJSON struct {
+	Results       []store.SearchResultJSON `json:"results"`
+	Offset        int                      `json:"offset"`
+	TotalEstimate int                      `json:"total_estimate"`
+	NextOffset    int                      `json:"next_offset,omitempty"`
+}
+
+type documentPageJSON struct {
+	Path       string `json:"path"`
+	Content    string `json:"content"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	Offset     int    `json:"offset"`
+	TotalBytes int    `json:"total_bytes"`
+	TotalLines int    `json:"total_lines"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type sectionJSON struct {
+	Level     int    `json:"level,omitempty"`
+	Heading   string `json:"heading,omitempty"`
+	Path      string `json:"path,omitempty"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Content   string `json:"content,omitempty"`
+}
+
+type tagJSON struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
 
 type statusJSON struct {
@@ -80,12 +116,16 @@ func (s *Server) GetTools() []mcp.Tool {
 	return s.toolDefs
 }
 
-// CallTool executes a tool locally by name
+// CallTool executes a tool locally by name. Nothing runs once ctx is
+// cancelled.
 func (s *Server) CallTool(ctx context.Context, name string, args map[string]interface{}) (*mcp.CallToolResult, error) {
 	handler, ok := s.toolHandlers[name]
 	if !ok {
 		return nil, fmt.Errorf("tool not found: %s", name)
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Construct the request object expected by the mcp-go handler
 	req := mcp.CallToolRequest{
@@ -125,25 +165,34 @@ func (s *Server) addTool(tool mcp.Tool, handler server.ToolHandlerFunc) {
 
 func (s *Server) registerTools() {
 	searchTool := mcp.NewTool("search",
-		mcp.WithDescription("Full text search using BM25. Returns a JSON list of matches. Use context_lines to see surrounding text. If 'full_file_returned' is true, 'snippet' contains the complete document content and 'get_document' is not required."),
+		mcp.WithDescription("Full text search using BM25. Returns a JSON list of matches, or with 'offset' set a JSON page of matches ('results') with 'total_estimate' and 'next_offset' when more results are available. Use context_lines to see surrounding text. If 'full_file_returned' is true, 'snippet' contains the complete document content and 'get_document' is not required."),
 		mcp.WithString("query", mcp.Required(), mcp.Description("The search query")),
 		mcp.WithNumber("limit", mcp.DefaultNumber(10), mcp.Description("Max number of documents to return")),
+		mcp.WithNumber("offset", mcp.DefaultNumber(0), mcp.Description("Number of results to skip, use 'next_offset' of the previous response to get the next page")),
 		mcp.WithNumber("context_lines", mcp.DefaultNumber(1), mcp.Description("Number of lines to show before and after a match")),
 		mcp.WithBoolean("find_all", mcp.DefaultBool(false), mcp.Description("If true, returns all matches in a file instead of just the first one")),
+		mcp.WithString("collection", mcp.Description("Only return documents from this collection")),
+		mcp.WithArray("tags", mcp.WithStringItems(), mcp.Description("Only return documents carrying all these tags (a tag also matches its nested tags, e.g. 'area' matches 'area/sub')")),
 	)
 
 	s.addTool(searchTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		query, _ := request.RequireString("query")
 		limit := request.GetInt("limit", 10)
+		offset := request.GetInt("offset", 0)
 		contextLines := request.GetInt("context_lines", 1)
 		findAll := request.GetBool("find_all", false)
+		filter := store.Filter{
+			Collection: request.GetString("collection", ""),
+			Tags:       request.GetStringSlice("tags", nil),
+		}
 
-		results, err := s.store.SearchFTS(query, limit, contextLines, findAll)
+		results, err := s.store.SearchFTS(query, offset+limit, contextLines, findAll, filter)
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Search failed: %v", err)), nil
 		}
+		results = store.PageResults(results, offset, limit)
 
-		resp := make([]searchResultJSON, len(results))
+		resp := make([]store.SearchResultJSON, len(results))
 		for i, r := range results {
 			// Context Logic: Small files get full content
 			snippet := r.Snippet
@@ -153,37 +202,43 @@ func (s *Server) registerTools() {
 				fullFile = true
 			}
 
-			resp[i] = searchResultJSON{
+			resp[i] = store.SearchResultJSON{
 				Filepath:         r.Filepath,
 				Title:            r.Title,
 				Score:            r.Score,
 				Size:             r.Size,
+				Line:             s.matchLine(r, query),
 				Snippet:          snippet,
 				Matches:          r.Matches,
 				FullFileReturned: fullFile,
 			}
 		}
 
-		jsonBytes, err := json.Marshal(resp)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
-		}
-
-		return mcp.NewToolResultText(string(jsonBytes)), nil
+		return searchPage(request, resp, offset, limit, func() (int, error) { return s.store.CountFTS(query, filter) })
 	})
 
 	// Vector Search Tool
 	vsearchTool := mcp.NewTool("vsearch",
-		mcp.WithDescription("Semantic search using vector embeddings. Returns the matched text chunk. If 'full_file_returned' is true, 'snippet' contains the complete document content and 'get_document' is not required."),
+		mcp.WithDescription("Semantic search using vector embeddings. Returns a JSON list of results with the matched text chunk, or with 'offset' set a JSON page ('results') with 'total_estimate' and 'next_offset' when more results are available. If 'full_file_returned' is true, 'snippet' contains the complete document content and 'get_document' is not required."),
 		mcp.WithString("query", mcp.Required(), mcp.Description("The search query")),
 		mcp.WithNumber("limit", mcp.DefaultNumber(10), mcp.Description("Max number of results")),
+		mcp.WithNumber("offset", mcp.DefaultNumber(0), mcp.Description("Number of results to skip, use 'next_offset' of the previous response to get the next page")),
 		mcp.WithNumber("context_lines", mcp.DefaultNumber(0), mcp.Description("Number of lines to show before and after the matched chunk")),
+		mcp.WithNumber("coarse_documents", mcp.DefaultNumber(0), mcp.Description("If > 0 and document vectors are enabled, only search chunks of this many closest documents")),
+		mcp.WithString("collection", mcp.Description("Only return documents from this collection")),
+		mcp.WithArray("tags", mcp.WithStringItems(), mcp.Description("Only return documents carrying all these tags (a tag also matches its nested tags, e.g. 'area' matches 'area/sub')")),
 	)
 
 	s.addTool(vsearchTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		query, _ := request.RequireString("query")
 		limit := request.GetInt("limit", 10)
+		offset := request.GetInt("offset", 0)
 		contextLines := request.GetInt("context_lines", 0)
+		coarseDocs := request.GetInt("coarse_documents", 0)
+		filter := store.Filter{
+			Collection: request.GetString("collection", ""),
+			Tags:       request.GetStringSlice("tags", nil),
+		}
 
 		// Generate embedding
 		vec, err := s.llm.Embed(query, true)
@@ -191,19 +246,18 @@ func (s *Server) registerTools() {
 			return mcp.NewToolResultError(fmt.Sprintf("Embedding generation failed: %v", err)), nil
 		}
 
-		results, err := s.store.SearchVec(vec, limit)
+		var results []store.SearchResult
+		if coarseDocs > 0 && s.config.DocVectors != "" {
+			results, err = s.store.SearchVecCoarse(vec, coarseDocs, offset+limit, filter)
+		} else {
+			results, err = s.store.SearchVec(vec, offset+limit, filter)
+		}
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Vector search failed: %v", err)), nil
 		}
+		results = store.PageResults(results, offset, limit)
 
-		// Initialize splitter to reconstruct chunks
-		splitter := textsplitter.NewMarkdownTextSplitter(
-			textsplitter.WithChunkSize(s.config.ChunkSize),
-			textsplitter.WithChunkOverlap(s.config.ChunkOverlap),
-			textsplitter.WithHeadingHierarchy(true),
-		)
-
-		resp := make([]searchResultJSON, len(results))
+		resp := make([]store.SearchResultJSON, len(results))
 		for i, r := range results {
 			var snippet string
 			fullFile := false
@@ -215,15 +269,7 @@ func (s *Server) registerTools() {
 			} else {
 				// Large files get specific chunk + context
 				snippet = r.Snippet // Default to beginning/summary if splitting fails
-				contentToSplit := r.Body
-				titleHeader := fmt.Sprintf("# %s", r.Title)
-				if !strings.Contains(r.Body, titleHeader) {
-					contentToSplit = fmt.Sprintf("%s\n\n%s", titleHeader, r.Body)
-				}
-
-				chunks, err := splitter.SplitText(contentToSplit)
-				if err == nil && r.Seq < len(chunks) {
-					chunkText := chunks[r.Seq]
+				if chunkText, ok := chunker.MatchedChunk(s.config, r); ok {
 					if contextLines > 0 {
 						// Expand context around the chunk
 						snippet = extractContext(r.Body, chunkText, contextLines)
@@ -233,30 +279,34 @@ func (s *Server) registerTools() {
 				}
 			}
 
-			resp[i] = searchResultJSON{
+			resp[i] = store.SearchResultJSON{
 				Filepath:         r.Filepath,
 				Title:            r.Title,
 				Score:            r.Score,
 				Size:             r.Size,
+				Line:             s.matchLine(r, query),
 				Snippet:          snippet,
 				FullFileReturned: fullFile,
 			}
 		}
 
-		jsonBytes, err := json.Marshal(resp)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
-		}
-
-		return mcp.NewToolResultText(string(jsonBytes)), nil
+		return searchPage(request, resp, offset, limit, func() (int, error) { return s.store.CountVec(filter) })
 	})
 
 	// Hybrid Query Tool
 	queryTool := mcp.NewTool("query",
-		mcp.WithDescription("Hybrid search using both keywords and semantic meaning (RRF). Best for most queries. If 'full_file_returned' is true, 'snippet' contains the complete document content and 'get_document' is not required."),
+		mcp.WithDescription("Hybrid search using both keywords and semantic meaning (RRF). Best for most queries. Returns a JSON list of results, or with 'offset' set a JSON page ('results') with 'total_estimate' and 'next_offset' when more results are available. If 'full_file_returned' is true, 'snippet' contains the complete document content and 'get_document' is not required."),
 		mcp.WithString("query", mcp.Required(), mcp.Description("The search query")),
 		mcp.WithNumber("limit", mcp.DefaultNumber(10), mcp.Description("Max number of results")),
+		mcp.WithNumber("offset", mcp.DefaultNumber(0), mcp.Description("Number of results to skip, use 'next_offset' of the previous response to get the next page")),
 		mcp.WithNumber("context_lines", mcp.DefaultNumber(1), mcp.Description("Number of lines to show before and after the match")),
+		mcp.WithBoolean("graph", mcp.Description("Boost documents central in the link graph and include documents linked to the top results")),
+		mcp.WithBoolean("explain", mcp.Description("Add to each result an 'explain' object with its BM25 and vector ranks and scores, the matching chunk seq and the RRF contribution of each source")),
+		mcp.WithNumber("min_bm25", mcp.Description("Drop keyword candidates with a BM25 score below this value")),
+		mcp.WithNumber("min_similarity", mcp.Description("Drop vector candidates with a cosine similarity below this value (0-1)")),
+		mcp.WithNumber("min_score", mcp.Description("Drop results with a fused RRF score below this value")),
+		mcp.WithString("collection", mcp.Description("Only return documents from this collection")),
+		mcp.WithArray("tags", mcp.WithStringItems(), mcp.Description("Only return documents carrying all these tags (a tag also matches its nested tags, e.g. 'area' matches 'area/sub')")),
 	)
 
 	s.addTool(queryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -266,7 +316,21 @@ func (s *Server) registerTools() {
 
 		query, _ := request.RequireString("query")
 		limit := request.GetInt("limit", 10)
+		offset := request.GetInt("offset", 0)
 		contextLines := request.GetInt("context_lines", 1)
+		graph := request.GetBool("graph", false)
+		filter := store.Filter{
+			Collection: request.GetString("collection", ""),
+			Tags:       request.GetStringSlice("tags", nil),
+		}
+		opts := store.HybridOptions{
+			Graph:         graph,
+			Filter:        filter,
+			MinBM25:       request.GetFloat("min_bm25", 0),
+			MinSimilarity: request.GetFloat("min_similarity", 0),
+			MinScore:      request.GetFloat("min_score", 0),
+			Explain:       request.GetBool("explain", false),
+		}
 
 		// Generate embedding
 		vec, err := s.llm.Embed(query, true)
@@ -275,19 +339,13 @@ func (s *Server) registerTools() {
 		}
 
 		// Pass contextLines to hybrid search
-		results, err := s.store.SearchHybrid(query, vec, limit, contextLines)
+		results, err := s.store.SearchHybrid(query, vec, offset+limit, contextLines, opts)
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Hybrid search failed: %v", err)), nil
 		}
+		results = store.PageResults(results, offset, limit)
 
-		// Initialize splitter for vector fallback cases
-		splitter := textsplitter.NewMarkdownTextSplitter(
-			textsplitter.WithChunkSize(s.config.ChunkSize),
-			textsplitter.WithChunkOverlap(s.config.ChunkOverlap),
-			textsplitter.WithHeadingHierarchy(true),
-		)
-
-		resp := make([]searchResultJSON, len(results))
+		resp := make([]store.SearchResultJSON, len(results))
 		for i, r := range results {
 			var finalSnippet string
 			fullFile := false
@@ -302,14 +360,7 @@ func (s *Server) registerTools() {
 
 				if len(r.Matches) == 0 && r.Body != "" {
 					// Vector result -> chunk extraction
-					contentToSplit := r.Body
-					titleHeader := fmt.Sprintf("# %s", r.Title)
-					if !strings.Contains(r.Body, titleHeader) {
-						contentToSplit = fmt.Sprintf("%s\n\n%s", titleHeader, r.Body)
-					}
-					chunks, err := splitter.SplitText(contentToSplit)
-					if err == nil && r.Seq < len(chunks) {
-						chunkText := chunks[r.Seq]
+					if chunkText, ok := chunker.MatchedChunk(s.config, r); ok {
 						if contextLines > 0 {
 							finalSnippet = extractContext(r.Body, chunkText, contextLines)
 						} else {
@@ -322,17 +373,90 @@ func (s *Server) registerTools() {
 				}
 			}
 
-			resp[i] = searchResultJSON{
+			resp[i] = store.SearchResultJSON{
 				Filepath:         r.Filepath,
 				Title:            r.Title,
 				Score:            r.Score,
 				Size:             r.Size,
+				Line:             s.matchLine(r, query),
 				Snippet:          finalSnippet,
 				Matches:          r.Matches,
 				FullFileReturned: fullFile,
+				Explain:          r.Explain,
 			}
 		}
 
+		return searchPage(request, resp, offset, limit, func() (int, error) { return s.store.CountHybrid(query, filter) })
+	})
+
+	// Similar Documents Tool
+	similarTool := mcp.NewTool("similar_documents",
+		mcp.WithDescription("Find documents related to a given document using its stored embeddings (no re-embedding). Returns a JSON list of documents, excluding the document itself, or with 'offset' set a JSON page ('results') with 'total_estimate' and 'next_offset' when more results are available."),
+		mcp.WithString("path", mcp.Required(), mcp.Description("The document path (e.g., 'notes/postgres-tuning.md')")),
+		mcp.WithNumber("limit", mcp.DefaultNumber(10), mcp.Description("Max number of documents to return")),
+		mcp.WithNumber("offset", mcp.DefaultNumber(0), mcp.Description("Number of results to skip, use 'next_offset' of the previous response to get the next page")),
+		mcp.WithBoolean("keywords", mcp.DefaultBool(false), mcp.Description("If true, also fuse in a keyword search on the document's most frequent terms")),
+		mcp.WithString("collection", mcp.Description("Only return documents from this collection")),
+		mcp.WithArray("tags", mcp.WithStringItems(), mcp.Description("Only return documents carrying all these tags (a tag also matches its nested tags, e.g. 'area' matches 'area/sub')")),
+	)
+
+	s.addTool(similarTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pathStr, _ := request.RequireString("path")
+		limit := request.GetInt("limit", 10)
+		offset := request.GetInt("offset", 0)
+		keywords := request.GetBool("keywords", false)
+		filter := store.Filter{
+			Collection: request.GetString("collection", ""),
+			Tags:       request.GetStringSlice("tags", nil),
+		}
+
+		collection, relPath, err := util.SplitDocPath(pathStr)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		results, err := s.store.SimilarDocuments(collection, relPath, offset+limit, keywords, filter)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Similar search failed: %v", err)), nil
+		}
+		results = store.PageResults(results, offset, limit)
+
+		resp := make([]store.SearchResultJSON, len(results))
+		for i, r := range results {
+			resp[i] = store.SearchResultJSON{
+				Filepath: r.Filepath,
+				Title:    r.Title,
+				Score:    r.Score,
+				Size:     r.Size,
+				Snippet:  r.Snippet,
+			}
+		}
+
+		return searchPage(request, resp, offset, limit, func() (int, error) { return s.store.CountSimilar(filter) })
+	})
+
+	// List Tags Tool
+	listTagsTool := mcp.NewTool("list_tags",
+		mcp.WithDescription("List the tags used in the notes (front matter 'tags:' and inline #tags) with their document counts. Nested tags are written 'area/sub' and also count for their parent. Use the tags to filter search, vsearch and query."),
+		mcp.WithString("collection", mcp.Description("Only count documents from this collection")),
+		mcp.WithString("prefix", mcp.Description("Only list this tag and its nested tags (e.g. 'area')")),
+	)
+
+	s.addTool(listTagsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		tags, err := s.store.ListTags(request.GetString("collection", ""))
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to list tags: %v", err)), nil
+		}
+
+		prefix := util.NormalizeTag(request.GetString("prefix", ""))
+		resp := []tagJSON{}
+		for _, t := range tags {
+			if prefix != "" && t.Tag != prefix && !strings.HasPrefix(t.Tag, prefix+"/") {
+				continue
+			}
+			resp = append(resp, tagJSON{Tag: t.Tag, Count: t.Count})
+		}
+
 		jsonBytes, err := json.Marshal(resp)
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
@@ -341,28 +465,175 @@ func (s *Server) registerTools() {
 		return mcp.NewToolResultText(string(jsonBytes)), nil
 	})
 
+	// Link Graph Tools
+	getLinksTool := mcp.NewTool("get_links",
+		mcp.WithDescription("List the outgoing links ([[wiki]] and markdown) of a document, with the document each link resolves to. Broken links have 'broken' set."),
+		mcp.WithString("path", mcp.Required(), mcp.Description("The document path (e.g., 'notes/meeting.md')")),
+	)
+
+	s.addTool(getLinksTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return s.linksResult(request, s.store.GetLinks)
+	})
+
+	getBacklinksTool := mcp.NewTool("get_backlinks",
+		mcp.WithDescription("List the documents linking to a document, with the line of each link"),
+		mcp.WithString("path", mcp.Required(), mcp.Description("The document path (e.g., 'notes/meeting.md')")),
+	)
+
+	s.addTool(getBacklinksTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return s.linksResult(request, s.store.GetBacklinks)
+	})
+
 	// Get Document Tool
 	getTool := mcp.NewTool("get_document",
-		mcp.WithDescription("Retrieve the full content of a specific document"),
+		mcp.WithDescription("Retrieve the content of a specific document, whole or one page at a time. The response gives the total size and line count; when 'next_cursor' is set, pass it as 'cursor' to read the following page. For large documents, set 'max_bytes' or a line range, or use 'get_outline'/'get_section'."),
 		mcp.WithString("path", mcp.Required(), mcp.Description("The document path (e.g., 'notes/meeting.md')")),
+		mcp.WithNumber("start_line", mcp.Description("First line to return (1-based)")),
+		mcp.WithNumber("end_line", mcp.Description("Last line to return (inclusive)")),
+		mcp.WithNumber("offset", mcp.Description("Byte offset to start from, used when 'start_line' is not set")),
+		mcp.WithNumber("max_bytes", mcp.Description("Maximum number of bytes to return; pages end on a line boundary")),
+		mcp.WithString("cursor", mcp.Description("The 'next_cursor' of a previous response, to continue reading")),
+		mcp.WithBoolean("line_numbers", mcp.DefaultBool(false), mcp.Description("Prefix each line with its line number")),
 	)
 
 	s.addTool(getTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		pathStr, _ := request.RequireString("path")
 
-		// Path format expected: collection/path/to/file.md
-		parts := strings.SplitN(pathStr, "/", 2)
-		if len(parts) < 2 {
-			return mcp.NewToolResultError("Invalid path format. Expected 'collection/path'"), nil
+		collection, relPath, err := util.SplitDocPath(pathStr)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		collection, relPath := parts[0], parts[1]
 
 		content, err := s.store.GetDocument(collection, relPath)
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to get document: %v", err)), nil
 		}
 
-		return mcp.NewToolResultText(content), nil
+		req, err := pageRequest(util.PageRequest{
+			StartLine: request.GetInt("start_line", 0),
+			EndLine:   request.GetInt("end_line", 0),
+			Offset:    request.GetInt("offset", 0),
+			MaxBytes:  request.GetInt("max_bytes", 0),
+		}, request.GetString("cursor", ""))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		page := util.Paginate(content, req)
+		text := page.Text
+		if request.GetBool("line_numbers", false) {
+			text = util.NumberLines(text, page.StartLine)
+		}
+
+		resp := documentPageJSON{
+			Path:       collection + "/" + relPath,
+			Content:    text,
+			StartLine:  page.StartLine,
+			EndLine:    page.EndLine,
+			Offset:     page.Offset,
+			TotalBytes: page.TotalBytes,
+			TotalLines: page.TotalLines,
+			NextCursor: page.NextCursor,
+		}
+
+		jsonBytes, err := json.Marshal(resp)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Outline Tool
+	outlineTool := mcp.NewTool("get_outline",
+		mcp.WithDescription("Get the heading tree of a document with the line range of each section. Use it to navigate large documents, then fetch a single part with 'get_section'."),
+		mcp.WithString("path", mcp.Required(), mcp.Description("The document path (e.g., 'notes/runbook.md')")),
+	)
+
+	s.addTool(outlineTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pathStr, _ := request.RequireString("path")
+		collection, relPath, err := util.SplitDocPath(pathStr)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		sections, err := s.store.GetOutline(collection, relPath)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to get outline: %v", err)), nil
+		}
+
+		resp := make([]sectionJSON, len(sections))
+		for i, sec := range sections {
+			resp[i] = sectionJSON{
+				Level:     sec.Level,
+				Heading:   sec.Heading,
+				Path:      strings.Join(sec.Path, "/"),
+				StartLine: sec.Line,
+				EndLine:   sec.EndLine,
+			}
+		}
+
+		jsonBytes, err := json.Marshal(resp)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Section Tool
+	sectionTool := mcp.NewTool("get_section",
+		mcp.WithDescription("Retrieve one section of a document, by heading path (e.g. 'Deploy/Rollback', as returned by 'get_outline') or by line range. Returns the content with its line range."),
+		mcp.WithString("path", mcp.Required(), mcp.Description("The document path (e.g., 'notes/runbook.md')")),
+		mcp.WithString("heading", mcp.Description("Heading path, ancestors separated by '/'. A unique heading name alone is enough")),
+		mcp.WithNumber("start_line", mcp.Description("First line to return (1-based), used when 'heading' is not set")),
+		mcp.WithNumber("end_line", mcp.Description("Last line to return (inclusive), defaults to the end of the document")),
+	)
+
+	s.addTool(sectionTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pathStr, _ := request.RequireString("path")
+		headingPath := request.GetString("heading", "")
+		startLine := request.GetInt("start_line", 0)
+		endLine := request.GetInt("end_line", 0)
+
+		collection, relPath, err := util.SplitDocPath(pathStr)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if headingPath == "" && startLine <= 0 {
+			return mcp.NewToolResultError("Either 'heading' or 'start_line' is required"), nil
+		}
+
+		content, err := s.store.GetDocument(collection, relPath)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to get document: %v", err)), nil
+		}
+
+		resp := sectionJSON{StartLine: startLine, EndLine: endLine}
+		if headingPath != "" {
+			sec, err := s.store.FindSection(collection, relPath, headingPath)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			resp = sectionJSON{
+				Level:     sec.Level,
+				Heading:   sec.Heading,
+				Path:      strings.Join(sec.Path, "/"),
+				StartLine: sec.Line,
+				EndLine:   sec.EndLine,
+			}
+		}
+		if total := util.LineCount(content); resp.EndLine <= 0 || resp.EndLine > total {
+			resp.EndLine = total
+		}
+		resp.Content = util.LineRange(content, resp.StartLine, resp.EndLine)
+
+		jsonBytes, err := json.Marshal(resp)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(string(jsonBytes)), nil
 	})
 
 	// Status Tool
@@ -393,9 +664,47 @@ func (s *Server) registerTools() {
 	})
 }
 
+// linksResult runs a link query for the document in the 'path' argument and
+// returns the links as JSON.
+func (s *Server) linksResult(request mcp.CallToolRequest, query func(collection, path string) ([]store.Link, error)) (*mcp.CallToolResult, error) {
+	pathStr, _ := request.RequireString("path")
+	collection, relPath, err := util.SplitDocPath(pathStr)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	links, err := query(collection, relPath)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Failed to get links: %v", err)), nil
+	}
+
+	resp := make([]linkJSON, len(links))
+	for i, l := range links {
+		resp[i] = linkJSON{
+			Source:    l.Source,
+			Line:      l.Line,
+			Kind:      l.Kind,
+			Target:    l.Target,
+			Heading:   l.Heading,
+			Alias:     l.Alias,
+			Resolved:  l.Resolved,
+			Broken:    l.Resolved == "",
+			Ambiguous: l.Ambiguous,
+		}
+	}
+
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
+	}
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
 func (s *Server) registerResources() {
 	// Template for accessing any document: qmd://{collection}/{path}
 	// Note: URI templates in MCP are RFC 6570. {+path} handles slashes.
+	// A page can be requested with query parameters, e.g.
+	// qmd://notes/big.md?start_line=100&end_line=200 or ?cursor=offset:4096&max_bytes=4096
 
 	s.mcp.AddResourceTemplate(
 		mcp.NewResourceTemplate("qmd://{collection}/{+path}", "Document"),
@@ -423,29 +732,133 @@ func (s *Server) registerResources() {
 				return nil, fmt.Errorf("invalid path argument")
 			}
 
+			path, rawQuery, paged := strings.Cut(path, "?")
+
 			content, err := s.store.GetDocument(collection, path)
 			if err != nil {
 				// Resource not found logic
 				return nil, fmt.Errorf("document not found: %w", err)
 			}
 
+			if !paged {
+				return []mcp.ResourceContents{
+					mcp.TextResourceContents{
+						URI:      request.Params.URI,
+						MIMEType: "text/markdown",
+						Text:     content,
+					},
+				}, nil
+			}
+
+			query, err := url.ParseQuery(rawQuery)
+			if err != nil {
+				return nil, fmt.Errorf("invalid query: %w", err)
+			}
+			req, err := pageRequest(util.PageRequest{
+				StartLine: queryInt(query, "start_line"),
+				EndLine:   queryInt(query, "end_line"),
+				Offset:    queryInt(query, "offset"),
+				MaxBytes:  queryInt(query, "max_bytes"),
+			}, query.Get("cursor"))
+			if err != nil {
+				return nil, err
+			}
+
+			page := util.Paginate(content, req)
+			text := page.Text
+			if query.Get("line_numbers") == "true" {
+				text = util.NumberLines(text, page.StartLine)
+			}
+
+			meta := map[string]any{
+				"start_line":  page.StartLine,
+				"end_line":    page.EndLine,
+				"offset":      page.Offset,
+				"total_bytes": page.TotalBytes,
+				"total_lines": page.TotalLines,
+			}
+			if page.NextCursor != "" {
+				meta["next_cursor"] = page.NextCursor
+			}
+
 			return []mcp.ResourceContents{
 				mcp.TextResourceContents{
+					Meta:     meta,
 					URI:      request.Params.URI,
 					MIMEType: "text/markdown",
-					Text:     content,
+					Text:     text,
 				},
 			}, nil
 		},
 	)
 }
 
+// searchPage returns a page of search results with the estimated number of
+// results and the offset of the next page, if any. Requests without an
+// offset get the bare JSON list of results, as before paging existed.
+func searchPage(request mcp.CallToolRequest, results []store.SearchResultJSON, offset, limit int, total func() (int, error)) (*mcp.CallToolResult, error) {
+	if _, ok := request.GetArguments()["offset"]; !ok {
+		jsonBytes, err := json.Marshal(results)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	page := searchPageJSON{Results: results, Offset: offset}
+	n, err := total()
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Counting results failed: %v", err)), nil
+	}
+	page.TotalEstimate = max(n, offset+len(results))
+	if len(results) == limit && page.TotalEstimate > offset+len(results) {
+		page.NextOffset = offset + len(results)
+	}
+
+	jsonBytes, err := json.Marshal(page)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("JSON marshal failed: %v", err)), nil
+	}
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// pageRequest resolves the page to read: a continuation cursor replaces the
+// requested window, keeping max_bytes.
+func pageRequest(req util.PageRequest, cursor string) (util.PageRequest, error) {
+	if cursor != "" {
+		return util.ParseCursor(cursor, req.MaxBytes)
+	}
+	if req.EndLine > 0 && req.StartLine <= 0 {
+		req.StartLine = 1
+	}
+	if req.EndLine > 0 && req.EndLine < req.StartLine {
+		return req, fmt.Errorf("end_line %d is before start_line %d", req.EndLine, req.StartLine)
+	}
+	return req, nil
+}
+
+// queryInt returns an integer query parameter, 0 if absent or invalid.
+func queryInt(query url.Values, key string) int {
+	n, _ := strconv.Atoi(query.Get(key))
+	return n
+}
+
 // GetUnderlyingServer exposes the raw MCP server instance.
 // This is used for creating in-process clients (e.g. for the chat command).
 func (s *Server) GetUnderlyingServer() *server.MCPServer {
 	return s.mcp
 }
 
+// matchLine returns the line of a result's document where it matched: the
+// keyword match, or the start of the matched chunk for vector results.
+func (s *Server) matchLine(r store.SearchResult, query string) int {
+	var chunk string
+	if len(r.Matches) == 0 {
+		chunk, _ = chunker.MatchedChunk(s.config, r)
+	}
+	return util.MatchLine(r.Body, query, chunk)
+}
+
 // extractContext locates the chunk within the full body and returns the chunk
 // extended by n lines before and after.
 func extractContext(body string, chunk string, n int) string {
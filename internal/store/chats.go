@@ -0,0 +1,200 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChatSession is a saved chat conversation.
+type ChatSession struct {
+	ID           int64     `json:"id"`
+	Title        string    `json:"title"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	MessageCount int       `json:"message_count"`
+}
+
+// ChatMessage is a message of a saved conversation. ToolCalls holds the
+// tool calls of an assistant message as JSON, ToolCallID and Name identify
+// the call a tool message answers.
+type ChatMessage struct {
+	Seq        int             `json:"seq"`
+	Role       string          `json:"role"`
+	Content    string          `json:"content"`
+	ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// chatTitleLen is the maximum length of a conversation title.
+const chatTitleLen = 60
+
+// CreateChatSession starts a new conversation and returns its ID.
+func (s *Store) CreateChatSession(provider, model string) (int64, error) {
+	now := time.Now().Format(time.RFC3339)
+	res, err := s.DB.Exec(`
+		INSERT INTO chat_sessions (title, provider, model, created_at, updated_at)
+		VALUES ('', ?, ?, ?, ?)
+	`, provider, model, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// AppendChatMessages adds messages to a conversation. The first user
+// message becomes the title of the conversation.
+func (s *Store) AppendChatMessages(sessionID int64, msgs []ChatMessage) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var title string
+	var seq int
+	err = tx.QueryRow(`
+		SELECT title, (SELECT COALESCE(MAX(seq) + 1, 0) FROM chat_messages WHERE session_id = ?)
+		FROM chat_sessions WHERE id = ?
+	`, sessionID, sessionID).Scan(&title, &seq)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("chat session %d not found", sessionID)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, m := range msgs {
+		createdAt := m.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = now
+		}
+		var toolCalls interface{}
+		if len(m.ToolCalls) > 0 {
+			toolCalls = string(m.ToolCalls)
+		}
+		_, err := tx.Exec(`
+			INSERT INTO chat_messages (session_id, seq, role, content, tool_calls, tool_call_id, name, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, sessionID, seq, m.Role, m.Content, toolCalls, m.ToolCallID, m.Name, createdAt.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		seq++
+
+		if title == "" && m.Role == "user" {
+			title = chatTitle(m.Content)
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE chat_sessions SET title = ?, updated_at = ? WHERE id = ?`,
+		title, now.Format(time.RFC3339), sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateChatMessage replaces the content of a message of a conversation.
+func (s *Store) UpdateChatMessage(sessionID int64, seq int, content string) error {
+	res, err := s.DB.Exec(`UPDATE chat_messages SET content = ? WHERE session_id = ? AND seq = ?`,
+		content, sessionID, seq)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("message %d of chat session %d not found", seq, sessionID)
+	}
+	_, err = s.DB.Exec(`UPDATE chat_sessions SET updated_at = ? WHERE id = ?`,
+		time.Now().Format(time.RFC3339), sessionID)
+	return err
+}
+
+// chatTitle shortens the first line of a message to a title.
+func chatTitle(content string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if r := []rune(title); len(r) > chatTitleLen {
+		title = strings.TrimSpace(string(r[:chatTitleLen-1])) + "…"
+	}
+	return title
+}
+
+// GetChatSession returns a conversation, or an error if it does not exist.
+func (s *Store) GetChatSession(id int64) (*ChatSession, error) {
+	sessions, err := s.queryChatSessions(`WHERE cs.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("chat session %d not found", id)
+	}
+	return &sessions[0], nil
+}
+
+// ListChatSessions returns the conversations, most recently updated first.
+// A limit of 0 returns them all.
+func (s *Store) ListChatSessions(limit int) ([]ChatSession, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	return s.queryChatSessions(`ORDER BY cs.updated_at DESC, cs.id DESC LIMIT ?`, limit)
+}
+
+func (s *Store) queryChatSessions(clause string, args ...interface{}) ([]ChatSession, error) {
+	rows, err := s.DB.Query(`
+		SELECT cs.id, cs.title, cs.provider, cs.model, cs.created_at, cs.updated_at,
+			(SELECT COUNT(*) FROM chat_messages m WHERE m.session_id = cs.id)
+		FROM chat_sessions cs `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []ChatSession
+	for rows.Next() {
+		var cs ChatSession
+		var created, updated string
+		if err := rows.Scan(&cs.ID, &cs.Title, &cs.Provider, &cs.Model, &created, &updated, &cs.MessageCount); err != nil {
+			return nil, err
+		}
+		cs.CreatedAt, _ = time.Parse(time.RFC3339, created)
+		cs.UpdatedAt, _ = time.Parse(time.RFC3339, updated)
+		sessions = append(sessions, cs)
+	}
+	return sessions, rows.Err()
+}
+
+// GetChatMessages returns the messages of a conversation in order.
+func (s *Store) GetChatMessages(sessionID int64) ([]ChatMessage, error) {
+	rows, err := s.DB.Query(`
+		SELECT seq, role, content, COALESCE(tool_calls, ''), tool_call_id, name, created_at
+		FROM chat_messages
+		WHERE session_id = ?
+		ORDER BY seq
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []ChatMessage
+	for rows.Next() {
+		var m ChatMessage
+		var toolCalls, created string
+		if err := rows.Scan(&m.Seq, &m.Role, &m.Content, &toolCalls, &m.ToolCallID, &m.Name, &created); err != nil {
+			return nil, err
+		}
+		if toolCalls != "" {
+			m.ToolCalls = json.RawMessage(toolCalls)
+		}
+		m.CreatedAt, _ = time.Parse(time.RFC3339, created)
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+)
+
+// SaveDocEmbedding stores the document level vector of a document version.
+func (s *Store) SaveDocEmbedding(hash string, vec []float32) error {
+	blob, err := sqlite_vec.SerializeFloat32(vec)
+	if err != nil {
+		return err
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// vec0 tables do not support INSERT OR REPLACE on the primary key
+	if _, err := tx.Exec(`DELETE FROM doc_vectors_vec WHERE hash = ?`, hash); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO doc_vectors_vec (hash, embedding) VALUES (?, ?)`, hash, blob); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetDocEmbeddings deletes all document level vectors.
+func (s *Store) ResetDocEmbeddings() error {
+	if !s.hasTable("doc_vectors_vec") {
+		return nil
+	}
+	_, err := s.DB.Exec(`DELETE FROM doc_vectors_vec`)
+	return err
+}
+
+// GetPendingDocEmbeddings returns the documents whose chunks are embedded but
+// which have no document level vector yet.
+func (s *Store) GetPendingDocEmbeddings() (map[string]PendingDoc, error) {
+	rows, err := s.DB.Query(`
+        SELECT d.hash, d.title, d.collection, c.doc
+        FROM documents d
+        JOIN content c ON d.hash = c.hash
+        WHERE d.hash IN (SELECT hash FROM content_vectors)
+        AND d.hash NOT IN (SELECT hash FROM doc_vectors_vec)
+        GROUP BY d.hash
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]PendingDoc)
+	for rows.Next() {
+		var hash, title, collection, body string
+		if err := rows.Scan(&hash, &title, &collection, &body); err != nil {
+			return nil, err
+		}
+		res[hash] = PendingDoc{Body: body, Title: title, Collection: collection}
+	}
+	return res, rows.Err()
+}
+
+// GetChunkEmbeddings returns the chunk vectors of a document version, in
+// chunk order.
+func (s *Store) GetChunkEmbeddings(hash string) ([][]float32, error) {
+	rows, err := s.DB.Query(`
+		SELECT v.embedding
+		FROM content_vectors cv
+		JOIN vectors_vec v ON v.chunk_key = cv.chunk_key
+		WHERE cv.hash = ?
+		ORDER BY cv.seq
+	`, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vecs [][]float32
+	for rows.Next() {
+		var blob []byte
+		if err := rows.Scan(&blob); err != nil {
+			return nil, err
+		}
+		vec, err := deserializeFloat32(blob)
+		if err != nil {
+			return nil, err
+		}
+		vecs = append(vecs, vec)
+	}
+	return vecs, rows.Err()
+}
+
+// GetDocEmbedding returns the document level vector of a document version,
+// or nil if none is stored.
+func (s *Store) GetDocEmbedding(hash string) ([]float32, error) {
+	if !s.hasTable("doc_vectors_vec") {
+		return nil, nil
+	}
+	rows, err := s.DB.Query(`SELECT embedding FROM doc_vectors_vec WHERE hash = ?`, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var blob []byte
+	if err := rows.Scan(&blob); err != nil {
+		return nil, err
+	}
+	return deserializeFloat32(blob)
+}
+
+// SearchVecCoarse runs a two stage vector search: a coarse pass over
+// document vectors selects the docLimit closest documents, then a fine pass
+// ranks the chunks of those documents only. A filter is applied to the
+// coarse pass.
+func (s *Store) SearchVecCoarse(queryVec []float32, docLimit, limit int, filter Filter) ([]SearchResult, error) {
+	queryBlob, err := sqlite_vec.SerializeFloat32(queryVec)
+	if err != nil {
+		return nil, err
+	}
+
+	docResults := `
+			SELECT hash
+			FROM doc_vectors_vec
+			WHERE embedding MATCH ?
+			AND k = ?`
+	args := []interface{}{queryBlob, docLimit}
+	if !filter.IsZero() {
+		where, whereArgs := filter.where()
+		docResults = `
+			SELECT hash
+			FROM doc_vectors_vec
+			WHERE hash IN (SELECT d.hash FROM documents d WHERE d.active = 1` + where + `)
+			ORDER BY vec_distance_cosine(embedding, ?)
+			LIMIT ?`
+		args = append(whereArgs, queryBlob, docLimit)
+	}
+
+	query := `
+		WITH doc_results AS (` + docResults + `
+		)
+		SELECT
+			vec_distance_cosine(v.embedding, ?) AS distance,
+			d.id,
+			d.collection || '/' || d.path,
+			d.title,
+			c.doc,
+			d.size,
+			cv.seq,
+			COALESCE(cv.text, '')
+		FROM doc_results dr
+		JOIN content_vectors cv ON cv.hash = dr.hash
+		JOIN vectors_vec v ON v.chunk_key = cv.chunk_key
+		JOIN documents d ON d.hash = cv.hash
+		JOIN content c ON c.hash = d.hash
+		WHERE d.active = 1
+		ORDER BY distance, d.id, cv.seq
+		LIMIT ?
+	`
+
+	rows, err := s.DB.Query(query, append(args, queryBlob, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVecResults(rows)
+}
+
+// MeanVector averages vectors and normalizes the result to unit length.
+func MeanVector(vecs [][]float32) []float32 {
+	if len(vecs) == 0 {
+		return nil
+	}
+	mean := make([]float32, len(vecs[0]))
+	for _, v := range vecs {
+		for i := range mean {
+			if i < len(v) {
+				mean[i] += v[i]
+			}
+		}
+	}
+
+	var sum float64
+	for _, v := range mean {
+		sum += float64(v * v)
+	}
+	sum = math.Sqrt(sum)
+	if sum == 0 {
+		return mean
+	}
+	norm := float32(1.0 / sum)
+	for i := range mean {
+		mean[i] *= norm
+	}
+	return mean
+}
+
+// deserializeFloat32 decodes a vector blob as stored by sqlite-vec
+// (little endian float32).
+func deserializeFloat32(blob []byte) ([]float32, error) {
+	if len(blob)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector blob size %d", len(blob))
+	}
+	vec := make([]float32, len(blob)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[i*4:]))
+	}
+	return vec, nil
+}
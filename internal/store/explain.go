@@ -0,0 +1,70 @@
+package store
+
+// Fusion sources reported by Explanation.Contributions.
+const (
+	SourceBM25      = "bm25"
+	SourceVector    = "vector"
+	SourceAuthority = "graph_authority"
+	SourceExpansion = "graph_expansion"
+)
+
+// StageScore is the position of a document in the ranked list of one search
+// stage.
+type StageScore struct {
+	Rank  int     `json:"rank"` // 1-based
+	Score float64 `json:"score"`
+}
+
+// Explanation breaks down how a hybrid search result was ranked.
+type Explanation struct {
+	// BM25 is the keyword match, with its score negated so that higher is
+	// better. Nil if the document did not match the keywords.
+	BM25 *StageScore `json:"bm25,omitempty"`
+	// Vector is the best matching chunk, scored by cosine similarity. Nil if
+	// no chunk was among the vector candidates.
+	Vector *StageScore `json:"vector,omitempty"`
+	// ChunkSeq is the sequence number of the best matching chunk.
+	ChunkSeq *int `json:"chunk_seq,omitempty"`
+	// Contributions holds the RRF score added by each source, summing to the
+	// final score.
+	Contributions map[string]float64 `json:"contributions"`
+}
+
+// rankedList is a named input of the fusion.
+type rankedList struct {
+	source  string
+	results []SearchResult
+}
+
+// explainFusion attaches an Explanation to each fused result, mirroring the
+// computation of ReciprocalRankFusion over the same lists.
+func explainFusion(fused []SearchResult, lists []rankedList) {
+	explanations := make(map[string]*Explanation, len(fused))
+	for i := range fused {
+		fused[i].Explain = &Explanation{Contributions: make(map[string]float64)}
+		explanations[fused[i].Filepath] = fused[i].Explain
+	}
+
+	for _, list := range lists {
+		for rank, r := range list.results {
+			e, ok := explanations[r.Filepath]
+			if !ok {
+				continue
+			}
+			e.Contributions[list.source] += 1.0 / (rrfK + float64(rank+1))
+
+			switch list.source {
+			case SourceBM25:
+				if e.BM25 == nil {
+					e.BM25 = &StageScore{Rank: rank + 1, Score: -r.Score}
+				}
+			case SourceVector:
+				if e.Vector == nil {
+					seq := r.Seq
+					e.Vector = &StageScore{Rank: rank + 1, Score: r.Score}
+					e.ChunkSeq = &seq
+				}
+			}
+		}
+	}
+}
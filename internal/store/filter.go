@@ -0,0 +1,41 @@
+package store
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/akhenakh/qmd/internal/util"
+)
+
+// Filter restricts search results.
+type Filter struct {
+	Collection string
+	// Tags a document must all carry. A tag also matches its nested tags:
+	// "area" matches documents tagged "area/sub".
+	Tags []string
+}
+
+// IsZero reports whether the filter lets every document through.
+func (f Filter) IsZero() bool {
+	return f.Collection == "" && len(f.Tags) == 0
+}
+
+// where returns the SQL conditions (each starting with AND) applying the
+// filter to the documents table aliased as d, and their arguments.
+func (f Filter) where() (string, []interface{}) {
+	var sb strings.Builder
+	var args []interface{}
+	if f.Collection != "" {
+		sb.WriteString(` AND d.collection = ?`)
+		args = append(args, f.Collection)
+	}
+	for _, tag := range f.Tags {
+		tag = util.NormalizeTag(tag)
+		if tag == "" {
+			continue
+		}
+		sb.WriteString(` AND d.id IN (SELECT doc_id FROM tags WHERE tag = ? OR substr(tag, 1, ?) = ?)`)
+		args = append(args, tag, utf8.RuneCountInString(tag)+1, tag+"/")
+	}
+	return sb.String(), args
+}
@@ -0,0 +1,296 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	pageRankDamping    = 0.85
+	pageRankIterations = 30
+
+	// graphExpandTop is the number of top fused results whose linked
+	// documents are pulled in by the one-hop expansion.
+	graphExpandTop = 5
+)
+
+// ComputeGraphRank computes a PageRank authority score for every document
+// from the resolved link graph and stores it. Scores are scaled so the
+// average document scores 1.
+func (s *Store) ComputeGraphRank() error {
+	rows, err := s.DB.Query(`SELECT id FROM documents WHERE active = 1`)
+	if err != nil {
+		return err
+	}
+	index := make(map[int64]int)
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		index[id] = len(ids)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	n := len(ids)
+	if n == 0 {
+		return nil
+	}
+
+	// Distinct edges, ignoring self links
+	outLinks := make([][]int, n)
+	lRows, err := s.DB.Query(`SELECT DISTINCT source_id, target_id FROM links WHERE target_id IS NOT NULL AND target_id != source_id`)
+	if err != nil {
+		return err
+	}
+	for lRows.Next() {
+		var src, dst int64
+		if err := lRows.Scan(&src, &dst); err != nil {
+			lRows.Close()
+			return err
+		}
+		i, ok1 := index[src]
+		j, ok2 := index[dst]
+		if ok1 && ok2 {
+			outLinks[i] = append(outLinks[i], j)
+		}
+	}
+	lRows.Close()
+
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1.0 / float64(n)
+	}
+	for iter := 0; iter < pageRankIterations; iter++ {
+		next := make([]float64, n)
+		dangling := 0.0
+		for i, targets := range outLinks {
+			if len(targets) == 0 {
+				dangling += rank[i]
+				continue
+			}
+			share := rank[i] / float64(len(targets))
+			for _, j := range targets {
+				next[j] += share
+			}
+		}
+		base := (1-pageRankDamping)/float64(n) + pageRankDamping*dangling/float64(n)
+		for i := range next {
+			next[i] = base + pageRankDamping*next[i]
+		}
+		rank = next
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM doc_rank`); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := tx.Exec(`INSERT INTO doc_rank (doc_id, rank) VALUES (?, ?)`, id, rank[i]*float64(n)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// graphRanks returns the stored authority score of documents.
+func (s *Store) graphRanks(ids []int64) (map[int64]float64, error) {
+	ranks := make(map[int64]float64)
+	if len(ids) == 0 {
+		return ranks, nil
+	}
+	rows, err := s.DB.Query(`SELECT doc_id, rank FROM doc_rank WHERE doc_id IN (`+placeholders(len(ids))+`)`, int64Args(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var rank float64
+		if err := rows.Scan(&id, &rank); err != nil {
+			return nil, err
+		}
+		ranks[id] = rank
+	}
+	return ranks, rows.Err()
+}
+
+// linkedDocuments returns the documents linked from or to each document.
+func (s *Store) linkedDocuments(ids []int64) (map[int64][]int64, error) {
+	neighbors := make(map[int64][]int64)
+	if len(ids) == 0 {
+		return neighbors, nil
+	}
+	in := placeholders(len(ids))
+	args := append(int64Args(ids), int64Args(ids)...)
+	rows, err := s.DB.Query(`
+		SELECT DISTINCT source_id, target_id FROM links
+		WHERE target_id IS NOT NULL AND source_id != target_id
+		AND (source_id IN (`+in+`) OR target_id IN (`+in+`))
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var src, dst int64
+		if err := rows.Scan(&src, &dst); err != nil {
+			return nil, err
+		}
+		neighbors[src] = append(neighbors[src], dst)
+		neighbors[dst] = append(neighbors[dst], src)
+	}
+	return neighbors, rows.Err()
+}
+
+// graphLists builds the two graph ranked lists fused into hybrid search:
+// the candidates ordered by authority, and the documents linked to the top
+// candidates (one-hop expansion), ordered by the rank of the linking result
+// then by authority. Linked documents not matching filter are left out.
+func (s *Store) graphLists(candidates []SearchResult, filter Filter) ([]SearchResult, []SearchResult, error) {
+	var ids []int64
+	seen := make(map[int64]bool)
+	for _, r := range candidates {
+		if !seen[r.DocID] {
+			seen[r.DocID] = true
+			ids = append(ids, r.DocID)
+		}
+	}
+
+	top := ids
+	if len(top) > graphExpandTop {
+		top = top[:graphExpandTop]
+	}
+	neighbors, err := s.linkedDocuments(top)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var expandIDs []int64
+	expandSeen := make(map[int64]bool)
+	for _, id := range top {
+		for _, n := range neighbors[id] {
+			if !expandSeen[n] {
+				expandSeen[n] = true
+				expandIDs = append(expandIDs, n)
+			}
+		}
+	}
+
+	ranks, err := s.graphRanks(append(append([]int64(nil), ids...), expandIDs...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Authority list over the deduplicated candidates
+	var authority []SearchResult
+	added := make(map[int64]bool)
+	for _, r := range candidates {
+		if !added[r.DocID] {
+			added[r.DocID] = true
+			authority = append(authority, r)
+		}
+	}
+	sort.SliceStable(authority, func(i, j int) bool {
+		return ranks[authority[i].DocID] > ranks[authority[j].DocID]
+	})
+
+	// Expansion list, neighbors of the same top result sorted by authority
+	expanded, err := s.documentsByID(expandIDs, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	order := make(map[int64]int)
+	for i, id := range expandIDs {
+		order[id] = i
+	}
+	firstHop := make(map[int64]int)
+	for i, id := range top {
+		for _, n := range neighbors[id] {
+			if _, ok := firstHop[n]; !ok {
+				firstHop[n] = i
+			}
+		}
+	}
+	sort.SliceStable(expanded, func(i, j int) bool {
+		a, b := expanded[i].DocID, expanded[j].DocID
+		if firstHop[a] != firstHop[b] {
+			return firstHop[a] < firstHop[b]
+		}
+		if ranks[a] != ranks[b] {
+			return ranks[a] > ranks[b]
+		}
+		return order[a] < order[b]
+	})
+
+	return authority, expanded, nil
+}
+
+// documentsByID loads the documents matching filter as search results.
+func (s *Store) documentsByID(ids []int64, filter Filter) ([]SearchResult, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	where, args := filter.where()
+	rows, err := s.DB.Query(`
+		SELECT d.id, d.collection || '/' || d.path, d.title, c.doc, d.size
+		FROM documents d
+		JOIN content c ON c.hash = d.hash
+		WHERE d.active = 1 AND d.id IN (`+placeholders(len(ids))+`)`+where+`
+	`, append(int64Args(ids), args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.DocID, &r.Filepath, &r.Title, &r.Body, &r.Size); err != nil {
+			return nil, err
+		}
+		if len(r.Body) > 200 {
+			r.Snippet = r.Body[:200] + "..."
+		} else {
+			r.Snippet = r.Body
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+func placeholders(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func int64Args(ids []int64) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// GraphRank returns the authority score of a document, 0 if unknown.
+func (s *Store) GraphRank(collection, path string) (float64, error) {
+	id, err := s.documentID(collection, path)
+	if err != nil {
+		return 0, err
+	}
+	ranks, err := s.graphRanks([]int64{id})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read graph rank: %w", err)
+	}
+	return ranks[id], nil
+}
@@ -0,0 +1,284 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/util"
+)
+
+// Link is a resolved (or broken) reference between two documents.
+type Link struct {
+	Source    string // collection/path of the linking document
+	Line      int
+	Kind      string // wiki or markdown
+	Target    string // Target as written
+	Heading   string
+	Alias     string
+	Resolved  string // collection/path of the target, empty if broken
+	Ambiguous bool   // Several documents matched, Resolved is the best guess
+}
+
+// saveLinks replaces the outgoing links and aliases of a document.
+// Links are left unresolved until ResolveLinks runs.
+func saveLinks(tx *sql.Tx, docID int64, content string) error {
+	if _, err := tx.Exec(`DELETE FROM links WHERE source_id = ?`, docID); err != nil {
+		return err
+	}
+	for _, l := range util.ExtractLinks(content) {
+		_, err := tx.Exec(`INSERT INTO links (source_id, kind, target, heading, alias, line) VALUES (?, ?, ?, ?, ?, ?)`,
+			docID, l.Kind, l.Target, l.Heading, l.Alias, l.Line)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM doc_aliases WHERE doc_id = ?`, docID); err != nil {
+		return err
+	}
+	fields, _ := util.ParseFrontMatter(content)
+	aliases := append(util.FrontMatterList(fields, "aliases"), util.FrontMatterList(fields, "alias")...)
+	for _, alias := range aliases {
+		if _, err := tx.Exec(`INSERT INTO doc_aliases (doc_id, alias) VALUES (?, ?)`, docID, alias); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type linkDoc struct {
+	id         int64
+	collection string
+	path       string
+}
+
+// linkResolver indexes documents by the names links use to refer to them.
+type linkResolver struct {
+	docs    map[int64]linkDoc
+	byPath  map[string]int64   // collection/path
+	byName  map[string][]int64 // lowercased file name without extension
+	byAlias map[string][]int64 // lowercased front matter alias
+}
+
+// ResolveLinks resolves every link to a document id. Wiki links match file
+// names (case insensitive, preferring the linking collection), then front
+// matter aliases; markdown links are resolved relative to the linking file.
+// It returns the number of broken links.
+func (s *Store) ResolveLinks() (int, error) {
+	r, err := s.newLinkResolver()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := s.DB.Query(`SELECT l.rowid, l.source_id, l.kind, l.target FROM links l`)
+	if err != nil {
+		return 0, err
+	}
+	type update struct {
+		rowid     int64
+		targetID  sql.NullInt64
+		ambiguous bool
+	}
+	var updates []update
+	for rows.Next() {
+		var rowid, sourceID int64
+		var kind, target string
+		if err := rows.Scan(&rowid, &sourceID, &kind, &target); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		u := update{rowid: rowid}
+		if id, ambiguous, ok := r.resolve(r.docs[sourceID], kind, target); ok {
+			u.targetID = sql.NullInt64{Int64: id, Valid: true}
+			u.ambiguous = ambiguous
+		}
+		updates = append(updates, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	broken := 0
+	for _, u := range updates {
+		if !u.targetID.Valid {
+			broken++
+		}
+		if _, err := tx.Exec(`UPDATE links SET target_id = ?, ambiguous = ? WHERE rowid = ?`, u.targetID, u.ambiguous, u.rowid); err != nil {
+			return 0, err
+		}
+	}
+	return broken, tx.Commit()
+}
+
+func (s *Store) newLinkResolver() (*linkResolver, error) {
+	r := &linkResolver{
+		docs:    make(map[int64]linkDoc),
+		byPath:  make(map[string]int64),
+		byName:  make(map[string][]int64),
+		byAlias: make(map[string][]int64),
+	}
+
+	rows, err := s.DB.Query(`SELECT id, collection, path FROM documents WHERE active = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d linkDoc
+		if err := rows.Scan(&d.id, &d.collection, &d.path); err != nil {
+			return nil, err
+		}
+		r.docs[d.id] = d
+		r.byPath[d.collection+"/"+d.path] = d.id
+		name := strings.ToLower(strings.TrimSuffix(path.Base(d.path), ".md"))
+		r.byName[name] = append(r.byName[name], d.id)
+	}
+
+	aRows, err := s.DB.Query(`SELECT doc_id, alias FROM doc_aliases`)
+	if err != nil {
+		return nil, err
+	}
+	defer aRows.Close()
+	for aRows.Next() {
+		var id int64
+		var alias string
+		if err := aRows.Scan(&id, &alias); err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(alias)
+		r.byAlias[key] = append(r.byAlias[key], id)
+	}
+	return r, nil
+}
+
+// resolve returns the target document id of a link, and whether several
+// documents were candidates.
+func (r *linkResolver) resolve(source linkDoc, kind, target string) (int64, bool, bool) {
+	if kind == util.LinkMarkdown {
+		var p string
+		if strings.HasPrefix(target, "/") {
+			p = path.Clean(strings.TrimPrefix(target, "/"))
+		} else {
+			p = path.Join(path.Dir(source.path), target)
+		}
+		for _, candidate := range []string{p, p + ".md"} {
+			if id, ok := r.byPath[source.collection+"/"+candidate]; ok {
+				return id, false, true
+			}
+		}
+		return 0, false, false
+	}
+
+	// Wiki link: an explicit path from the collection root wins
+	target = strings.TrimSuffix(strings.TrimSpace(target), ".md")
+	if id, ok := r.byPath[source.collection+"/"+target+".md"]; ok {
+		return id, false, true
+	}
+
+	candidates := r.byName[strings.ToLower(path.Base(target))]
+	if strings.Contains(target, "/") {
+		// [[folder/Note]] must match the end of the path
+		suffix := strings.ToLower(target) + ".md"
+		var filtered []int64
+		for _, id := range candidates {
+			if strings.HasSuffix(strings.ToLower(r.docs[id].path), suffix) {
+				filtered = append(filtered, id)
+			}
+		}
+		candidates = filtered
+	}
+	if len(candidates) == 0 {
+		candidates = r.byAlias[strings.ToLower(target)]
+	}
+	if len(candidates) == 0 {
+		return 0, false, false
+	}
+
+	// Prefer the linking collection, then the shortest path
+	sorted := append([]int64(nil), candidates...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := r.docs[sorted[i]], r.docs[sorted[j]]
+		if (a.collection == source.collection) != (b.collection == source.collection) {
+			return a.collection == source.collection
+		}
+		if len(a.path) != len(b.path) {
+			return len(a.path) < len(b.path)
+		}
+		return a.path < b.path
+	})
+	return sorted[0], len(sorted) > 1, true
+}
+
+// GetLinks returns the outgoing links of a document.
+func (s *Store) GetLinks(collection, path string) ([]Link, error) {
+	id, err := s.documentID(collection, path)
+	if err != nil {
+		return nil, err
+	}
+	return s.queryLinks(`WHERE l.source_id = ? ORDER BY l.line`, id)
+}
+
+// GetBacklinks returns the links pointing to a document.
+func (s *Store) GetBacklinks(collection, path string) ([]Link, error) {
+	id, err := s.documentID(collection, path)
+	if err != nil {
+		return nil, err
+	}
+	return s.queryLinks(`WHERE l.target_id = ? ORDER BY src.collection, src.path, l.line`, id)
+}
+
+// GetBrokenLinks returns the links that could not be resolved, optionally
+// restricted to a collection.
+func (s *Store) GetBrokenLinks(collection string) ([]Link, error) {
+	if collection != "" {
+		return s.queryLinks(`WHERE l.target_id IS NULL AND src.collection = ? ORDER BY src.path, l.line`, collection)
+	}
+	return s.queryLinks(`WHERE l.target_id IS NULL ORDER BY src.collection, src.path, l.line`)
+}
+
+func (s *Store) queryLinks(where string, args ...interface{}) ([]Link, error) {
+	rows, err := s.DB.Query(`
+		SELECT
+			src.collection || '/' || src.path,
+			l.line, l.kind, l.target, l.heading, l.alias,
+			COALESCE(dst.collection || '/' || dst.path, ''),
+			l.ambiguous
+		FROM links l
+		JOIN documents src ON src.id = l.source_id
+		LEFT JOIN documents dst ON dst.id = l.target_id
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []Link
+	for rows.Next() {
+		var l Link
+		if err := rows.Scan(&l.Source, &l.Line, &l.Kind, &l.Target, &l.Heading, &l.Alias, &l.Resolved, &l.Ambiguous); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// documentID returns the id of an indexed document.
+func (s *Store) documentID(collection, path string) (int64, error) {
+	var id int64
+	err := s.DB.QueryRow(`SELECT id FROM documents WHERE collection = ? AND path = ?`, collection, path).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("document not found: %s/%s", collection, path)
+	}
+	return id, err
+}
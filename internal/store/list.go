@@ -0,0 +1,59 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// DocumentInfo describes an indexed document, without its content.
+type DocumentInfo struct {
+	Collection string    `json:"collection"`
+	Path       string    `json:"path"`
+	Title      string    `json:"title"`
+	Size       int       `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"` // Last time the document was (re)indexed
+	Embedded   bool      `json:"embedded"`    // Whether the chunk vectors of this version are stored
+}
+
+// ListDocuments returns the active documents of a collection (all
+// collections if empty) whose path starts with prefix, sorted by path.
+func (s *Store) ListDocuments(collection, prefix string) ([]DocumentInfo, error) {
+	query := `
+		SELECT d.collection, d.path, d.title, d.size, d.modified_at,
+			EXISTS (SELECT 1 FROM content_vectors cv WHERE cv.hash = d.hash)
+		FROM documents d
+		WHERE d.active = 1`
+	var args []interface{}
+	if collection != "" {
+		query += ` AND d.collection = ?`
+		args = append(args, collection)
+	}
+	if prefix != "" {
+		query += ` AND substr(d.path, 1, ?) = ?`
+		args = append(args, len([]rune(prefix)), prefix)
+	}
+	query += ` ORDER BY d.collection, d.path`
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []DocumentInfo
+	for rows.Next() {
+		var d DocumentInfo
+		var modified string
+		if err := rows.Scan(&d.Collection, &d.Path, &d.Title, &d.Size, &modified, &d.Embedded); err != nil {
+			return nil, err
+		}
+		d.ModifiedAt, _ = time.Parse(time.RFC3339, modified)
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
+
+// Filepath returns the "collection/path" identifier of the document.
+func (d DocumentInfo) Filepath() string {
+	return d.Collection + "/" + strings.TrimPrefix(d.Path, "/")
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/util"
+)
+
+// Section is a heading of a document with the line range it covers.
+type Section struct {
+	Level   int
+	Heading string
+	Path    []string // Heading breadcrumb, from the top level heading to this one
+	Line    int      // 1-based line of the heading
+	EndLine int      // Last line of the section, nested sections included
+}
+
+// saveHeadings replaces the heading outline of a document.
+func saveHeadings(tx *sql.Tx, docID int64, content string) error {
+	if _, err := tx.Exec(`DELETE FROM headings WHERE doc_id = ?`, docID); err != nil {
+		return err
+	}
+	for i, h := range util.ParseHeadings(content) {
+		_, err := tx.Exec(`INSERT INTO headings (doc_id, seq, level, text, line, end_line) VALUES (?, ?, ?, ?, ?, ?)`,
+			docID, i, h.Level, h.Text, h.Line, h.EndLine)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOutline returns the heading tree of a document in document order.
+func (s *Store) GetOutline(collection, path string) ([]Section, error) {
+	docID, err := s.documentID(collection, path)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Query(`SELECT level, text, line, end_line FROM headings WHERE doc_id = ? ORDER BY seq`, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sections []Section
+	var stack []Section
+	for rows.Next() {
+		var sec Section
+		if err := rows.Scan(&sec.Level, &sec.Heading, &sec.Line, &sec.EndLine); err != nil {
+			return nil, err
+		}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= sec.Level {
+			stack = stack[:len(stack)-1]
+		}
+		for _, parent := range stack {
+			sec.Path = append(sec.Path, parent.Heading)
+		}
+		sec.Path = append(sec.Path, sec.Heading)
+		stack = append(stack, sec)
+		sections = append(sections, sec)
+	}
+	return sections, rows.Err()
+}
+
+// FindSection returns the section of a document designated by a heading
+// path such as "Deploy/Rollback". The last element must be the heading
+// itself, the previous ones its ancestors in order, not necessarily direct
+// parents. Matching is case insensitive; the first match wins.
+func (s *Store) FindSection(collection, path, headingPath string) (*Section, error) {
+	sections, err := s.GetOutline(collection, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A heading containing '/' can be given as is
+	for i := range sections {
+		if strings.EqualFold(sections[i].Heading, strings.TrimSpace(headingPath)) {
+			return &sections[i], nil
+		}
+	}
+
+	var parts []string
+	for _, p := range strings.Split(headingPath, "/") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty section path")
+	}
+
+	for i := range sections {
+		if matchesHeadingPath(sections[i].Path, parts) {
+			return &sections[i], nil
+		}
+	}
+	return nil, fmt.Errorf("section %q not found in %s/%s", headingPath, collection, path)
+}
+
+func matchesHeadingPath(crumbs, parts []string) bool {
+	last := len(crumbs) - 1
+	if !strings.EqualFold(crumbs[last], parts[len(parts)-1]) {
+		return false
+	}
+	j := 0
+	for _, c := range crumbs[:last] {
+		if j < len(parts)-1 && strings.EqualFold(c, parts[j]) {
+			j++
+		}
+	}
+	return j == len(parts)-1
+}
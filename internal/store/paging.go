@@ -0,0 +1,82 @@
+package store
+
+// minHybridCandidates is the minimum number of candidates fetched from each
+// engine by hybrid search. Fusion scores depend on the candidate pool, so a
+// pool that does not grow with the first pages keeps their order stable.
+const minHybridCandidates = 50
+
+// PageResults returns at most limit results starting at offset.
+func PageResults(results []SearchResult, offset, limit int) []SearchResult {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(results) {
+		return nil
+	}
+	results = results[offset:]
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// CountFTS returns the number of documents matching a full-text query.
+func (s *Store) CountFTS(query string, filter Filter) (int, error) {
+	where, args := filter.where()
+	var n int
+	err := s.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM documents_fts fts
+		JOIN documents d ON d.id = fts.rowid
+		WHERE documents_fts MATCH ?`+where,
+		append([]interface{}{ftsPhrase(query)}, args...)...).Scan(&n)
+	return n, err
+}
+
+// CountVec returns the number of embedded documents passing the filter,
+// which is the number of results vector search can rank.
+func (s *Store) CountVec(filter Filter) (int, error) {
+	where, args := filter.where()
+	var n int
+	err := s.DB.QueryRow(`
+		SELECT COUNT(DISTINCT d.id)
+		FROM documents d
+		JOIN content_vectors cv ON cv.hash = d.hash
+		WHERE d.active = 1`+where, args...).Scan(&n)
+	return n, err
+}
+
+// CountHybrid estimates the number of documents hybrid search can return:
+// the documents matching the query, or all embedded documents if more.
+func (s *Store) CountHybrid(query string, filter Filter) (int, error) {
+	n, err := s.CountFTS(query, filter)
+	if err != nil {
+		return 0, err
+	}
+	embedded, err := s.countEmbedded(filter)
+	if err != nil {
+		return 0, err
+	}
+	return max(n, embedded), nil
+}
+
+// CountSimilar estimates the number of documents SimilarDocuments can
+// return: the embedded documents passing the filter, but the document
+// itself.
+func (s *Store) CountSimilar(filter Filter) (int, error) {
+	n, err := s.countEmbedded(filter)
+	return max(n-1, 0), err
+}
+
+// countEmbedded returns the number of embedded documents passing the
+// filter.
+func (s *Store) countEmbedded(filter Filter) (int, error) {
+	where, args := filter.where()
+	var n int
+	err := s.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM documents d
+		WHERE d.active = 1
+		AND EXISTS (SELECT 1 FROM content_vectors cv WHERE cv.hash = d.hash)`+where, args...).Scan(&n)
+	return n, err
+}
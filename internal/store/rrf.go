@@ -47,9 +47,12 @@ func ReciprocalRankFusion(resultLists ...[]SearchResult) []SearchResult {
 		fused = append(fused, ds.result)
 	}
 
-	// Sort descending by score
+	// Sort descending by score, ties by path so that pages are stable
 	sort.Slice(fused, func(i, j int) bool {
-		return fused[i].Score > fused[j].Score
+		if fused[i].Score != fused[j].Score {
+			return fused[i].Score > fused[j].Score
+		}
+		return fused[i].Filepath < fused[j].Filepath
 	})
 
 	return fused
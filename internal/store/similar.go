@@ -0,0 +1,215 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+)
+
+// similarCandidates is how many nearest neighbours are fetched per requested
+// result, to leave room for the document itself, several chunks of the same
+// document and filtered out results.
+const similarCandidates = 5
+
+// SimilarDocuments returns the documents closest to collection/path, using
+// its stored vectors (document vector, or mean of its chunk vectors) so
+// nothing is re-embedded. With keywords set, a BM25 search on the document's
+// most frequent terms is fused in with RRF.
+func (s *Store) SimilarDocuments(collection, path string, limit int, keywords bool, filter Filter) ([]SearchResult, error) {
+	var docID int64
+	var hash, body string
+	err := s.DB.QueryRow(`
+		SELECT d.id, d.hash, c.doc
+		FROM documents d
+		JOIN content c ON c.hash = d.hash
+		WHERE d.collection = ? AND d.path = ?
+	`, collection, path).Scan(&docID, &hash, &body)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("document not found: %s/%s", collection, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	vec, err := s.GetDocEmbedding(hash)
+	if err != nil {
+		return nil, err
+	}
+	useDocVectors := vec != nil
+	if vec == nil {
+		vecs, err := s.GetChunkEmbeddings(hash)
+		if err != nil {
+			return nil, err
+		}
+		vec = MeanVector(vecs)
+	}
+	if vec == nil {
+		return nil, fmt.Errorf("document %s/%s has no embeddings, run 'qmd embed' first", collection, path)
+	}
+
+	var vecResults []SearchResult
+	if useDocVectors {
+		vecResults, err = s.nearestDocuments(vec, (limit+1)*similarCandidates, filter)
+	} else {
+		vecResults, err = s.SearchVec(vec, (limit+1)*similarCandidates, filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Copies of the document indexed elsewhere share its hash and are as
+	// similar as the document itself
+	exclude, err := s.documentIDs(hash)
+	if err != nil {
+		return nil, err
+	}
+	exclude[docID] = true
+	results := dedupeDocuments(vecResults, exclude)
+
+	if keywords {
+		terms := topTerms(body, 10)
+		if len(terms) > 0 {
+			ftsResults, err := s.searchFTSMatch(strings.Join(terms, " OR "), "", (limit+1)*2, 0, false, filter)
+			if err != nil {
+				return nil, fmt.Errorf("FTS search failed: %w", err)
+			}
+			results = ReciprocalRankFusion(results, dedupeDocuments(ftsResults, exclude))
+		}
+	}
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// nearestDocuments returns the k documents closest to vec. Without filter
+// it runs a KNN query over document vectors; with a filter the distance is
+// computed for the matching documents only, like SearchVec.
+func (s *Store) nearestDocuments(vec []float32, k int, filter Filter) ([]SearchResult, error) {
+	blob, err := sqlite_vec.SerializeFloat32(vec)
+	if err != nil {
+		return nil, err
+	}
+	where, whereArgs := filter.where()
+
+	docResults := `
+			SELECT hash, distance
+			FROM doc_vectors_vec
+			WHERE embedding MATCH ?
+			AND k = ?`
+	args := []interface{}{blob, k}
+	if !filter.IsZero() {
+		docResults = `
+			SELECT hash, vec_distance_cosine(embedding, ?) AS distance
+			FROM doc_vectors_vec
+			WHERE hash IN (SELECT d.hash FROM documents d WHERE d.active = 1` + where + `)
+			ORDER BY distance
+			LIMIT ?`
+		args = append(append([]interface{}{blob}, whereArgs...), k)
+	}
+
+	rows, err := s.DB.Query(`
+		WITH doc_results AS (`+docResults+`
+		)
+		SELECT
+			dr.distance,
+			d.id,
+			d.collection || '/' || d.path,
+			d.title,
+			c.doc,
+			d.size,
+			0,
+			''
+		FROM doc_results dr
+		JOIN documents d ON d.hash = dr.hash
+		JOIN content c ON c.hash = d.hash
+		WHERE d.active = 1`+where+`
+		ORDER BY dr.distance
+	`, append(args, whereArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVecResults(rows)
+}
+
+// documentIDs returns the ids of the documents with the given content hash.
+func (s *Store) documentIDs(hash string) (map[int64]bool, error) {
+	rows, err := s.DB.Query(`SELECT id FROM documents WHERE hash = ?`, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// dedupeDocuments keeps the best ranked result of each document, dropping
+// the excluded documents.
+func dedupeDocuments(results []SearchResult, exclude map[int64]bool) []SearchResult {
+	seen := make(map[int64]bool)
+	var out []SearchResult
+	for _, r := range results {
+		if exclude[r.DocID] || seen[r.DocID] {
+			continue
+		}
+		seen[r.DocID] = true
+		out = append(out, r)
+	}
+	return out
+}
+
+var termRegex = regexp.MustCompile(`[\p{L}\p{N}_]{4,}`)
+
+// stopWords are frequent English words not worth matching on.
+var stopWords = map[string]bool{
+	"this": true, "that": true, "with": true, "from": true, "have": true,
+	"will": true, "your": true, "they": true, "them": true, "their": true,
+	"there": true, "what": true, "when": true, "which": true, "were": true,
+	"been": true, "than": true, "then": true, "also": true, "into": true,
+	"more": true, "some": true, "only": true, "about": true, "would": true,
+	"could": true, "should": true, "these": true, "those": true, "here": true,
+	"just": true, "like": true, "does": true, "each": true, "other": true,
+}
+
+// topTerms returns the n most frequent significant terms of a text, quoted
+// for use in an FTS5 query.
+func topTerms(text string, n int) []string {
+	counts := make(map[string]int)
+	for _, t := range termRegex.FindAllString(strings.ToLower(text), -1) {
+		if !stopWords[t] {
+			counts[t]++
+		}
+	}
+
+	terms := make([]string, 0, len(counts))
+	for t := range counts {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if counts[terms[i]] != counts[terms[j]] {
+			return counts[terms[i]] > counts[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+	if len(terms) > n {
+		terms = terms[:n]
+	}
+	for i, t := range terms {
+		terms[i] = fmt.Sprintf(`"%s"`, t)
+	}
+	return terms
+}
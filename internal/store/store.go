@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/akhenakh/qmd/internal/config"
 	"github.com/akhenakh/qmd/internal/util"
@@ -64,7 +66,8 @@ func (s *Store) initBasicSchema() error {
 			name TEXT,
 			pattern TEXT,
 			exclude TEXT, 
-			context TEXT
+			context TEXT,
+			chunker TEXT
 		)`,
 		// Content tables
 		`CREATE TABLE IF NOT EXISTS content (
@@ -108,11 +111,83 @@ func (s *Store) initBasicSchema() error {
 			(SELECT doc FROM content WHERE hash = new.hash);
 		 END`,
 		// Vector mapping table (vector table created separately based on dim)
+		// Maps each chunk of a document version to the key of its vector, so
+		// identical chunks share a single embedding. text keeps the chunk as
+		// displayed, which may differ from what was sent to the embedder.
 		`CREATE TABLE IF NOT EXISTS content_vectors (
 			hash TEXT NOT NULL,
 			seq INTEGER NOT NULL DEFAULT 0,
+			chunk_key TEXT,
+			text TEXT,
 			PRIMARY KEY (hash, seq)
 		)`,
+		// Link graph, target_id is NULL for broken links
+		`CREATE TABLE IF NOT EXISTS links (
+			source_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL,
+			heading TEXT NOT NULL DEFAULT '',
+			alias TEXT NOT NULL DEFAULT '',
+			line INTEGER NOT NULL,
+			target_id INTEGER,
+			ambiguous INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY (source_id) REFERENCES documents(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_links_source ON links(source_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_links_target ON links(target_id)`,
+		// Link graph authority (PageRank), computed after link resolution
+		`CREATE TABLE IF NOT EXISTS doc_rank (
+			doc_id INTEGER PRIMARY KEY,
+			rank REAL NOT NULL,
+			FOREIGN KEY (doc_id) REFERENCES documents(id) ON DELETE CASCADE
+		)`,
+		// Heading outline, one row per heading with its section line range
+		`CREATE TABLE IF NOT EXISTS headings (
+			doc_id INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			level INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			line INTEGER NOT NULL,
+			end_line INTEGER NOT NULL,
+			PRIMARY KEY (doc_id, seq),
+			FOREIGN KEY (doc_id) REFERENCES documents(id) ON DELETE CASCADE
+		)`,
+		// Normalized tags, nested tags are stored as "area/sub"
+		`CREATE TABLE IF NOT EXISTS tags (
+			doc_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (doc_id, tag),
+			FOREIGN KEY (doc_id) REFERENCES documents(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_tags_tag ON tags(tag)`,
+		// Front matter aliases, used to resolve wiki links
+		`CREATE TABLE IF NOT EXISTS doc_aliases (
+			doc_id INTEGER NOT NULL,
+			alias TEXT NOT NULL,
+			FOREIGN KEY (doc_id) REFERENCES documents(id) ON DELETE CASCADE
+		)`,
+		// Chat conversations, tool_calls holds the calls of assistant
+		// messages as JSON
+		`CREATE TABLE IF NOT EXISTS chat_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL DEFAULT '',
+			provider TEXT NOT NULL DEFAULT '',
+			model TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS chat_messages (
+			session_id INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			role TEXT NOT NULL,
+			content TEXT NOT NULL DEFAULT '',
+			tool_calls TEXT,
+			tool_call_id TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (session_id, seq),
+			FOREIGN KEY (session_id) REFERENCES chat_sessions(id) ON DELETE CASCADE
+		)`,
 	}
 
 	for _, q := range queries {
@@ -128,16 +203,57 @@ func (s *Store) initBasicSchema() error {
 			}
 		}
 	}
+
+	// Column additions for databases created by older versions.
+	// Errors are ignored as the column usually exists already.
+	migrations := []string{
+		"ALTER TABLE content_vectors ADD COLUMN chunk_key TEXT",
+		"ALTER TABLE content_vectors ADD COLUMN text TEXT",
+		"ALTER TABLE collections ADD COLUMN chunker TEXT",
+	}
+	for _, q := range migrations {
+		s.DB.Exec(q)
+	}
+
+	// Indexes depending on migrated columns
+	if _, err := s.DB.Exec("CREATE INDEX IF NOT EXISTS idx_content_vectors_chunk ON content_vectors(chunk_key)"); err != nil {
+		return err
+	}
 	return nil
 }
 
 func (s *Store) EnsureVectorTable(dim int) error {
+	// Older versions keyed vectors by document hash and chunk sequence.
+	// Those vectors cannot be shared between documents, so drop them and
+	// let the next embed run regenerate them per chunk text.
+	var schema string
+	err := s.DB.QueryRow("SELECT sql FROM sqlite_master WHERE name='vectors_vec'").Scan(&schema)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if strings.Contains(schema, "hash_seq") {
+		if _, err := s.DB.Exec("DROP TABLE vectors_vec"); err != nil {
+			return err
+		}
+		if _, err := s.DB.Exec("DELETE FROM content_vectors"); err != nil {
+			return err
+		}
+	}
+
 	query := fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS vectors_vec USING vec0(
-		hash_seq TEXT PRIMARY KEY,
+		chunk_key TEXT PRIMARY KEY,
 		embedding float[%d] distance_metric=cosine
 	)`, dim)
+	if _, err := s.DB.Exec(query); err != nil {
+		return err
+	}
 
-	_, err := s.DB.Exec(query)
+	// Document level vectors, keyed by content hash
+	query = fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS doc_vectors_vec USING vec0(
+		hash TEXT PRIMARY KEY,
+		embedding float[%d] distance_metric=cosine
+	)`, dim)
+	_, err = s.DB.Exec(query)
 	return err
 }
 
@@ -189,15 +305,49 @@ func (s *Store) LoadConfig() (*config.Config, error) {
 			cfg.ChunkOverlap = i
 		}
 	}
+	if v, ok := kv["chunker"]; ok && v != "" {
+		cfg.Chunker = v
+	}
+	if v, ok := kv["doc_vectors"]; ok {
+		cfg.DocVectors = v
+	}
+	if v, ok := kv["contextual_chunks"]; ok {
+		cfg.ContextualChunks = (v == "true")
+	}
 	if v, ok := kv["embeddings_configured"]; ok {
 		cfg.EmbeddingsConfigured = (v == "true")
 	}
+	if v, ok := kv["chat_provider"]; ok && v != "" {
+		cfg.ChatProvider = v
+	}
+	if v, ok := kv["chat_url"]; ok {
+		cfg.ChatURL = v
+	}
+	if v, ok := kv["chat_model"]; ok && v != "" {
+		cfg.ChatModel = v
+	}
+	if v, ok := kv["chat_context_size"]; ok {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.ChatContextSize = i
+		}
+	}
+	if v, ok := kv["chat_model_path"]; ok {
+		cfg.ChatModelPath = v
+	}
+	if v, ok := kv["chat_temperature"]; ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.ChatTemperature = &f
+		}
+	}
+	if v, ok := kv["chat_mcp_servers"]; ok && v != "" {
+		json.Unmarshal([]byte(v), &cfg.ChatMCPServers)
+	}
 
 	// Load Collections
-	cRows, err := s.DB.Query("SELECT path, name, pattern, exclude, context FROM collections")
+	cRows, err := s.DB.Query("SELECT path, name, pattern, COALESCE(exclude, ''), COALESCE(context, ''), COALESCE(chunker, '') FROM collections")
 	if err != nil {
 		// Fallback for older schema if migration didn't run via init
-		cRows, err = s.DB.Query("SELECT path, name, pattern, '', context FROM collections")
+		cRows, err = s.DB.Query("SELECT path, name, pattern, '', context, '' FROM collections")
 		if err != nil {
 			return cfg, nil
 		}
@@ -205,14 +355,15 @@ func (s *Store) LoadConfig() (*config.Config, error) {
 	defer cRows.Close()
 
 	for cRows.Next() {
-		var path, name, pattern, excludeJSON, contextJSON string
-		if err := cRows.Scan(&path, &name, &pattern, &excludeJSON, &contextJSON); err == nil {
+		var path, name, pattern, excludeJSON, contextJSON, chunker string
+		if err := cRows.Scan(&path, &name, &pattern, &excludeJSON, &contextJSON, &chunker); err == nil {
 			c := config.Collection{
 				Path:    path,
 				Name:    name,
 				Pattern: pattern,
 				Context: make(map[string]string),
 				Exclude: make([]string, 0),
+				Chunker: chunker,
 			}
 			if contextJSON != "" {
 				json.Unmarshal([]byte(contextJSON), &c.Context)
@@ -264,12 +415,55 @@ func (s *Store) SaveConfig(cfg *config.Config) error {
 		if err := upsert("chunk_overlap", strconv.Itoa(cfg.ChunkOverlap)); err != nil {
 			return err
 		}
+		if err := upsert("chunker", cfg.Chunker); err != nil {
+			return err
+		}
+		if err := upsert("doc_vectors", cfg.DocVectors); err != nil {
+			return err
+		}
+		if err := upsert("contextual_chunks", fmt.Sprintf("%v", cfg.ContextualChunks)); err != nil {
+			return err
+		}
 	}
 
 	if err := upsert("embeddings_configured", fmt.Sprintf("%v", cfg.EmbeddingsConfigured)); err != nil {
 		return err
 	}
 
+	if err := upsert("chat_provider", cfg.ChatProvider); err != nil {
+		return err
+	}
+	if err := upsert("chat_url", cfg.ChatURL); err != nil {
+		return err
+	}
+	if err := upsert("chat_model", cfg.ChatModel); err != nil {
+		return err
+	}
+	if err := upsert("chat_context_size", strconv.Itoa(cfg.ChatContextSize)); err != nil {
+		return err
+	}
+	if err := upsert("chat_model_path", cfg.ChatModelPath); err != nil {
+		return err
+	}
+	temperature := ""
+	if cfg.ChatTemperature != nil {
+		temperature = strconv.FormatFloat(*cfg.ChatTemperature, 'f', -1, 64)
+	}
+	if err := upsert("chat_temperature", temperature); err != nil {
+		return err
+	}
+	servers := ""
+	if len(cfg.ChatMCPServers) > 0 {
+		b, err := json.Marshal(cfg.ChatMCPServers)
+		if err != nil {
+			return err
+		}
+		servers = string(b)
+	}
+	if err := upsert("chat_mcp_servers", servers); err != nil {
+		return err
+	}
+
 	if _, err := tx.Exec("DELETE FROM collections"); err != nil {
 		return err
 	}
@@ -277,8 +471,8 @@ func (s *Store) SaveConfig(cfg *config.Config) error {
 	for _, c := range cfg.Collections {
 		ctxBytes, _ := json.Marshal(c.Context)
 		excBytes, _ := json.Marshal(c.Exclude)
-		_, err := tx.Exec("INSERT INTO collections (path, name, pattern, exclude, context) VALUES (?, ?, ?, ?, ?)",
-			c.Path, c.Name, c.Pattern, string(excBytes), string(ctxBytes))
+		_, err := tx.Exec("INSERT INTO collections (path, name, pattern, exclude, context, chunker) VALUES (?, ?, ?, ?, ?, ?)",
+			c.Path, c.Name, c.Pattern, string(excBytes), string(ctxBytes), c.Chunker)
 		if err != nil {
 			return err
 		}
@@ -318,9 +512,39 @@ func (s *Store) IndexDocument(colName, path, content string) error {
 		return err
 	}
 
+	var docID int64
+	err = tx.QueryRow(`SELECT id FROM documents WHERE collection = ? AND path = ?`, colName, path).Scan(&docID)
+	if err != nil {
+		return err
+	}
+	if err := saveLinks(tx, docID, content); err != nil {
+		return err
+	}
+	if err := saveTags(tx, docID, content); err != nil {
+		return err
+	}
+	if err := saveHeadings(tx, docID, content); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
+// SearchResultJSON is the JSON shape of a search result, shared by the MCP
+// tools and the CLI --format output.
+type SearchResultJSON struct {
+	Filepath         string   `json:"filepath"`
+	Title            string   `json:"title"`
+	Score            float64  `json:"score"`
+	Size             int      `json:"size"`
+	Line             int      `json:"line,omitempty"` // 1-based line of the match
+	Snippet          string   `json:"snippet,omitempty"`
+	Matches          []string `json:"matches,omitempty"`
+	FullFileReturned bool     `json:"full_file_returned,omitempty"`
+
+	Explain *Explanation `json:"explain,omitempty"`
+}
+
 type SearchResult struct {
 	DocID    int64
 	Filepath string
@@ -331,12 +555,33 @@ type SearchResult struct {
 	Body     string // Full content
 	Size     int    // File size in bytes
 	Seq      int    // Sequence number of the matching chunk
+	Chunk    string // Text of the matching chunk, empty if not stored
+
+	Explain *Explanation // Ranking breakdown, set by hybrid search on request
+}
+
+func (s *Store) SearchFTS(query string, limit int, contextLines int, findAll bool, filter Filter) ([]SearchResult, error) {
+	query = strings.TrimSpace(query)
+	return s.searchFTSMatch(ftsPhrase(query), query, limit, contextLines, findAll, filter)
 }
 
-func (s *Store) SearchFTS(query string, limit int, contextLines int, findAll bool) ([]SearchResult, error) {
+// SearchFTSPrefix is SearchFTS with the last word of the query matched as a
+// prefix, for search as you type.
+func (s *Store) SearchFTSPrefix(query string, limit int, contextLines int, filter Filter) ([]SearchResult, error) {
 	query = strings.TrimSpace(query)
-	cleanQuery := strings.ReplaceAll(query, "\"", "")
-	ftsQuery := fmt.Sprintf(`"%s"`, cleanQuery)
+	return s.searchFTSMatch(ftsPhrase(query)+"*", query, limit, contextLines, false, filter)
+}
+
+// ftsPhrase turns a user query into an FTS5 phrase query.
+func ftsPhrase(query string) string {
+	cleanQuery := strings.ReplaceAll(strings.TrimSpace(query), "\"", "")
+	return fmt.Sprintf(`"%s"`, cleanQuery)
+}
+
+// searchFTSMatch runs a raw FTS5 MATCH expression. query is the text used to
+// locate matches in the body for context extraction.
+func (s *Store) searchFTSMatch(ftsQuery, query string, limit int, contextLines int, findAll bool, filter Filter) ([]SearchResult, error) {
+	where, args := filter.where()
 
 	// Join with documents table to retrieve the 'size' field
 	rows, err := s.DB.Query(`
@@ -349,9 +594,9 @@ func (s *Store) SearchFTS(query string, limit int, contextLines int, findAll boo
 			d.size
 		FROM documents_fts fts
 		JOIN documents d ON d.id = fts.rowid
-		WHERE documents_fts MATCH ? 
-		ORDER BY rank 
-		LIMIT ?`, ftsQuery, limit)
+		WHERE documents_fts MATCH ?`+where+`
+		ORDER BY rank, d.id
+		LIMIT ?`, append(append([]interface{}{ftsQuery}, args...), limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -393,29 +638,31 @@ func max(a, b int) int {
 }
 
 func extractOffsetsFromBody(body string, query string) string {
-	cleanQuery := strings.TrimSpace(strings.ToLower(query))
+	cleanQuery := strings.TrimSpace(query)
 	if cleanQuery == "" {
 		return ""
 	}
 
-	bodyLower := strings.ToLower(body)
-	queryLower := strings.ToLower(cleanQuery)
+	// Case folding can change the byte length of some runes, so offsets are
+	// taken on body itself rather than on a lowercased copy
+	re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(cleanQuery))
 
 	var offsetsParts []string
 	idx := 0
 
-	for {
-		pos := strings.Index(bodyLower[idx:], queryLower)
-		if pos == -1 {
+	for idx <= len(body) {
+		loc := re.FindStringIndex(body[idx:])
+		if loc == nil {
 			break
 		}
 
-		actualPos := idx + pos
-		// colNum=2 (body), termNum=0, byteOffset=actualPos, size=len(query)
+		actualPos := idx + loc[0]
+		// colNum=2 (body), termNum=0, byteOffset=actualPos, size=match length
 		offsetsParts = append(offsetsParts,
-			fmt.Sprintf("2 0 %d %d", actualPos, len(query)))
+			fmt.Sprintf("2 0 %d %d", actualPos, loc[1]-loc[0]))
 
-		idx = actualPos + 1
+		_, size := utf8.DecodeRuneInString(body[actualPos:])
+		idx = actualPos + max(size, 1)
 	}
 
 	return strings.Join(offsetsParts, " ")
@@ -487,52 +734,149 @@ func extractMatches(body string, offsetsStr string, n int, findAll bool) []strin
 	return results
 }
 
-func (s *Store) SaveEmbedding(hash string, seq int, vec []float32) error {
-	blob, err := sqlite_vec.SerializeFloat32(vec)
+// HasEmbedding reports whether a vector is already stored for the chunk key.
+func (s *Store) HasEmbedding(key string) (bool, error) {
+	var n int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM vectors_vec WHERE chunk_key = ?`, key).Scan(&n)
 	if err != nil {
-		return err
+		return false, err
 	}
-	key := fmt.Sprintf("%s_%d", hash, seq)
+	return n > 0, nil
+}
+
+// SaveEmbedding maps chunk seq of the document version hash to the vector
+// stored under key, along with the chunk text to display for it.
+// If vec is nil the existing vector for key is reused.
+func (s *Store) SaveEmbedding(hash string, seq int, key string, text string, vec []float32) error {
 	tx, err := s.DB.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	_, err = tx.Exec(`INSERT OR IGNORE INTO content_vectors (hash, seq) VALUES (?, ?)`, hash, seq)
+	_, err = tx.Exec(`INSERT OR REPLACE INTO content_vectors (hash, seq, chunk_key, text) VALUES (?, ?, ?, ?)`, hash, seq, key, text)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(`INSERT OR REPLACE INTO vectors_vec (hash_seq, embedding) VALUES (?, ?)`, key, blob)
-	if err != nil {
-		return err
+	if vec != nil {
+		blob, err := sqlite_vec.SerializeFloat32(vec)
+		if err != nil {
+			return err
+		}
+		// vec0 tables do not support INSERT OR REPLACE on the primary key
+		if _, err := tx.Exec(`DELETE FROM vectors_vec WHERE chunk_key = ?`, key); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO vectors_vec (chunk_key, embedding) VALUES (?, ?)`, key, blob); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
 
-func (s *Store) SearchVec(queryVec []float32, limit int) ([]SearchResult, error) {
+// ResetEmbeddings forgets which vectors belong to which documents, forcing
+// every document to be re-embedded (e.g. after switching models).
+func (s *Store) ResetEmbeddings() error {
+	if _, err := s.DB.Exec(`DELETE FROM content_vectors`); err != nil {
+		return err
+	}
+	return s.ResetDocEmbeddings()
+}
+
+// ResetCollectionEmbeddings forces the documents of a collection to be
+// re-embedded (e.g. after changing its chunking strategy).
+func (s *Store) ResetCollectionEmbeddings(collection string) error {
+	if _, err := s.DB.Exec(`DELETE FROM content_vectors WHERE hash IN (SELECT hash FROM documents WHERE collection = ?)`, collection); err != nil {
+		return err
+	}
+	if !s.hasTable("doc_vectors_vec") {
+		return nil
+	}
+	_, err := s.DB.Exec(`DELETE FROM doc_vectors_vec WHERE hash IN (SELECT hash FROM documents WHERE collection = ?)`, collection)
+	return err
+}
+
+// PruneEmbeddings removes chunk mappings of document versions that are no
+// longer indexed and vectors no chunk refers to. It returns the number of
+// vectors deleted.
+func (s *Store) PruneEmbeddings() (int, error) {
+	if _, err := s.DB.Exec(`DELETE FROM content_vectors WHERE hash NOT IN (SELECT hash FROM documents)`); err != nil {
+		return 0, err
+	}
+	res, err := s.DB.Exec(`
+		DELETE FROM vectors_vec
+		WHERE chunk_key NOT IN (SELECT chunk_key FROM content_vectors WHERE chunk_key IS NOT NULL)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+
+	if s.hasTable("doc_vectors_vec") {
+		if _, err := s.DB.Exec(`DELETE FROM doc_vectors_vec WHERE hash NOT IN (SELECT hash FROM documents)`); err != nil {
+			return int(n), err
+		}
+	}
+	return int(n), nil
+}
+
+// SearchVec returns the chunks closest to queryVec. Without filter it runs a
+// KNN query on the vector index; with a filter the distance is computed for
+// the chunks of matching documents only, so filtering never starves results.
+func (s *Store) SearchVec(queryVec []float32, limit int, filter Filter) ([]SearchResult, error) {
 	queryBlob, err := sqlite_vec.SerializeFloat32(queryVec)
 	if err != nil {
 		return nil, err
 	}
 
+	if !filter.IsZero() {
+		where, args := filter.where()
+		rows, err := s.DB.Query(`
+			SELECT
+				vec_distance_cosine(v.embedding, ?) AS distance,
+				d.id,
+				d.collection || '/' || d.path,
+				d.title,
+				c.doc,
+				d.size,
+				cv.seq,
+				COALESCE(cv.text, '')
+			FROM documents d
+			JOIN content_vectors cv ON cv.hash = d.hash
+			JOIN vectors_vec v ON v.chunk_key = cv.chunk_key
+			JOIN content c ON c.hash = d.hash
+			WHERE d.active = 1`+where+`
+			ORDER BY distance, d.id, cv.seq
+			LIMIT ?
+		`, append(append([]interface{}{queryBlob}, args...), limit)...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		return scanVecResults(rows)
+	}
+
 	query := `
 		WITH vec_results AS (
-			SELECT hash_seq, distance
+			SELECT chunk_key, distance
 			FROM vectors_vec
 			WHERE embedding MATCH ?
 			AND k = ?
 		)
 		SELECT
 			vr.distance,
+			d.id,
 			d.collection || '/' || d.path,
 			d.title,
 			c.doc,
 			d.size,
-			cast(substr(vr.hash_seq, 66) as integer) as seq
+			cv.seq,
+			COALESCE(cv.text, '')
 		FROM vec_results vr
-		JOIN documents d ON d.hash = substr(vr.hash_seq, 1, 64)
+		JOIN content_vectors cv ON cv.chunk_key = vr.chunk_key
+		JOIN documents d ON d.hash = cv.hash
 		JOIN content c ON c.hash = d.hash
-		ORDER BY vr.distance
+		WHERE d.active = 1
+		ORDER BY vr.distance, d.id, cv.seq
 	`
 
 	rows, err := s.DB.Query(query, queryBlob, limit)
@@ -541,10 +885,16 @@ func (s *Store) SearchVec(queryVec []float32, limit int) ([]SearchResult, error)
 	}
 	defer rows.Close()
 
+	return scanVecResults(rows)
+}
+
+// scanVecResults reads rows of (distance, id, filepath, title, body, size,
+// seq, chunk text) into search results scored by cosine similarity.
+func scanVecResults(rows *sql.Rows) ([]SearchResult, error) {
 	var results []SearchResult
 	for rows.Next() {
 		var r SearchResult
-		if err := rows.Scan(&r.Score, &r.Filepath, &r.Title, &r.Body, &r.Size, &r.Seq); err != nil {
+		if err := rows.Scan(&r.Score, &r.DocID, &r.Filepath, &r.Title, &r.Body, &r.Size, &r.Seq, &r.Chunk); err != nil {
 			return nil, err
 		}
 		// Convert cosine distance to similarity score
@@ -559,22 +909,28 @@ func (s *Store) SearchVec(queryVec []float32, limit int) ([]SearchResult, error)
 
 		results = append(results, r)
 	}
-	return results, nil
+	return results, rows.Err()
 }
 
 type PendingDoc struct {
-	Body  string
-	Title string
+	Body       string
+	Title      string
+	Collection string
 }
 
 func (s *Store) GetPendingEmbeddings() (map[string]PendingDoc, error) {
-	// Join with documents table to get the Title
+	// Join with documents table to get the Title and Collection.
+	// Identical content indexed twice is embedded once, as the active
+	// document indexed first: its title and collection give the contextual
+	// header and the chunker of the vectors shared by all the copies.
 	rows, err := s.DB.Query(`
-        SELECT DISTINCT d.hash, d.title, c.doc
+        SELECT d.hash, d.title, d.collection, c.doc
         FROM documents d
         JOIN content c ON d.hash = c.hash
         LEFT JOIN content_vectors cv ON d.hash = cv.hash
         WHERE cv.hash IS NULL
+        AND d.active = 1
+        AND d.id = (SELECT MIN(id) FROM documents WHERE hash = d.hash AND active = 1)
     `)
 	if err != nil {
 		return nil, err
@@ -583,11 +939,11 @@ func (s *Store) GetPendingEmbeddings() (map[string]PendingDoc, error) {
 
 	res := make(map[string]PendingDoc)
 	for rows.Next() {
-		var hash, title, body string
-		if err := rows.Scan(&hash, &title, &body); err != nil {
+		var hash, title, collection, body string
+		if err := rows.Scan(&hash, &title, &collection, &body); err != nil {
 			return nil, err
 		}
-		res[hash] = PendingDoc{Body: body, Title: title}
+		res[hash] = PendingDoc{Body: body, Title: title, Collection: collection}
 	}
 	return res, nil
 }
@@ -611,9 +967,10 @@ func (s *Store) GetDocument(collection, path string) (string, error) {
 }
 
 type Stats struct {
-	TotalDocuments int
-	Collections    int
-	Embeddings     int
+	TotalDocuments int `json:"total_documents"`
+	Collections    int `json:"collections"`
+	Embeddings     int `json:"embeddings"`
+	DocEmbeddings  int `json:"doc_embeddings"`
 }
 
 func (s *Store) GetStats() (*Stats, error) {
@@ -643,36 +1000,110 @@ func (s *Store) GetStats() (*Stats, error) {
 	} else {
 		stats.Embeddings = 0
 	}
+
+	if s.hasTable("doc_vectors_vec") {
+		if err := s.DB.QueryRow("SELECT COUNT(*) FROM doc_vectors_vec").Scan(&stats.DocEmbeddings); err != nil {
+			return nil, err
+		}
+	}
 	return stats, nil
 }
 
+// hasTable reports whether a table (including virtual tables) exists.
+func (s *Store) hasTable(name string) bool {
+	var n int
+	if err := s.DB.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = ?", name).Scan(&n); err != nil {
+		return false
+	}
+	return n > 0
+}
+
+// HybridOptions tunes hybrid search.
+type HybridOptions struct {
+	// Graph adds two ranked lists from the link graph to the fusion: the
+	// candidates ordered by PageRank authority, and the documents linked to
+	// the top results (one-hop expansion).
+	Graph bool
+	// Filter restricts both the keyword and the vector candidates.
+	Filter Filter
+
+	// MinBM25 drops keyword candidates whose BM25 score (higher is better)
+	// is below it. Zero keeps all candidates.
+	MinBM25 float64
+	// MinSimilarity drops vector candidates whose cosine similarity is below
+	// it. Zero keeps all candidates.
+	MinSimilarity float64
+	// MinScore drops fused results whose RRF score is below it.
+	MinScore float64
+
+	// Explain attaches an Explanation to each result.
+	Explain bool
+}
+
 // SearchHybrid performs both FTS and Vector search and combines them using RRF.
-// It fetches more candidates (limit * 2) from each source to ensure good intersection.
-func (s *Store) SearchHybrid(textQuery string, queryVec []float32, limit int, contextLines int) ([]SearchResult, error) {
+// It fetches more candidates (limit * 2, at least minHybridCandidates) from each source to ensure good intersection.
+func (s *Store) SearchHybrid(textQuery string, queryVec []float32, limit int, contextLines int, opts HybridOptions) ([]SearchResult, error) {
 	// Run searches in parallel (mocked here by sequential for simplicity, or use goroutines)
 	// We ask for more results (2x limit) from individual engines to improve fusion quality
-	candidateLimit := limit * 2
+	candidateLimit := max(limit*2, minHybridCandidates)
 
 	// FTS Search
 	// Pass contextLines through to FTS
-	ftsResults, err := s.SearchFTS(textQuery, candidateLimit, contextLines, false)
+	ftsResults, err := s.SearchFTS(textQuery, candidateLimit, contextLines, false, opts.Filter)
 	if err != nil {
 		return nil, fmt.Errorf("FTS search failed: %w", err)
 	}
 
 	// Vector Search
-	vecResults, err := s.SearchVec(queryVec, candidateLimit)
+	vecResults, err := s.SearchVec(queryVec, candidateLimit, opts.Filter)
 	if err != nil {
 		return nil, fmt.Errorf("vector search failed: %w", err)
 	}
 
+	// Both lists are sorted best first, so thresholds only cut their tails
+	// and leave the ranks of the remaining candidates unchanged.
+	if opts.MinBM25 > 0 {
+		ftsResults = truncateWhile(ftsResults, func(r SearchResult) bool { return -r.Score >= opts.MinBM25 })
+	}
+	if opts.MinSimilarity > 0 {
+		vecResults = truncateWhile(vecResults, func(r SearchResult) bool { return r.Score >= opts.MinSimilarity })
+	}
+
 	// Fuse Results
+	lists := []rankedList{{SourceBM25, ftsResults}, {SourceVector, vecResults}}
 	fused := ReciprocalRankFusion(ftsResults, vecResults)
 
+	if opts.Graph && len(fused) > 0 {
+		authority, expanded, err := s.graphLists(fused, opts.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("graph ranking failed: %w", err)
+		}
+		lists = append(lists, rankedList{SourceAuthority, authority}, rankedList{SourceExpansion, expanded})
+		fused = ReciprocalRankFusion(ftsResults, vecResults, authority, expanded)
+	}
+
+	if opts.MinScore > 0 {
+		fused = truncateWhile(fused, func(r SearchResult) bool { return r.Score >= opts.MinScore })
+	}
+
 	// Apply final limit
 	if len(fused) > limit {
 		fused = fused[:limit]
 	}
 
+	if opts.Explain {
+		explainFusion(fused, lists)
+	}
+
 	return fused, nil
 }
+
+// truncateWhile returns the leading results for which keep returns true.
+func truncateWhile(results []SearchResult, keep func(SearchResult) bool) []SearchResult {
+	for i, r := range results {
+		if !keep(r) {
+			return results[:i]
+		}
+	}
+	return results
+}
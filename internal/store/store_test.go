@@ -1,11 +1,15 @@
 package store_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/akhenakh/qmd/internal/config"
 	"github.com/akhenakh/qmd/internal/store"
+	"github.com/akhenakh/qmd/internal/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -79,7 +83,7 @@ We are discussing the architecture of Project Alpha.
 	require.NoError(t, err)
 
 	// Test: Search for a word in the body
-	results, err := s.SearchFTS("architecture", 10, 1, false)
+	results, err := s.SearchFTS("architecture", 10, 1, false, store.Filter{})
 	require.NoError(t, err)
 
 	if len(results) == 0 {
@@ -97,6 +101,20 @@ We are discussing the architecture of Project Alpha.
 	assert.Equal(t, "work/alpha.md", results[0].Filepath)
 }
 
+// TestSearchFTSUnicodeMatches checks that matches are located on the right
+// line when lowercasing changes the byte length of the text before them.
+func TestSearchFTSUnicodeMatches(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	require.NoError(t, s.IndexDocument("notes", "istanbul.md", "İİİİİİ\nKafka in İstanbul\nȺȺȺ\nanother Kafka line\nlast line"))
+
+	results, err := s.SearchFTS("kafka", 10, 0, true, store.Filter{})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, []string{"-> Kafka in İstanbul", "-> another Kafka line"}, results[0].Matches)
+}
+
 func TestIndexAndGetDocument(t *testing.T) {
 	s, cleanup := setupTestEnv(t)
 	defer cleanup()
@@ -122,7 +140,7 @@ func TestUpdateDocument(t *testing.T) {
 	require.NoError(t, err)
 
 	// Verify initial search
-	res, _ := s.SearchFTS("initial", 10, 0, false)
+	res, _ := s.SearchFTS("initial", 10, 0, false, store.Filter{})
 	require.Len(t, res, 1)
 
 	// 2. Update doc
@@ -130,14 +148,51 @@ func TestUpdateDocument(t *testing.T) {
 	require.NoError(t, err)
 
 	// 3. Search for OLD term (should fail)
-	res, _ = s.SearchFTS("initial", 10, 0, false)
+	res, _ = s.SearchFTS("initial", 10, 0, false, store.Filter{})
 	assert.Len(t, res, 0, "Old content should be removed from FTS index")
 
 	// 4. Search for NEW term (should succeed)
-	res, _ = s.SearchFTS("updated", 10, 0, false)
+	res, _ = s.SearchFTS("updated", 10, 0, false, store.Filter{})
 	assert.Len(t, res, 1, "New content should be present in FTS index")
 }
 
+// TestPendingEmbeddingsSharedContent checks that content indexed in several
+// collections is embedded once, as its first active document.
+func TestPendingEmbeddingsSharedContent(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	content := "# Shared\nSame content in every collection"
+	require.NoError(t, s.IndexDocument("archive", "shared.md", content))
+	require.NoError(t, s.IndexDocument("notes", "shared.md", content))
+	require.NoError(t, s.IndexDocument("work", "shared.md", content))
+
+	for i := 0; i < 5; i++ {
+		pending, err := s.GetPendingEmbeddings()
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		for _, doc := range pending {
+			assert.Equal(t, "archive", doc.Collection)
+		}
+	}
+
+	// Inactive documents neither give the header nor stay pending
+	_, err := s.DB.Exec(`UPDATE documents SET active = 0 WHERE collection = 'archive'`)
+	require.NoError(t, err)
+	pending, err := s.GetPendingEmbeddings()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	for _, doc := range pending {
+		assert.Equal(t, "notes", doc.Collection)
+	}
+
+	_, err = s.DB.Exec(`UPDATE documents SET active = 0`)
+	require.NoError(t, err)
+	pending, err = s.GetPendingEmbeddings()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
 func TestVectors(t *testing.T) {
 	s, cleanup := setupTestEnv(t)
 	defer cleanup()
@@ -161,12 +216,623 @@ func TestVectors(t *testing.T) {
 	vec := make([]float32, 768)
 	vec[0] = 0.5
 	vec[1] = 0.5
-	err = s.SaveEmbedding(hash, 0, vec)
+	err = s.SaveEmbedding(hash, 0, "key0", content, vec)
 	assert.NoError(t, err)
 
 	// Search Vector
-	results, err := s.SearchVec(vec, 5)
+	results, err := s.SearchVec(vec, 5, store.Filter{})
 	assert.NoError(t, err)
 	require.Len(t, results, 1)
 	assert.Equal(t, "vec/vec.md", results[0].Filepath)
+	assert.Equal(t, content, results[0].Chunk)
+}
+
+func TestEmbeddingCacheSharedAcrossDocuments(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	require.NoError(t, s.IndexDocument("vec", "a.md", "Shared chunk A"))
+	require.NoError(t, s.IndexDocument("vec", "b.md", "Shared chunk B"))
+
+	pending, err := s.GetPendingEmbeddings()
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+
+	vec := make([]float32, 768)
+	vec[0] = 1
+
+	// The first document stores the vector, the second only references it
+	first := true
+	for hash := range pending {
+		if first {
+			require.NoError(t, s.SaveEmbedding(hash, 0, "shared", "Shared chunk", vec))
+			first = false
+			continue
+		}
+		exists, err := s.HasEmbedding("shared")
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.NoError(t, s.SaveEmbedding(hash, 0, "shared", "Shared chunk", nil))
+	}
+
+	results, err := s.SearchVec(vec, 5, store.Filter{})
+	require.NoError(t, err)
+	assert.Len(t, results, 2, "both documents should be found through the shared vector")
+
+	// Editing a document makes its previous version stale
+	require.NoError(t, s.IndexDocument("vec", "a.md", "Edited chunk A"))
+	require.NoError(t, s.IndexDocument("vec", "b.md", "Edited chunk B"))
+	pruned, err := s.PruneEmbeddings()
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+
+	exists, err := s.HasEmbedding("shared")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestDocEmbeddingsCoarseSearch(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	require.NoError(t, s.IndexDocument("notes", "a.md", "Doc A"))
+	require.NoError(t, s.IndexDocument("notes", "b.md", "Doc B"))
+
+	pending, err := s.GetPendingEmbeddings()
+	require.NoError(t, err)
+
+	// Document A points along the first axis, B along the second
+	hashes := make(map[string]string)
+	for hash, doc := range pending {
+		hashes[doc.Body] = hash
+	}
+	vecA := make([]float32, 768)
+	vecA[0] = 1
+	vecB := make([]float32, 768)
+	vecB[1] = 1
+	require.NoError(t, s.SaveEmbedding(hashes["Doc A"], 0, "a0", "Doc A", vecA))
+	require.NoError(t, s.SaveEmbedding(hashes["Doc B"], 0, "b0", "Doc B", vecB))
+
+	docPending, err := s.GetPendingDocEmbeddings()
+	require.NoError(t, err)
+	require.Len(t, docPending, 2)
+
+	for hash := range docPending {
+		vecs, err := s.GetChunkEmbeddings(hash)
+		require.NoError(t, err)
+		require.Len(t, vecs, 1)
+		require.NoError(t, s.SaveDocEmbedding(hash, store.MeanVector(vecs)))
+	}
+
+	docPending, err = s.GetPendingDocEmbeddings()
+	require.NoError(t, err)
+	assert.Len(t, docPending, 0)
+
+	stored, err := s.GetDocEmbedding(hashes["Doc A"])
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0, stored[0], 1e-6)
+
+	// Only the closest document survives the coarse pass
+	results, err := s.SearchVecCoarse(vecA, 1, 10, store.Filter{})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "notes/a.md", results[0].Filepath)
+	assert.InDelta(t, 1.0, results[0].Score, 1e-6)
+}
+
+func TestSimilarDocuments(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	docs := map[string]string{
+		"postgres-tuning.md": "Postgres tuning: vacuum and shared buffers",
+		"postgres-backup.md": "Postgres backup with vacuum checks",
+		"gardening.md":       "Tomatoes need sun",
+	}
+	for path, content := range docs {
+		require.NoError(t, s.IndexDocument("notes", path, content))
+	}
+	require.NoError(t, s.IndexDocument("other", "postgres.md", "Postgres notes"))
+	// A copy of the source document is not a similar document
+	require.NoError(t, s.IndexDocument("copies", "postgres-tuning.md", docs["postgres-tuning.md"]))
+
+	pending, err := s.GetPendingEmbeddings()
+	require.NoError(t, err)
+
+	// Postgres documents are close to each other, gardening is orthogonal
+	for hash, doc := range pending {
+		vec := make([]float32, 768)
+		switch doc.Body {
+		case docs["postgres-tuning.md"]:
+			vec[0] = 1
+		case docs["postgres-backup.md"]:
+			vec[0], vec[1] = 0.9, 0.1
+		case "Postgres notes":
+			vec[0], vec[1] = 0.8, 0.2
+		default:
+			vec[2] = 1
+		}
+		require.NoError(t, s.SaveEmbedding(hash, 0, hash, doc.Body, vec))
+	}
+
+	results, err := s.SimilarDocuments("notes", "postgres-tuning.md", 2, false, store.Filter{})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "notes/postgres-backup.md", results[0].Filepath)
+	assert.Equal(t, "other/postgres.md", results[1].Filepath)
+
+	// The collection filter drops the other collection
+	results, err = s.SimilarDocuments("notes", "postgres-tuning.md", 2, true, store.Filter{Collection: "notes"})
+	require.NoError(t, err)
+	for _, r := range results {
+		assert.NotEqual(t, "notes/postgres-tuning.md", r.Filepath, "the document itself must be excluded")
+		assert.NotEqual(t, "other/postgres.md", r.Filepath)
+	}
+	assert.Equal(t, "notes/postgres-backup.md", results[0].Filepath)
+
+	n, err := s.CountSimilar(store.Filter{Collection: "notes"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = s.SimilarDocuments("notes", "missing.md", 2, false, store.Filter{})
+	assert.Error(t, err)
+}
+
+// TestSimilarDocumentsFiltered checks that a filter keeps matching documents
+// that are further away than the KNN candidates.
+func TestSimilarDocumentsFiltered(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	for i := 0; i < 12; i++ {
+		require.NoError(t, s.IndexDocument("notes", fmt.Sprintf("postgres-%d.md", i), fmt.Sprintf("Postgres note %d", i)))
+	}
+	require.NoError(t, s.IndexDocument("garden", "tomatoes.md", "Tomatoes need sun"))
+
+	pending, err := s.GetPendingEmbeddings()
+	require.NoError(t, err)
+	for hash, doc := range pending {
+		vec := make([]float32, 768)
+		if strings.HasPrefix(doc.Body, "Postgres") {
+			vec[0], vec[1] = 1, float32(len(doc.Body))/100
+		} else {
+			vec[0], vec[2] = 0.1, 1
+		}
+		require.NoError(t, s.SaveEmbedding(hash, 0, hash, doc.Body, vec))
+		require.NoError(t, s.SaveDocEmbedding(hash, vec))
+	}
+
+	results, err := s.SimilarDocuments("notes", "postgres-0.md", 1, false, store.Filter{Collection: "garden"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "garden/tomatoes.md", results[0].Filepath)
+}
+
+func TestLinksAndBacklinks(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	require.NoError(t, s.IndexDocument("vault", "index.md", `# Index
+See [[Postgres Tuning]], [[postgres tuning|the tuning note]] and [[PG#Vacuum]].
+Also [backup](ops/backup.md) and [[Missing Note]].
+`+"`[[Not A Link]]`"+`
+`))
+	require.NoError(t, s.IndexDocument("vault", "db/Postgres Tuning.md", "# Tuning\nBack to [index](../index.md)"))
+	require.NoError(t, s.IndexDocument("vault", "ops/backup.md", "---\naliases: [PG]\n---\n# Backup"))
+
+	broken, err := s.ResolveLinks()
+	require.NoError(t, err)
+	assert.Equal(t, 1, broken)
+
+	links, err := s.GetLinks("vault", "index.md")
+	require.NoError(t, err)
+	require.Len(t, links, 5)
+	assert.Equal(t, "vault/db/Postgres Tuning.md", links[0].Resolved)
+	assert.Equal(t, "vault/db/Postgres Tuning.md", links[1].Resolved)
+	assert.Equal(t, "the tuning note", links[1].Alias)
+	assert.Equal(t, "vault/ops/backup.md", links[2].Resolved, "alias should resolve")
+	assert.Equal(t, "Vacuum", links[2].Heading)
+
+	backlinks, err := s.GetBacklinks("vault", "ops/backup.md")
+	require.NoError(t, err)
+	assert.Len(t, backlinks, 2)
+
+	backlinks, err = s.GetBacklinks("vault", "index.md")
+	require.NoError(t, err)
+	require.Len(t, backlinks, 1)
+	assert.Equal(t, "vault/db/Postgres Tuning.md", backlinks[0].Source)
+
+	brokenLinks, err := s.GetBrokenLinks("")
+	require.NoError(t, err)
+	require.Len(t, brokenLinks, 1)
+	assert.Equal(t, "Missing Note", brokenLinks[0].Target)
+}
+
+func TestGraphRankAndExpansion(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	docs := map[string]string{
+		"a.md":   "# A\nKafka consumer groups #streaming, see [[Hub]]",
+		"b.md":   "# B\nBroker settings, see [[Hub]]",
+		"c.md":   "# C\nUnrelated note, see [[Hub]]",
+		"hub.md": "# Hub\nEverything starts here",
+	}
+	axis := map[string][]float32{
+		"a.md":   {1, 0, 0},
+		"b.md":   {1, 1, 0},
+		"c.md":   {0.1, 1, 0},
+		"hub.md": {0, 0, 1},
+	}
+	for path, content := range docs {
+		require.NoError(t, s.IndexDocument("vault", path, content))
+	}
+	_, err := s.ResolveLinks()
+	require.NoError(t, err)
+	require.NoError(t, s.ComputeGraphRank())
+
+	hubRank, err := s.GraphRank("vault", "hub.md")
+	require.NoError(t, err)
+	aRank, err := s.GraphRank("vault", "a.md")
+	require.NoError(t, err)
+	assert.Greater(t, hubRank, aRank)
+
+	for path, content := range docs {
+		vec := make([]float32, 768)
+		copy(vec, axis[path])
+		require.NoError(t, s.SaveEmbedding(util.HashContent(content), 0, path, content, vec))
+	}
+
+	query := make([]float32, 768)
+	query[0] = 1
+
+	position := func(results []store.SearchResult, fp string) int {
+		for i, r := range results {
+			if r.Filepath == fp {
+				return i
+			}
+		}
+		return -1
+	}
+
+	plain, err := s.SearchHybrid("kafka", query, 4, 0, store.HybridOptions{})
+	require.NoError(t, err)
+	assert.Less(t, position(plain, "vault/c.md"), position(plain, "vault/hub.md"))
+
+	boosted, err := s.SearchHybrid("kafka", query, 4, 0, store.HybridOptions{Graph: true})
+	require.NoError(t, err)
+	assert.Equal(t, "vault/a.md", boosted[0].Filepath)
+	assert.Less(t, position(boosted, "vault/hub.md"), position(boosted, "vault/c.md"))
+
+	// The expansion keeps to the filter, the hub is not tagged
+	filtered, err := s.SearchHybrid("kafka", query, 4, 0, store.HybridOptions{Graph: true, Filter: store.Filter{Tags: []string{"streaming"}}})
+	require.NoError(t, err)
+	require.Len(t, filtered, 1)
+	assert.Equal(t, "vault/a.md", filtered[0].Filepath)
+
+	// A deactivated document is not pulled back in by the expansion
+	_, err = s.DB.Exec(`UPDATE documents SET active = 0 WHERE path = 'hub.md'`)
+	require.NoError(t, err)
+	boosted, err = s.SearchHybrid("kafka", query, 4, 0, store.HybridOptions{Graph: true})
+	require.NoError(t, err)
+	assert.Equal(t, -1, position(boosted, "vault/hub.md"))
+}
+
+func TestTagsAndFilters(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	require.NoError(t, s.IndexDocument("vault", "a.md", "---\ntags: [Infra, project/alpha]\n---\n# A\nDeploy notes #ops/k8s"))
+	require.NoError(t, s.IndexDocument("vault", "b.md", "# B\nDeploy checklist #ops and issue #123\n```\n#not-a-tag\n```"))
+	require.NoError(t, s.IndexDocument("other", "c.md", "# C\nDeploy `#code` #Project/Beta"))
+
+	tags, err := s.GetTags("vault", "a.md")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"infra", "ops/k8s", "project/alpha"}, tags)
+
+	tags, err = s.GetTags("vault", "b.md")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ops"}, tags)
+
+	counts, err := s.ListTags("")
+	require.NoError(t, err)
+	byTag := make(map[string]int)
+	for _, c := range counts {
+		byTag[c.Tag] = c.Count
+	}
+	assert.Equal(t, map[string]int{
+		"infra": 1, "ops": 2, "ops/k8s": 1, "project": 2, "project/alpha": 1, "project/beta": 1,
+	}, byTag)
+
+	counts, err = s.ListTags("other")
+	require.NoError(t, err)
+	assert.Len(t, counts, 2)
+
+	// A parent tag matches nested tags, several tags must all match
+	results, err := s.SearchFTS("deploy", 10, 0, false, store.Filter{Tags: []string{"ops"}})
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	results, err = s.SearchFTS("deploy", 10, 0, false, store.Filter{Tags: []string{"#OPS", "project"}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "vault/a.md", results[0].Filepath)
+
+	results, err = s.SearchFTS("deploy", 10, 0, false, store.Filter{Tags: []string{"proj"}})
+	require.NoError(t, err)
+	assert.Len(t, results, 0, "tag prefixes only match whole hierarchy levels")
+
+	// Filtered vector search only considers matching documents
+	vec := make([]float32, 768)
+	vec[0] = 1
+	for _, doc := range []struct{ path, content string }{
+		{"a.md", "---\ntags: [Infra, project/alpha]\n---\n# A\nDeploy notes #ops/k8s"},
+		{"b.md", "# B\nDeploy checklist #ops and issue #123\n```\n#not-a-tag\n```"},
+	} {
+		require.NoError(t, s.SaveEmbedding(util.HashContent(doc.content), 0, doc.path, doc.content, vec))
+	}
+	results, err = s.SearchVec(vec, 10, store.Filter{Tags: []string{"infra"}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "vault/a.md", results[0].Filepath)
+
+	results, err = s.SearchVec(vec, 10, store.Filter{Collection: "other"})
+	require.NoError(t, err)
+	assert.Len(t, results, 0)
+}
+
+func TestOutlineAndSections(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	content := `---
+title: Runbook
+---
+# Runbook
+Intro
+
+## Deploy
+Steps
+` + "```" + `
+# not a heading
+` + "```" + `
+### Rollback
+Revert the release
+
+## Monitoring
+Dashboards
+`
+	require.NoError(t, s.IndexDocument("ops", "runbook.md", content))
+
+	outline, err := s.GetOutline("ops", "runbook.md")
+	require.NoError(t, err)
+	require.Len(t, outline, 4)
+	assert.Equal(t, store.Section{Level: 1, Heading: "Runbook", Path: []string{"Runbook"}, Line: 4, EndLine: 16}, outline[0])
+	assert.Equal(t, 7, outline[1].Line)
+	assert.Equal(t, 14, outline[1].EndLine, "a section includes its subsections")
+	assert.Equal(t, []string{"Runbook", "Deploy", "Rollback"}, outline[2].Path)
+	assert.Equal(t, 12, outline[2].Line)
+
+	sec, err := s.FindSection("ops", "runbook.md", "deploy/rollback")
+	require.NoError(t, err)
+	assert.Equal(t, "Rollback", sec.Heading)
+	assert.Equal(t, "### Rollback\nRevert the release\n", util.LineRange(content, sec.Line, sec.EndLine))
+
+	sec, err = s.FindSection("ops", "runbook.md", "Runbook/Monitoring")
+	require.NoError(t, err)
+	assert.Equal(t, 15, sec.Line)
+
+	_, err = s.FindSection("ops", "runbook.md", "Monitoring/Rollback")
+	assert.Error(t, err)
+}
+
+func TestListDocuments(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	require.NoError(t, s.IndexDocument("notes", "docs/a.md", "# A"))
+	require.NoError(t, s.IndexDocument("notes", "docs/b.md", "# B"))
+	require.NoError(t, s.IndexDocument("notes", "todo.md", "# Todo"))
+	require.NoError(t, s.IndexDocument("work", "docs/c.md", "# C"))
+
+	vec := make([]float32, 768)
+	vec[0] = 1
+	require.NoError(t, s.SaveEmbedding(util.HashContent("# A"), 0, "a0", "# A", vec))
+
+	docs, err := s.ListDocuments("", "")
+	require.NoError(t, err)
+	assert.Len(t, docs, 4)
+
+	docs, err = s.ListDocuments("notes", "docs/")
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "notes/docs/a.md", docs[0].Filepath())
+	assert.Equal(t, "A", docs[0].Title)
+	assert.Equal(t, 3, docs[0].Size)
+	assert.True(t, docs[0].Embedded)
+	assert.False(t, docs[1].Embedded)
+	assert.False(t, docs[0].ModifiedAt.IsZero())
+}
+
+func TestPagination(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	for i := 0; i < 5; i++ {
+		path := fmt.Sprintf("doc%d.md", i)
+		require.NoError(t, s.IndexDocument("notes", path, "# Doc\nThe same pagination text."))
+	}
+
+	all, err := s.SearchFTS("pagination", 10, 0, false, store.Filter{})
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+
+	total, err := s.CountFTS("pagination", store.Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, total)
+
+	// Identical scores must still produce the same order on every call.
+	var paged []store.SearchResult
+	for offset := 0; offset < 5; offset += 2 {
+		results, err := s.SearchFTS("pagination", offset+2, 0, false, store.Filter{})
+		require.NoError(t, err)
+		paged = append(paged, store.PageResults(results, offset, 2)...)
+	}
+	require.Len(t, paged, 5)
+	for i := range all {
+		assert.Equal(t, all[i].Filepath, paged[i].Filepath)
+	}
+
+	assert.Nil(t, store.PageResults(all, 10, 2))
+
+	// Vector search ranks documents, however many chunks they have
+	hash := util.HashContent("# Doc\nThe same pagination text.")
+	for seq := 0; seq < 3; seq++ {
+		vec := make([]float32, 768)
+		vec[seq] = 1
+		require.NoError(t, s.SaveEmbedding(hash, seq, fmt.Sprintf("chunk %d", seq), "", vec))
+	}
+	total, err = s.CountVec(store.Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, total)
+}
+
+func TestHybridExplainAndThresholds(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	docs := map[string]string{
+		"a.md": "# A\nKafka consumer groups",
+		"b.md": "# B\nBroker settings",
+		"c.md": "# C\nUnrelated note",
+	}
+	axis := map[string][]float32{
+		"a.md": {1, 0, 0},
+		"b.md": {1, 1, 0},
+		"c.md": {0, 0, 1},
+	}
+	for path, content := range docs {
+		require.NoError(t, s.IndexDocument("vault", path, content))
+		vec := make([]float32, 768)
+		copy(vec, axis[path])
+		require.NoError(t, s.SaveEmbedding(util.HashContent(content), 0, path, content, vec))
+	}
+
+	query := make([]float32, 768)
+	query[0] = 1
+
+	results, err := s.SearchHybrid("kafka", query, 3, 0, store.HybridOptions{Explain: true})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	top := results[0]
+	assert.Equal(t, "vault/a.md", top.Filepath)
+	require.NotNil(t, top.Explain)
+	require.NotNil(t, top.Explain.BM25)
+	assert.Equal(t, 1, top.Explain.BM25.Rank)
+	assert.Greater(t, top.Explain.BM25.Score, 0.0)
+	require.NotNil(t, top.Explain.Vector)
+	assert.Equal(t, 1, top.Explain.Vector.Rank)
+	assert.InDelta(t, 1.0, top.Explain.Vector.Score, 1e-5)
+	assert.Equal(t, 0, *top.Explain.ChunkSeq)
+	var sum float64
+	for _, c := range top.Explain.Contributions {
+		sum += c
+	}
+	assert.InDelta(t, top.Score, sum, 1e-9)
+
+	for _, r := range results[1:] {
+		assert.Nil(t, r.Explain.BM25, r.Filepath)
+	}
+
+	// Only the keyword match and the close vector survive the thresholds.
+	results, err = s.SearchHybrid("kafka", query, 3, 0, store.HybridOptions{MinSimilarity: 0.5})
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Nil(t, results[0].Explain)
+
+	results, err = s.SearchHybrid("kafka", query, 3, 0, store.HybridOptions{MinScore: 0.02})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "vault/a.md", results[0].Filepath)
+}
+
+func TestChatSessions(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	first, err := s.CreateChatSession("ollama", "llama3")
+	require.NoError(t, err)
+	second, err := s.CreateChatSession("openai", "qwen3")
+	require.NoError(t, err)
+
+	err = s.AppendChatMessages(first, []store.ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "How do consumer groups rebalance when a new consumer joins the group?\nDetails please."},
+		{Role: "assistant", ToolCalls: []byte(`[{"function":{"name":"query","arguments":{"query":"rebalance"}}}]`)},
+		{Role: "tool", Content: "[results]", Name: "query", ToolCallID: "call_0"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.AppendChatMessages(first, []store.ChatMessage{{Role: "assistant", Content: "They stop and reassign."}}))
+
+	cs, err := s.GetChatSession(first)
+	require.NoError(t, err)
+	assert.Equal(t, "How do consumer groups rebalance when a new consumer joins…", cs.Title)
+	assert.Equal(t, "llama3", cs.Model)
+	assert.Equal(t, 5, cs.MessageCount)
+
+	msgs, err := s.GetChatMessages(first)
+	require.NoError(t, err)
+	require.Len(t, msgs, 5)
+	for i, m := range msgs {
+		assert.Equal(t, i, m.Seq)
+	}
+	assert.JSONEq(t, `[{"function":{"name":"query","arguments":{"query":"rebalance"}}}]`, string(msgs[2].ToolCalls))
+	assert.Nil(t, msgs[3].ToolCalls)
+	assert.Equal(t, "call_0", msgs[3].ToolCallID)
+	assert.Equal(t, "They stop and reassign.", msgs[4].Content)
+
+	require.NoError(t, s.UpdateChatMessage(first, 0, "Be detailed."))
+	msgs, err = s.GetChatMessages(first)
+	require.NoError(t, err)
+	assert.Equal(t, "Be detailed.", msgs[0].Content)
+	assert.Error(t, s.UpdateChatMessage(first, 9, "missing"))
+
+	sessions, err := s.ListChatSessions(0)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	ids := []int64{sessions[0].ID, sessions[1].ID}
+	assert.ElementsMatch(t, []int64{first, second}, ids)
+
+	sessions, err = s.ListChatSessions(1)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 1)
+
+	_, err = s.GetChatSession(999)
+	assert.Error(t, err)
+	assert.Error(t, s.AppendChatMessages(999, []store.ChatMessage{{Role: "user"}}))
+}
+
+func TestConfigMCPServers(t *testing.T) {
+	s, cleanup := setupTestEnv(t)
+	defer cleanup()
+
+	cfg, err := s.LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.ChatMCPServers)
+
+	cfg.ChatMCPServers = []config.MCPServer{
+		{Name: "git", Command: "uvx", Args: []string{"mcp-server-git"}, Env: []string{"GIT_DIR=/notes"}},
+		{Name: "issues", URL: "https://example.com/mcp", Headers: map[string]string{"Authorization": "Bearer x"}},
+	}
+	require.NoError(t, s.SaveConfig(cfg))
+	loaded, err := s.LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, cfg.ChatMCPServers, loaded.ChatMCPServers)
+
+	loaded.ChatMCPServers = nil
+	require.NoError(t, s.SaveConfig(loaded))
+	loaded, err = s.LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, loaded.ChatMCPServers)
 }
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/util"
+)
+
+// TagCount is a tag and the number of documents carrying it or one of its
+// nested tags.
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// saveTags replaces the tags of a document.
+func saveTags(tx *sql.Tx, docID int64, content string) error {
+	if _, err := tx.Exec(`DELETE FROM tags WHERE doc_id = ?`, docID); err != nil {
+		return err
+	}
+	for _, tag := range util.ExtractTags(content) {
+		if _, err := tx.Exec(`INSERT INTO tags (doc_id, tag) VALUES (?, ?)`, docID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListTags returns every tag with its document count, sorted by tag.
+// Parents of nested tags are listed too: a document tagged "area/sub" counts
+// for both "area" and "area/sub". An empty collection lists all collections.
+func (s *Store) ListTags(collection string) ([]TagCount, error) {
+	query := `
+		SELECT t.doc_id, t.tag
+		FROM tags t
+		JOIN documents d ON d.id = t.doc_id
+		WHERE d.active = 1`
+	var args []interface{}
+	if collection != "" {
+		query += ` AND d.collection = ?`
+		args = append(args, collection)
+	}
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := make(map[string]map[int64]bool)
+	for rows.Next() {
+		var docID int64
+		var tag string
+		if err := rows.Scan(&docID, &tag); err != nil {
+			return nil, err
+		}
+		parts := strings.Split(tag, "/")
+		for i := range parts {
+			t := strings.Join(parts[:i+1], "/")
+			if docs[t] == nil {
+				docs[t] = make(map[int64]bool)
+			}
+			docs[t][docID] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make([]TagCount, 0, len(docs))
+	for tag, ids := range docs {
+		counts = append(counts, TagCount{Tag: tag, Count: len(ids)})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Tag < counts[j].Tag })
+	return counts, nil
+}
+
+// GetTags returns the tags of a document.
+func (s *Store) GetTags(collection, path string) ([]string, error) {
+	rows, err := s.DB.Query(`
+		SELECT t.tag
+		FROM tags t
+		JOIN documents d ON d.id = t.doc_id
+		WHERE d.collection = ? AND d.path = ?
+		ORDER BY t.tag
+	`, collection, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,44 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// RenderedLine maps a source line to a 0-based line of the rendered markdown.
+// Rendering drops markup and rewraps text, so it looks for the query in the
+// rendered text near the expected position, and falls back to scaling the
+// source line.
+func RenderedLine(rendered, query string, line, total int) int {
+	lines := strings.Split(rendered, "\n")
+	estimate := 0
+	if total > 1 {
+		estimate = (line - 1) * (len(lines) - 1) / (total - 1)
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return estimate
+	}
+	best := -1
+	for i, l := range lines {
+		if !strings.Contains(strings.ToLower(ansi.Strip(l)), query) {
+			continue
+		}
+		if best < 0 || abs(i-estimate) < abs(best-estimate) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return estimate
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
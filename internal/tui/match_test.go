@@ -0,0 +1,16 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderedLine(t *testing.T) {
+	rendered := "\x1b[1mTitle\x1b[0m\n\nIntro text\n\nKafka\n\nConsumer groups rebalance.\n"
+
+	assert.Equal(t, 4, RenderedLine(rendered, "kafka", 5, 6))
+	assert.Equal(t, 0, RenderedLine(rendered, "title", 1, 6))
+	// No match in the rendered text: scale the source line.
+	assert.Equal(t, 7, RenderedLine(rendered, "zzz", 6, 6))
+}
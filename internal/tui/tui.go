@@ -0,0 +1,447 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/akhenakh/qmd/internal/llm"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/akhenakh/qmd/internal/util"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Search modes
+const (
+	ModeBM25   = "bm25"
+	ModeVector = "vector"
+	ModeHybrid = "hybrid"
+)
+
+const (
+	// debounce is how long typing must pause before a search runs.
+	debounce   = 200 * time.Millisecond
+	maxResults = 50
+)
+
+var (
+	promptStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("5"))
+
+	statusStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("241"))
+
+	errorStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("9"))
+
+	selectedStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("2"))
+
+	itemStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("252"))
+
+	listStyle = lipgloss.NewStyle().
+			BorderStyle(lipgloss.NormalBorder()).
+			BorderRight(true).
+			BorderForeground(lipgloss.Color("240")).
+			PaddingRight(1)
+)
+
+// Options configures the search UI.
+type Options struct {
+	// Mode is the initial search mode.
+	Mode string
+	// Collections and Tags are the values the filter toggles cycle through.
+	Collections []string
+	Tags        []string
+	// Filter is the initial filter.
+	Filter store.Filter
+	// Resolve maps a result path (collection/path) to a file on disk, or
+	// returns "" if the document has no file (e.g. archive collections).
+	Resolve func(docPath string) string
+}
+
+type model struct {
+	store    *store.Store
+	embedder llm.Embedder
+	opts     Options
+
+	input    textinput.Model
+	preview  viewport.Model
+	width    int
+	height   int
+	modes    []string
+	mode     int
+	filter   store.Filter
+	renderer *glamour.TermRenderer
+
+	// searchID identifies the latest query, older results are discarded.
+	searchID  int
+	results   []store.SearchResult
+	selected  int
+	searching bool
+	err       error
+	statusMsg string
+}
+
+type debounceMsg struct{ id int }
+
+type resultsMsg struct {
+	id      int
+	results []store.SearchResult
+	err     error
+}
+
+type editorMsg struct {
+	err     error
+	tmpFile string
+}
+
+// Run starts the interactive search. embedder may be nil, in which case only
+// keyword search is available.
+func Run(s *store.Store, embedder llm.Embedder, opts Options) error {
+	m := newModel(s, embedder, opts)
+	if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+		return fmt.Errorf("error running search UI: %w", err)
+	}
+	return nil
+}
+
+func newModel(s *store.Store, embedder llm.Embedder, opts Options) model {
+	ti := textinput.New()
+	ti.Placeholder = "Search your notes..."
+	ti.Prompt = ""
+	ti.Focus()
+	ti.CharLimit = 512
+
+	modes := []string{ModeBM25}
+	if embedder != nil {
+		modes = append(modes, ModeVector, ModeHybrid)
+	}
+	mode := 0
+	for i, name := range modes {
+		if name == opts.Mode {
+			mode = i
+		}
+	}
+
+	return model{
+		store:    s,
+		embedder: embedder,
+		opts:     opts,
+		input:    ti,
+		preview:  viewport.New(0, 0),
+		modes:    modes,
+		mode:     mode,
+		filter:   opts.Filter,
+	}
+}
+
+func (m model) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.input.Width = msg.Width - 12
+		m.preview.Width = msg.Width - m.listWidth() - 3
+		m.preview.Height = max(msg.Height-3, 0)
+		m.renderer = nil
+		m.updatePreview()
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyEsc:
+			return m, tea.Quit
+
+		case tea.KeyTab:
+			m.mode = (m.mode + 1) % len(m.modes)
+			return m.search()
+
+		case tea.KeyCtrlF:
+			m.filter.Collection = next(m.opts.Collections, m.filter.Collection)
+			return m.search()
+
+		case tea.KeyCtrlT:
+			var current string
+			if len(m.filter.Tags) > 0 {
+				current = m.filter.Tags[0]
+			}
+			m.filter.Tags = nil
+			if tag := next(m.opts.Tags, current); tag != "" {
+				m.filter.Tags = []string{tag}
+			}
+			return m.search()
+
+		case tea.KeyUp, tea.KeyCtrlP:
+			m.selected = max(m.selected-1, 0)
+			m.updatePreview()
+			return m, nil
+
+		case tea.KeyDown, tea.KeyCtrlN:
+			m.selected = max(min(m.selected+1, len(m.results)-1), 0)
+			m.updatePreview()
+			return m, nil
+
+		case tea.KeyPgUp, tea.KeyPgDown:
+			var cmd tea.Cmd
+			m.preview, cmd = m.preview.Update(msg)
+			return m, cmd
+
+		case tea.KeyEnter:
+			if len(m.results) == 0 {
+				return m, nil
+			}
+			return m, m.openEditor(m.results[m.selected])
+		}
+
+		before := m.input.Value()
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		if m.input.Value() == before {
+			return m, cmd
+		}
+		m.searchID++
+		id := m.searchID
+		return m, tea.Batch(cmd, tea.Tick(debounce, func(time.Time) tea.Msg { return debounceMsg{id: id} }))
+
+	case debounceMsg:
+		if msg.id != m.searchID {
+			return m, nil
+		}
+		return m.search()
+
+	case resultsMsg:
+		if msg.id != m.searchID {
+			return m, nil
+		}
+		m.searching = false
+		m.err = msg.err
+		m.results = msg.results
+		m.selected = 0
+		m.updatePreview()
+		return m, nil
+
+	case editorMsg:
+		if msg.tmpFile != "" {
+			os.Remove(msg.tmpFile)
+		}
+		if msg.err != nil {
+			m.err = fmt.Errorf("editor: %w", msg.err)
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+// search runs the current query in the background.
+func (m model) search() (tea.Model, tea.Cmd) {
+	m.searchID++
+	query := strings.TrimSpace(m.input.Value())
+	if query == "" {
+		m.results = nil
+		m.err = nil
+		m.updatePreview()
+		return m, nil
+	}
+
+	m.searching = true
+	id, mode, filter := m.searchID, m.modes[m.mode], m.filter
+	s, embedder := m.store, m.embedder
+	return m, func() tea.Msg {
+		var results []store.SearchResult
+		var err error
+		switch mode {
+		case ModeBM25:
+			results, err = s.SearchFTSPrefix(query, maxResults, 0, filter)
+		default:
+			var vec []float32
+			vec, err = embedder.Embed(query, true)
+			if err != nil {
+				break
+			}
+			if mode == ModeVector {
+				results, err = s.SearchVec(vec, maxResults, filter)
+			} else {
+				results, err = s.SearchHybrid(query, vec, maxResults, 0, store.HybridOptions{Filter: filter})
+			}
+		}
+		return resultsMsg{id: id, results: results, err: err}
+	}
+}
+
+// next returns the value following current in values, cycling through ""
+// (no filter) after the last one.
+func next(values []string, current string) string {
+	if current == "" {
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+	for i, v := range values {
+		if v == current && i+1 < len(values) {
+			return values[i+1]
+		}
+	}
+	return ""
+}
+
+func (m model) listWidth() int {
+	return max(m.width*2/5, 20)
+}
+
+// updatePreview renders the selected document and scrolls to its match.
+func (m *model) updatePreview() {
+	if len(m.results) == 0 || m.preview.Width <= 0 {
+		m.preview.SetContent("")
+		return
+	}
+	r := m.results[m.selected]
+
+	if m.renderer == nil {
+		renderer, err := glamour.NewTermRenderer(
+			glamour.WithStandardStyle("dark"),
+			glamour.WithWordWrap(max(m.preview.Width-4, 20)),
+		)
+		if err != nil {
+			m.preview.SetContent(r.Body)
+			return
+		}
+		m.renderer = renderer
+	}
+
+	rendered, err := m.renderer.Render(r.Body)
+	if err != nil {
+		rendered = r.Body
+	}
+	m.preview.SetContent(rendered)
+
+	line := util.MatchLine(r.Body, m.input.Value(), r.Chunk)
+	target := RenderedLine(rendered, m.input.Value(), line, strings.Count(r.Body, "\n")+1)
+	m.preview.SetYOffset(max(target-2, 0))
+}
+
+// openEditor opens the result in $EDITOR at the matching line. Documents
+// without a file on disk are opened from a temporary copy.
+func (m model) openEditor(r store.SearchResult) tea.Cmd {
+	path := ""
+	if m.opts.Resolve != nil {
+		path = m.opts.Resolve(r.Filepath)
+	}
+
+	var tmpFile string
+	if _, err := os.Stat(path); path == "" || err != nil {
+		f, err := os.CreateTemp("", "qmd-*-"+filepath.Base(r.Filepath))
+		if err != nil {
+			return func() tea.Msg { return editorMsg{err: err} }
+		}
+		_, err = f.WriteString(r.Body)
+		f.Close()
+		if err != nil {
+			return func() tea.Msg { return editorMsg{err: err, tmpFile: f.Name()} }
+		}
+		path, tmpFile = f.Name(), f.Name()
+	}
+
+	line := util.MatchLine(r.Body, m.input.Value(), r.Chunk)
+	cmd := EditorCommand(os.Getenv("EDITOR"), path, line)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorMsg{err: err, tmpFile: tmpFile}
+	})
+}
+
+// EditorCommand builds the command opening path at line with editor, using
+// the line syntax of common editors.
+func EditorCommand(editor, path string, line int) *exec.Cmd {
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		args = []string{"vi"}
+	}
+
+	switch filepath.Base(args[0]) {
+	case "code", "codium", "cursor":
+		args = append(args, "-g", fmt.Sprintf("%s:%d", path, line))
+	case "hx", "helix", "zed", "subl":
+		args = append(args, fmt.Sprintf("%s:%d", path, line))
+	default:
+		args = append(args, fmt.Sprintf("+%d", line), path)
+	}
+	return exec.Command(args[0], args[1:]...)
+}
+
+func (m model) View() string {
+	if m.width == 0 {
+		return ""
+	}
+
+	header := promptStyle.Render(fmt.Sprintf("%-7s> ", m.modes[m.mode])) + m.input.View()
+
+	var list strings.Builder
+	listWidth := m.listWidth()
+	height := max(m.height-3, 0)
+	start := max(m.selected-height+1, 0)
+	for i := start; i < len(m.results) && i < start+height; i++ {
+		r := m.results[i]
+		label := truncate(fmt.Sprintf("%s (%.3f)", r.Filepath, r.Score), listWidth-2)
+		if i == m.selected {
+			list.WriteString(selectedStyle.Render("> " + label))
+		} else {
+			list.WriteString(itemStyle.Render("  " + label))
+		}
+		list.WriteString("\n")
+	}
+
+	body := lipgloss.JoinHorizontal(lipgloss.Top,
+		listStyle.Width(listWidth).Height(height).Render(strings.TrimSuffix(list.String(), "\n")),
+		" "+m.preview.View(),
+	)
+
+	return fmt.Sprintf("%s\n%s\n%s", header, body, m.statusLine())
+}
+
+func (m model) statusLine() string {
+	if m.err != nil {
+		return errorStyle.Render(m.err.Error())
+	}
+
+	collection, tag := "all", "all"
+	if m.filter.Collection != "" {
+		collection = m.filter.Collection
+	}
+	if len(m.filter.Tags) > 0 {
+		tag = m.filter.Tags[0]
+	}
+	state := fmt.Sprintf("%d results", len(m.results))
+	if m.searching {
+		state = "searching..."
+	}
+	return statusStyle.Render(fmt.Sprintf(
+		"%s | collection: %s | tag: %s | Tab: mode, Ctrl+F: collection, Ctrl+T: tag, Enter: edit, Esc: quit",
+		state, collection, tag,
+	))
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if width <= 1 || len(r) <= width {
+		return s
+	}
+	return string(r[:width-1]) + "…"
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Link kinds
+const (
+	LinkWiki     = "wiki"     // [[Note]], [[Note|alias]], [[Note#Heading]]
+	LinkMarkdown = "markdown" // [text](../note.md)
+)
+
+// Link is a reference from a document to another note.
+type Link struct {
+	Kind    string
+	Target  string // Note name or relative path, without the heading part
+	Heading string // Heading anchor, if any
+	Alias   string // Display text, if any
+	Line    int    // 1-based line number
+}
+
+var (
+	wikiLinkRegex     = regexp.MustCompile(`!?\[\[([^\]\|#]*)(?:#([^\]\|]*))?(?:\|([^\]]*))?\]\]`)
+	markdownLinkRegex = regexp.MustCompile(`(!?)\[([^\]]*)\]\(<?([^)\s>]+)>?(?:\s+"[^"]*")?\)`)
+	inlineCodeRegex   = regexp.MustCompile("`[^`]*`")
+)
+
+// ExtractLinks returns the links to other notes found in markdown content.
+// Links inside code blocks and inline code, images and external URLs are
+// ignored.
+func ExtractLinks(content string) []Link {
+	var links []Link
+
+	for ml := range MarkdownLines(content) {
+		if ml.Code {
+			continue
+		}
+		line := inlineCodeRegex.ReplaceAllString(ml.Text, "")
+
+		for _, m := range wikiLinkRegex.FindAllStringSubmatch(line, -1) {
+			target := strings.TrimSpace(m[1])
+			if target == "" {
+				// [[#Heading]] points into the same note
+				continue
+			}
+			links = append(links, Link{
+				Kind:    LinkWiki,
+				Target:  target,
+				Heading: strings.TrimSpace(m[2]),
+				Alias:   strings.TrimSpace(m[3]),
+				Line:    ml.Num,
+			})
+		}
+
+		for _, m := range markdownLinkRegex.FindAllStringSubmatch(line, -1) {
+			if m[1] == "!" {
+				continue
+			}
+			target, heading, _ := strings.Cut(m[3], "#")
+			if target == "" || strings.Contains(target, "://") || strings.HasPrefix(target, "mailto:") {
+				continue
+			}
+			if decoded, err := url.PathUnescape(target); err == nil {
+				target = decoded
+			}
+			if ext := path.Ext(target); ext != "" && ext != ".md" {
+				continue
+			}
+			links = append(links, Link{
+				Kind:    LinkMarkdown,
+				Target:  target,
+				Heading: heading,
+				Alias:   strings.TrimSpace(m[2]),
+				Line:    ml.Num,
+			})
+		}
+	}
+	return links
+}
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"fmt"
+	"iter"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	headingRegex = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	fenceRegex   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+)
+
+// MarkdownLine is a line of markdown content, with whether it belongs to a
+// fenced code block.
+type MarkdownLine struct {
+	Text   string // The line, without its newline
+	Num    int    // 1-based line number
+	Offset int    // Byte offset of the start of the line
+
+	// Code is set for the lines of fenced code blocks, fences included.
+	// FenceOpen and FenceClose mark their opening and closing fences.
+	Code       bool
+	FenceOpen  bool
+	FenceClose bool
+}
+
+// MarkdownLines iterates over the lines of markdown content, tracking the
+// fenced code blocks: a fence of 3 or more backticks or tildes, indented
+// by up to 3 spaces, is closed by a line of at least as many of the same
+// character.
+func MarkdownLines(content string) iter.Seq[MarkdownLine] {
+	return func(yield func(MarkdownLine) bool) {
+		fence := ""
+		offset := 0
+		for i, text := range strings.Split(content, "\n") {
+			line := MarkdownLine{Text: text, Num: i + 1, Offset: offset}
+			offset += len(text) + 1
+
+			trimmed := strings.TrimSpace(text)
+			switch {
+			case fence != "":
+				line.Code = true
+				if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+					line.FenceClose = true
+					fence = ""
+				}
+			default:
+				if m := fenceRegex.FindStringSubmatch(text); m != nil {
+					line.Code, line.FenceOpen = true, true
+					fence = m[1]
+				}
+			}
+			if !yield(line) {
+				return
+			}
+		}
+	}
+}
+
+// ParseFrontMatter splits a leading YAML front matter block ("---" fenced)
+// from the content. It returns the parsed fields (nil if absent or invalid)
+// and the remaining body.
+func ParseFrontMatter(content string) (map[string]interface{}, string) {
+	normalized := strings.ReplaceAll(content, "\r\n", "\n")
+	if !strings.HasPrefix(normalized, "---\n") {
+		return nil, content
+	}
+
+	rest := normalized[4:]
+	end := strings.Index(rest, "\n---")
+	if end == -1 {
+		return nil, content
+	}
+
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal([]byte(rest[:end]), &fields); err != nil {
+		return nil, content
+	}
+
+	body := rest[end+4:]
+	if idx := strings.Index(body, "\n"); idx != -1 {
+		body = body[idx+1:]
+	} else {
+		body = ""
+	}
+	return fields, body
+}
+
+// FrontMatterList returns a front matter field as a list of strings.
+// It accepts YAML lists as well as comma or space separated strings.
+func FrontMatterList(fields map[string]interface{}, key string) []string {
+	var out []string
+	switch v := fields[key].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s := strings.TrimSpace(fmt.Sprint(item)); s != "" {
+				out = append(out, s)
+			}
+		}
+	case string:
+		for _, item := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if s := strings.TrimSpace(item); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// ParseHeading parses an ATX heading line ("## Title ##"), returning its
+// level and text.
+func ParseHeading(line string) (level int, text string, ok bool) {
+	m := headingRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return 0, "", false
+	}
+	return len(m[1]), m[2], true
+}
+
+// LeadingHeadings returns the text of the heading lines a chunk starts with.
+// The markdown splitter prepends the heading hierarchy to every chunk, so
+// this is the breadcrumb of the section the chunk belongs to.
+func LeadingHeadings(chunk string) []string {
+	var headings []string
+	for _, line := range strings.Split(chunk, "\n") {
+		_, text, ok := ParseHeading(line)
+		if !ok {
+			break
+		}
+		headings = append(headings, text)
+	}
+	return headings
+}
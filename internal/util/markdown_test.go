@@ -0,0 +1,34 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkdownLines(t *testing.T) {
+	content := "intro\n````go\n```\ncode\n````\n    ```not a fence\n~~~\nx\n~~~~\nend"
+
+	var code []int
+	var opens, closes []int
+	for ml := range util.MarkdownLines(content) {
+		if ml.Code {
+			code = append(code, ml.Num)
+		}
+		if ml.FenceOpen {
+			opens = append(opens, ml.Num)
+		}
+		if ml.FenceClose {
+			closes = append(closes, ml.Num)
+		}
+		if ml.Num == 4 {
+			assert.Equal(t, len("intro\n````go\n```\n"), ml.Offset)
+		}
+	}
+	// A shorter fence does not close a longer one, and 4 spaces of
+	// indentation make an indented line, not a fence
+	assert.Equal(t, []int{2, 3, 4, 5, 7, 8, 9}, code)
+	assert.Equal(t, []int{2, 7}, opens)
+	assert.Equal(t, []int{5, 9}, closes)
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Heading is a markdown heading with the line range of its section.
+type Heading struct {
+	Level   int
+	Text    string
+	Line    int // 1-based line of the heading
+	EndLine int // Last line of the section, up to the next heading of the same or higher level
+}
+
+// ParseHeadings returns the ATX headings of a markdown document, skipping
+// front matter and fenced code blocks. Line numbers refer to the full
+// content, front matter included.
+func ParseHeadings(content string) []Heading {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+
+	start := 0
+	if len(lines) > 0 && lines[0] == "---" {
+		for i := 1; i < len(lines); i++ {
+			if lines[i] == "---" {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	var headings []Heading
+	for ml := range MarkdownLines(strings.Join(lines[start:], "\n")) {
+		if ml.Code {
+			continue
+		}
+		if level, text, ok := ParseHeading(ml.Text); ok {
+			headings = append(headings, Heading{Level: level, Text: text, Line: start + ml.Num})
+		}
+	}
+
+	total := LineCount(content)
+	for i := range headings {
+		headings[i].EndLine = total
+		for _, next := range headings[i+1:] {
+			if next.Level <= headings[i].Level {
+				headings[i].EndLine = next.Line - 1
+				break
+			}
+		}
+	}
+	return headings
+}
+
+// LineRange returns lines start to end (1-based, inclusive) of content.
+// Out of range bounds are clamped, end <= 0 means up to the last line.
+func LineRange(content string, start, end int) string {
+	lines := strings.Split(content, "\n")
+	if start < 1 {
+		start = 1
+	}
+	if end <= 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return ""
+	}
+	return strings.Join(lines[start-1:end], "\n")
+}
+
+// LineCount returns the number of lines of content. A trailing newline does
+// not start a new line.
+func LineCount(content string) int {
+	if content == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(content, "\n"), "\n") + 1
+}
+
+// MatchLine returns the 1-based line of body a result matched: the start of
+// the matching chunk for vector results, else the first line containing the
+// query or, failing that, one of its words.
+func MatchLine(body, query, chunk string) int {
+	if chunk = strings.TrimSpace(chunk); chunk != "" {
+		if i := strings.Index(body, chunk); i >= 0 {
+			return strings.Count(body[:i], "\n") + 1
+		}
+	}
+
+	// Case folding can change the byte length of some runes, so offsets are
+	// taken on body itself rather than on a lowercased copy
+	needles := append([]string{strings.TrimSpace(query)}, strings.Fields(query)...)
+	for _, needle := range needles {
+		if needle == "" {
+			continue
+		}
+		re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(needle))
+		if loc := re.FindStringIndex(body); loc != nil {
+			return strings.Count(body[:loc[0]], "\n") + 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,23 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchLine(t *testing.T) {
+	body := "# Title\n\nIntro text\n\n## Kafka\nConsumer groups rebalance.\n"
+
+	assert.Equal(t, 5, util.MatchLine(body, "kafka", ""))
+	assert.Equal(t, 6, util.MatchLine(body, "groups rebalance", ""))
+	assert.Equal(t, 6, util.MatchLine(body, "missing rebalance", ""))
+	assert.Equal(t, 3, util.MatchLine(body, "kafka", "Intro text"))
+	assert.Equal(t, 1, util.MatchLine(body, "nothing", ""))
+
+	// Runes whose lowercase form has another byte length
+	assert.Equal(t, 2, util.MatchLine("ȺȺȺȺȺȺ\nkafka", "kafka", ""))
+	assert.Equal(t, 3, util.MatchLine("İİİİİİİİ\nİstanbul\nkafka", "KAFKA", ""))
+	assert.Equal(t, 2, util.MatchLine("intro\nⱥȺ notes", "ȺȺ", ""))
+}
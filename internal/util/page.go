@@ -0,0 +1,156 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Page is a window over a document, with what is needed to fetch the next one.
+type Page struct {
+	Text       string
+	StartLine  int // 1-based line of the first returned line
+	EndLine    int // Last returned line
+	Offset     int // Byte offset of the first returned byte
+	TotalBytes int
+	TotalLines int
+	NextCursor string // Empty when the end of the document is reached
+}
+
+// PageRequest describes the window to return. Lines take precedence over
+// bytes; MaxBytes caps the size of either kind of window (0 = no cap).
+type PageRequest struct {
+	StartLine int
+	EndLine   int // 0 = up to the end
+	Offset    int
+	MaxBytes  int
+}
+
+// Cursor formats: "line:<start>:<count>" continues a line window of count
+// lines (0 = to the end), "offset:<n>" continues a byte window.
+
+// ParseCursor turns a continuation cursor back into a page request.
+func ParseCursor(cursor string, maxBytes int) (PageRequest, error) {
+	req := PageRequest{MaxBytes: maxBytes}
+	parts := strings.Split(cursor, ":")
+	var err error
+	switch {
+	case len(parts) == 3 && parts[0] == "line":
+		var count int
+		if req.StartLine, err = strconv.Atoi(parts[1]); err == nil {
+			count, err = strconv.Atoi(parts[2])
+		}
+		if err == nil && count > 0 {
+			req.EndLine = req.StartLine + count - 1
+		}
+	case len(parts) == 2 && parts[0] == "offset":
+		req.Offset, err = strconv.Atoi(parts[1])
+	default:
+		err = fmt.Errorf("unknown format")
+	}
+	if err != nil {
+		return req, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return req, nil
+}
+
+// Paginate returns the requested window of content. Windows capped by
+// MaxBytes end on a line boundary when possible.
+func Paginate(content string, req PageRequest) Page {
+	page := Page{TotalBytes: len(content), TotalLines: LineCount(content)}
+
+	var start, end int
+	if req.StartLine > 0 {
+		start = lineOffset(content, req.StartLine)
+		end = len(content)
+		if req.EndLine > 0 {
+			// An end line before the start line gives an empty window
+			end = max(lineOffset(content, req.EndLine+1), start)
+		}
+	} else {
+		start = min(max(req.Offset, 0), len(content))
+		// Never start in the middle of a UTF-8 sequence
+		for start < len(content) && !utf8.RuneStart(content[start]) {
+			start++
+		}
+		end = len(content)
+	}
+
+	capped := false
+	if req.MaxBytes > 0 && end-start > req.MaxBytes {
+		cut := start + req.MaxBytes
+		if nl := strings.LastIndexByte(content[start:cut], '\n'); nl != -1 {
+			cut = start + nl + 1
+		} else {
+			for cut > start && !utf8.RuneStart(content[cut]) {
+				cut--
+			}
+			if cut == start {
+				// Always make progress, even if MaxBytes is smaller than a rune
+				_, size := utf8.DecodeRuneInString(content[start:])
+				cut = start + size
+			}
+		}
+		end = cut
+		capped = true
+	}
+
+	page.Text = content[start:end]
+	page.Offset = start
+	page.StartLine = strings.Count(content[:start], "\n") + 1
+	page.EndLine = page.StartLine + LineCount(page.Text) - 1
+	if page.Text == "" {
+		page.EndLine = page.StartLine - 1
+	}
+
+	switch {
+	case end >= len(content), end == start:
+	case req.StartLine > 0 && !capped:
+		count := req.EndLine - req.StartLine + 1
+		page.NextCursor = fmt.Sprintf("line:%d:%d", req.EndLine+1, count)
+	case req.StartLine > 0 && content[end-1] == '\n':
+		// Resume the same line window after the lines that did not fit
+		count := 0
+		if req.EndLine > 0 {
+			count = req.EndLine - page.EndLine
+		}
+		page.NextCursor = fmt.Sprintf("line:%d:%d", page.EndLine+1, count)
+	default:
+		// Byte windows, and lines too long to fit in MaxBytes
+		page.NextCursor = fmt.Sprintf("offset:%d", end)
+	}
+	return page
+}
+
+// NumberLines prefixes each line of text with its line number, starting
+// at first.
+func NumberLines(text string, first int) string {
+	if text == "" {
+		return ""
+	}
+	trailing := strings.HasSuffix(text, "\n")
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	var sb strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&sb, "%6d\t%s", first+i, line)
+		if i < len(lines)-1 || trailing {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// lineOffset returns the byte offset of the start of a 1-based line, or the
+// content length past the last line.
+func lineOffset(content string, line int) int {
+	offset := 0
+	for i := 1; i < line; i++ {
+		nl := strings.IndexByte(content[offset:], '\n')
+		if nl == -1 {
+			return len(content)
+		}
+		offset += nl + 1
+	}
+	return offset
+}
@@ -0,0 +1,55 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/akhenakh/qmd/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaginate(t *testing.T) {
+	content := "one\ntwo\nthree\nfour\nfive\n"
+
+	page := util.Paginate(content, util.PageRequest{StartLine: 2, EndLine: 3})
+	assert.Equal(t, "two\nthree\n", page.Text)
+	assert.Equal(t, 2, page.StartLine)
+	assert.Equal(t, 3, page.EndLine)
+	assert.Equal(t, 5, page.TotalLines)
+	assert.Equal(t, len(content), page.TotalBytes)
+	assert.Equal(t, "line:4:2", page.NextCursor)
+
+	req, err := util.ParseCursor(page.NextCursor, 0)
+	require.NoError(t, err)
+	page = util.Paginate(content, req)
+	assert.Equal(t, "four\nfive\n", page.Text)
+	assert.Empty(t, page.NextCursor)
+
+	// Byte pages end on a line boundary
+	var pages []string
+	req = util.PageRequest{MaxBytes: 10}
+	for {
+		page = util.Paginate(content, req)
+		pages = append(pages, page.Text)
+		if page.NextCursor == "" {
+			break
+		}
+		req, err = util.ParseCursor(page.NextCursor, 10)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"one\ntwo\n", "three\n", "four\nfive\n"}, pages)
+
+	// A line longer than max_bytes is split without breaking runes
+	page = util.Paginate("héllo\n", util.PageRequest{MaxBytes: 2})
+	assert.Equal(t, "h", page.Text)
+	assert.Equal(t, "offset:1", page.NextCursor)
+
+	// An inverted line range is empty
+	page = util.Paginate(content, util.PageRequest{StartLine: 5, EndLine: 3})
+	assert.Equal(t, "", page.Text)
+	assert.Equal(t, 5, page.StartLine)
+	assert.Equal(t, 4, page.EndLine)
+	assert.Empty(t, page.NextCursor)
+
+	assert.Equal(t, "     4\tfour\n     5\tfive\n", util.NumberLines("four\nfive\n", 4))
+}
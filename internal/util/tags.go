@@ -0,0 +1,61 @@
+package util
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	inlineTagRegex = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_\-/]+)`)
+	tagLetterRegex = regexp.MustCompile(`\p{L}`)
+)
+
+// ExtractTags returns the normalized tags of a markdown document: the
+// front matter "tags" (or "tag") field and inline #tags outside of code.
+// Nested tags keep their hierarchy ("#area/sub" gives "area/sub").
+func ExtractTags(content string) []string {
+	fields, body := ParseFrontMatter(content)
+
+	seen := make(map[string]bool)
+	var tags []string
+	add := func(raw string) {
+		if tag := NormalizeTag(raw); tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, t := range append(FrontMatterList(fields, "tags"), FrontMatterList(fields, "tag")...) {
+		add(t)
+	}
+
+	for ml := range MarkdownLines(body) {
+		if ml.Code {
+			continue
+		}
+		line := inlineCodeRegex.ReplaceAllString(ml.Text, "")
+		for _, m := range inlineTagRegex.FindAllStringSubmatch(line, -1) {
+			// "#123" is an issue reference, not a tag
+			if tagLetterRegex.MatchString(m[1]) {
+				add(m[1])
+			}
+		}
+	}
+
+	sort.Strings(tags)
+	return tags
+}
+
+// NormalizeTag lowercases a tag and strips the leading '#' and empty
+// hierarchy levels, so "#Area//Sub/" becomes "area/sub".
+func NormalizeTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#")))
+	var parts []string
+	for _, p := range strings.Split(tag, "/") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -13,6 +14,21 @@ func HashContent(content string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// SplitDocPath splits a "collection/path/to/file.md" document path.
+func SplitDocPath(docPath string) (collection, path string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(docPath, "qmd://"), "/", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid path %q, expected 'collection/path'", docPath)
+	}
+	return parts[0], parts[1], nil
+}
+
+// HashChunk returns the cache key of an embedded chunk. The model id is part
+// of the key so vectors from different models never get mixed.
+func HashChunk(modelID, text string) string {
+	return HashContent(modelID + "\x00" + text)
+}
+
 func ExtractTitle(content, filename string) string {
 	// Look for first H1
 	re := regexp.MustCompile(`(?m)^#\s+(.+)$`)
@@ -34,14 +50,21 @@ func ExtractTitle(content, filename string) string {
 // IsExcluded checks if a given path matches any of the glob patterns.
 // It returns true if excluded, and the matching pattern.
 func IsExcluded(path string, excludePatterns []string) (bool, string) {
-	if len(excludePatterns) == 0 {
+	return MatchGlobs(path, excludePatterns)
+}
+
+// MatchGlobs checks if a path matches any of the glob patterns, with git
+// like semantics: a pattern without slash matches the base name anywhere in
+// the tree. It returns the first matching pattern.
+func MatchGlobs(path string, patterns []string) (bool, string) {
+	if len(patterns) == 0 {
 		return false, ""
 	}
 	// Use ToSlash for consistent matching across OSes
 	pathToCheck := filepath.ToSlash(path)
 	baseName := filepath.Base(pathToCheck)
 
-	for _, pattern := range excludePatterns {
+	for _, pattern := range patterns {
 		// Clean the pattern
 		pattern = strings.TrimSpace(pattern)
 		if pattern == "" {
@@ -3,21 +3,28 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/akhenakh/qmd/internal/chat"
+	"github.com/akhenakh/qmd/internal/chunker"
 	"github.com/akhenakh/qmd/internal/config"
 	"github.com/akhenakh/qmd/internal/ingest"
 	"github.com/akhenakh/qmd/internal/llm"
 	"github.com/akhenakh/qmd/internal/mcpserver"
 	"github.com/akhenakh/qmd/internal/store"
+	"github.com/akhenakh/qmd/internal/tui"
 	"github.com/akhenakh/qmd/internal/util"
 
 	"github.com/spf13/cobra"
-	"github.com/tmc/langchaingo/textsplitter"
+	"golang.org/x/term"
 )
 
 var (
@@ -31,14 +38,54 @@ var (
 	localModelPath string
 	localLibPath   string
 
+	contextualChunks bool
+	docVectors       string
+	coarseDocs       int
+
+	brokenOnly  bool
+	sectionPath string
+	lineRange   string
+	rawOutput   bool
+	lineNumbers bool
+	lsGlobs     []string
+
+	outputFormat string
+	filesOnly    bool
+	resultLimit  int
+	resultOffset int
+	graphBoost   bool
+
+	explainRanking bool
+	minBM25        float64
+	minSimilarity  float64
+	minScore       float64
+
+	similarKeywords bool
+	collectionName  string
+	filterTags      []string
+
 	contextLines int
 	findAll      bool
 
 	excludePatterns []string
+	chunkStrategy   string
+
+	// TUI flags
+	tuiMode string
 
 	// Chat flags
-	chatURL   string
-	chatModel string
+	chatProvider    string
+	chatURL         string
+	chatModel       string
+	chatContextSize int
+	chatTemperature float64
+	chatResume      int64
+	chatListLimit   int
+	exportFormat    string
+	askTimeout      time.Duration
+	chatMCPServers  []string
+	mcpEnv          []string
+	mcpHeaders      []string
 
 	// Debug flag
 	debugMode bool
@@ -67,62 +114,6 @@ func getEmbedder() (llm.Embedder, error) {
 	return llm.NewHTTPClient(globalConfig.OllamaURL, globalConfig.ModelName, globalConfig.EmbedDimensions), nil
 }
 
-func generateEmbeddings() {
-	embedder, err := getEmbedder()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer embedder.Close()
-
-	// Update variable type based on Store change
-	pending, err := globalStore.GetPendingEmbeddings()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	if len(pending) == 0 {
-		fmt.Println("No pending embeddings.")
-		return
-	}
-
-	fmt.Printf("Generating embeddings for %d documents (Dim: %d)...\n", len(pending), globalConfig.EmbedDimensions)
-
-	splitter := textsplitter.NewMarkdownTextSplitter(
-		textsplitter.WithChunkSize(globalConfig.ChunkSize),
-		textsplitter.WithChunkOverlap(globalConfig.ChunkOverlap),
-		textsplitter.WithHeadingHierarchy(true),
-	)
-
-	for hash, doc := range pending {
-		contentToSplit := doc.Body
-
-		// Ensure the document starts with the Title as H1.
-		titleHeader := fmt.Sprintf("# %s", doc.Title)
-		if !strings.Contains(doc.Body, titleHeader) {
-			contentToSplit = fmt.Sprintf("%s\n\n%s", titleHeader, doc.Body)
-		}
-
-		chunks, err := splitter.SplitText(contentToSplit)
-		if err != nil {
-			log.Printf("Error splitting: %v", err)
-			continue
-		}
-
-		for i, chunk := range chunks {
-			vec, err := embedder.Embed(chunk, false)
-			if err != nil {
-				log.Printf("Error embedding: %v", err)
-				continue
-			}
-			if err := globalStore.SaveEmbedding(hash, i, vec); err != nil {
-				log.Fatal(err)
-			}
-		}
-		fmt.Print(".")
-	}
-	fmt.Println("\nDone.")
-}
-
 func main() {
 	var rootCmd = &cobra.Command{
 		Use: "qmd",
@@ -174,6 +165,22 @@ func main() {
 		Use:   "info",
 		Short: "Show index information and configuration",
 		Run: func(cmd *cobra.Command, args []string) {
+			checkFormat()
+			if outputFormat == formatCSV || outputFormat == formatTSV {
+				log.Fatalf("format %q is not supported by info, use json or jsonl", outputFormat)
+			}
+			if outputFormat != formatText {
+				stats, err := globalStore.GetStats()
+				if err != nil {
+					log.Fatal(err)
+				}
+				info := infoJSON{Database: globalStore.DBPath, Config: globalConfig.Redacted(), Stats: stats}
+				if err := writeJSON(outputFormat, info); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+
 			fmt.Println("=== Configuration ===")
 			fmt.Printf("Database Path:    %s\n", globalStore.DBPath)
 
@@ -182,6 +189,11 @@ func main() {
 				fmt.Printf("Dimensions:       %d\n", globalConfig.EmbedDimensions)
 				fmt.Printf("Chunk Size:       %d\n", globalConfig.ChunkSize)
 				fmt.Printf("Chunk Overlap:    %d\n", globalConfig.ChunkOverlap)
+				fmt.Printf("Chunker:          %s\n", globalConfig.Chunker)
+				fmt.Printf("Contextual:       %v\n", globalConfig.ContextualChunks)
+				if globalConfig.DocVectors != "" {
+					fmt.Printf("Doc Vectors:      %s\n", globalConfig.DocVectors)
+				}
 
 				if globalConfig.UseLocal {
 					fmt.Println("Mode:             Local (llama.cpp)")
@@ -194,6 +206,18 @@ func main() {
 			} else {
 				fmt.Println("Embedding:        Not configured (run 'qmd embed' to setup)")
 			}
+			fmt.Printf("Chat Provider:    %s\n", globalConfig.ChatProvider)
+			fmt.Printf("Chat Model:       %s\n", globalConfig.ChatModel)
+			if globalConfig.ChatURL != "" {
+				fmt.Printf("Chat URL:         %s\n", globalConfig.ChatURL)
+			}
+			if len(globalConfig.ChatMCPServers) > 0 {
+				var names []string
+				for _, srv := range globalConfig.ChatMCPServers {
+					names = append(names, srv.Name)
+				}
+				fmt.Printf("Chat MCP Servers: %s\n", strings.Join(names, ", "))
+			}
 			fmt.Println()
 
 			fmt.Println("=== Collections ===")
@@ -205,6 +229,9 @@ func main() {
 					if len(col.Exclude) > 0 {
 						fmt.Printf("  Exclude: %v\n", col.Exclude)
 					}
+					if col.Chunker != "" {
+						fmt.Printf("  Chunker: %s\n", col.Chunker)
+					}
 				}
 			}
 			fmt.Println()
@@ -218,6 +245,9 @@ func main() {
 			fmt.Println("=== Index Stats ===")
 			fmt.Printf("Total Documents:  %d\n", stats.TotalDocuments)
 			fmt.Printf("Vector Count:     %d\n", stats.Embeddings)
+			if stats.DocEmbeddings > 0 {
+				fmt.Printf("Doc Vectors:      %d\n", stats.DocEmbeddings)
+			}
 
 			if globalConfig.EmbeddingsConfigured && stats.Embeddings > 0 {
 				fmt.Printf("Embeddings:       Present\n")
@@ -229,12 +259,21 @@ func main() {
 		},
 	}
 
+	cmdInfo.Flags().StringVar(&outputFormat, "format", formatText, "Output format: text, json or jsonl")
+
 	var cmdAdd = &cobra.Command{
 		Use:   "add [path...]",
 		Short: "Add folders or compressed archives (.zst)",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			var added []config.Collection
+			changed := false
+
+			if chunkStrategy != "" {
+				if _, err := chunker.New(chunkStrategy, globalConfig.ChunkSize, globalConfig.ChunkOverlap); err != nil {
+					log.Fatal(err)
+				}
+			}
 
 			for _, arg := range args {
 				absPath, err := filepath.Abs(arg)
@@ -263,9 +302,18 @@ func main() {
 
 				// Check if already exists in config
 				exists := false
-				for _, c := range globalConfig.Collections {
+				for i, c := range globalConfig.Collections {
 					if c.Path == absPath {
 						exists = true
+						// Re-adding with --chunker switches the collection's strategy
+						if cmd.Flags().Changed("chunker") && c.Chunker != chunkStrategy {
+							globalConfig.Collections[i].Chunker = chunkStrategy
+							if err := globalStore.ResetCollectionEmbeddings(c.Name); err != nil {
+								log.Fatal(err)
+							}
+							changed = true
+							fmt.Printf("Collection '%s' now uses the '%s' chunker, its embeddings will be regenerated\n", c.Name, chunkStrategy)
+						}
 						break
 					}
 				}
@@ -276,6 +324,7 @@ func main() {
 						Path:    absPath,
 						Pattern: "**/*.md", // Default pattern, ignored for archives
 						Exclude: excludePatterns,
+						Chunker: chunkStrategy,
 					}
 					globalConfig.Collections = append(globalConfig.Collections, newCol)
 					added = append(added, newCol)
@@ -285,7 +334,7 @@ func main() {
 				}
 			}
 
-			if len(added) > 0 {
+			if len(added) > 0 || changed {
 				// Save to DB
 				if err := globalStore.SaveConfig(globalConfig); err != nil {
 					log.Fatal(err)
@@ -295,10 +344,12 @@ func main() {
 				for _, col := range added {
 					reindex(col)
 				}
+				resolveLinks()
 			}
 		},
 	}
 	cmdAdd.Flags().StringSliceVarP(&excludePatterns, "exclude", "x", nil, "Glob patterns to exclude (e.g. node_modules, *.tmp)")
+	cmdAdd.Flags().StringVar(&chunkStrategy, "chunker", "", "Chunking strategy for this collection: "+strings.Join(chunker.Strategies, ", "))
 
 	var cmdUpdate = &cobra.Command{
 		Use:   "update",
@@ -307,6 +358,7 @@ func main() {
 			for _, col := range globalConfig.Collections {
 				reindex(col)
 			}
+			resolveLinks()
 
 			// Only update embeddings if configured
 			if globalConfig.EmbeddingsConfigured {
@@ -319,6 +371,11 @@ func main() {
 		Use:   "embed",
 		Short: "Generate missing embeddings (and configure model settings)",
 		Run: func(cmd *cobra.Command, args []string) {
+			previousModel := embeddingModelID()
+			previousContextual := globalConfig.ContextualChunks
+			previousChunker := globalConfig.Chunker
+			previousDocVectors := globalConfig.DocVectors
+
 			// Update config from flags if provided
 			if cmd.Flags().Changed("url") {
 				globalConfig.OllamaURL = ollamaURL
@@ -338,6 +395,25 @@ func main() {
 			if cmd.Flags().Changed("lib-path") {
 				globalConfig.LocalLibPath = localLibPath
 			}
+			if cmd.Flags().Changed("contextual") {
+				globalConfig.ContextualChunks = contextualChunks
+			}
+			if cmd.Flags().Changed("chunker") {
+				if _, err := chunker.New(chunkStrategy, globalConfig.ChunkSize, globalConfig.ChunkOverlap); err != nil {
+					log.Fatal(err)
+				}
+				globalConfig.Chunker = chunkStrategy
+			}
+			if cmd.Flags().Changed("doc-vectors") {
+				switch docVectors {
+				case "off", "":
+					globalConfig.DocVectors = ""
+				case config.DocVectorsMean, config.DocVectorsSummary:
+					globalConfig.DocVectors = docVectors
+				default:
+					log.Fatalf("invalid --doc-vectors %q (valid: mean, summary, off)", docVectors)
+				}
+			}
 
 			// If local mode is active and no explicit model name provided,
 			// use the filename from the path as the model name.
@@ -345,6 +421,25 @@ func main() {
 				globalConfig.ModelName = filepath.Base(globalConfig.LocalModelPath)
 			}
 
+			// Cached vectors of another model cannot be reused
+			if globalConfig.EmbeddingsConfigured && previousModel != embeddingModelID() {
+				fmt.Printf("Embedding model changed (%s -> %s), re-embedding all documents.\n", previousModel, embeddingModelID())
+				if err := globalStore.ResetEmbeddings(); err != nil {
+					log.Fatal(err)
+				}
+			} else if globalConfig.EmbeddingsConfigured && (previousContextual != globalConfig.ContextualChunks || previousChunker != globalConfig.Chunker) {
+				fmt.Println("Chunking settings changed, re-embedding all documents.")
+				if err := globalStore.ResetEmbeddings(); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			if previousDocVectors != globalConfig.DocVectors {
+				if err := globalStore.ResetDocEmbeddings(); err != nil {
+					log.Fatal(err)
+				}
+			}
+
 			// Mark as configured
 			globalConfig.EmbeddingsConfigured = true
 
@@ -369,18 +464,28 @@ func main() {
 	cmdEmbed.Flags().BoolVar(&localMode, "local", false, "Use local llama.cpp inference")
 	cmdEmbed.Flags().StringVar(&localModelPath, "model-path", "", "Path to GGUF model file")
 	cmdEmbed.Flags().StringVar(&localLibPath, "lib-path", "", "Path to llama.cpp shared library")
+	cmdEmbed.Flags().StringVar(&chunkStrategy, "chunker", "", "Default chunking strategy: "+strings.Join(chunker.Strategies, ", "))
+	cmdEmbed.Flags().StringVar(&docVectors, "doc-vectors", "", "Document level vectors: mean (of chunk vectors), summary (embedded title + summary) or off")
+	cmdEmbed.Flags().BoolVar(&contextualChunks, "contextual", false, "Prefix chunks with document title, collection, tags and section before embedding")
 
 	var cmdSearch = &cobra.Command{
 		Use:   "search [query]",
 		Short: "Full text search",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			results, err := globalStore.SearchFTS(args[0], 10, contextLines, findAll)
+			checkFormat()
+			checkPaging()
+			results, err := globalStore.SearchFTS(args[0], resultOffset+resultLimit, contextLines, findAll, searchFilter())
 			if err != nil {
 				log.Fatal(err)
 			}
+			results = store.PageResults(results, resultOffset, resultLimit)
+			if emitResults(results, func(r store.SearchResult) string { return r.Snippet }) {
+				return
+			}
+			defer printPageFooter(len(results), func() (int, error) { return globalStore.CountFTS(args[0], searchFilter()) })
 			for _, r := range results {
-				fmt.Printf("\033[1;36m[%s] %s\033[0m\n", r.Filepath, r.Title)
+				fmt.Println(highlight(fmt.Sprintf("[%s] %s", r.Filepath, r.Title)))
 				if len(r.Matches) > 0 {
 					for _, match := range r.Matches {
 						fmt.Printf("%s\n\n", match)
@@ -393,12 +498,18 @@ func main() {
 	}
 	cmdSearch.Flags().IntVarP(&contextLines, "context", "C", 0, "Context lines")
 	cmdSearch.Flags().BoolVarP(&findAll, "all", "a", false, "Show all matches")
+	cmdSearch.Flags().StringVarP(&collectionName, "collection", "c", "", "Only return documents of this collection")
+	cmdSearch.Flags().StringSliceVarP(&filterTags, "tag", "t", nil, "Only return documents with this tag, nested tags included (repeatable)")
+	addOutputFlags(cmdSearch)
+	addPagingFlags(cmdSearch)
 
 	var cmdVSearch = &cobra.Command{
 		Use:   "vsearch [query]",
 		Short: "Vector semantic search",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			checkFormat()
+			checkPaging()
 			if !globalConfig.EmbeddingsConfigured {
 				log.Fatal("Embeddings not configured. Run 'qmd embed' first.")
 			}
@@ -413,45 +524,310 @@ func main() {
 				log.Fatal(err)
 			}
 
-			results, err := globalStore.SearchVec(qVec, 10)
+			var results []store.SearchResult
+			if coarseDocs > 0 {
+				if globalConfig.DocVectors == "" {
+					log.Fatal("Document vectors not enabled. Run 'qmd embed --doc-vectors mean' first.")
+				}
+				results, err = globalStore.SearchVecCoarse(qVec, coarseDocs, resultOffset+resultLimit, searchFilter())
+			} else {
+				results, err = globalStore.SearchVec(qVec, resultOffset+resultLimit, searchFilter())
+			}
 			if err != nil {
 				log.Fatal(err)
 			}
+			results = store.PageResults(results, resultOffset, resultLimit)
 
-			// Initialize splitter if context is requested to reconstruct chunks
-			var splitter textsplitter.TextSplitter
-			if contextLines > 0 {
-				splitter = textsplitter.NewMarkdownTextSplitter(
-					textsplitter.WithChunkSize(globalConfig.ChunkSize),
-					textsplitter.WithChunkOverlap(globalConfig.ChunkOverlap),
-					textsplitter.WithHeadingHierarchy(true),
-				)
+			matchedChunk := func(r store.SearchResult) string {
+				chunk, _ := chunker.MatchedChunk(globalConfig, r)
+				return chunk
 			}
-
+			if emitResults(results, matchedChunk) {
+				return
+			}
+			defer printPageFooter(len(results), func() (int, error) { return globalStore.CountVec(searchFilter()) })
 			for _, r := range results {
-				fmt.Printf("[%.4f] \033[1;36m%s\033[0m - %s\n", r.Score, r.Filepath, r.Title)
+				fmt.Printf("[%.4f] %s - %s\n", r.Score, highlight(r.Filepath), r.Title)
 
 				if contextLines > 0 {
-					// We need to re-split the document to find the specific chunk that matched
-					contentToSplit := r.Body
-					// Ensure header logic matches generateEmbeddings
-					titleHeader := fmt.Sprintf("# %s", r.Title)
-					if !strings.Contains(r.Body, titleHeader) {
-						contentToSplit = fmt.Sprintf("%s\n\n%s", titleHeader, r.Body)
-					}
-
-					chunks, err := splitter.SplitText(contentToSplit)
-					if err == nil && r.Seq < len(chunks) {
-						fmt.Printf("   %s\n\n", strings.ReplaceAll(chunks[r.Seq], "\n", " "))
+					if chunk := matchedChunk(r); chunk != "" {
+						fmt.Printf("   %s\n\n", strings.ReplaceAll(chunk, "\n", " "))
 					} else {
 						// Fallback if splitting fails or index out of bounds
-						fmt.Printf("   (Context unavailable: chunk %d/%d)\n\n", r.Seq, len(chunks))
+						fmt.Printf("   (Context unavailable: chunk %d)\n\n", r.Seq)
 					}
 				}
 			}
 		},
 	}
 	cmdVSearch.Flags().IntVarP(&contextLines, "context", "C", 0, "Show the matching chunk content")
+	cmdVSearch.Flags().IntVar(&coarseDocs, "coarse", 0, "Search chunks of the N closest documents only (requires document vectors)")
+	cmdVSearch.Flags().StringVarP(&collectionName, "collection", "c", "", "Only return documents of this collection")
+	cmdVSearch.Flags().StringSliceVarP(&filterTags, "tag", "t", nil, "Only return documents with this tag, nested tags included (repeatable)")
+	addOutputFlags(cmdVSearch)
+	addPagingFlags(cmdVSearch)
+
+	var cmdSimilar = &cobra.Command{
+		Use:   "similar [collection/path]",
+		Short: "Find documents similar to a document",
+		Long:  "Finds the documents nearest to the given one using its stored vectors, optionally fused with a BM25 search on its most frequent terms.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if !globalConfig.EmbeddingsConfigured {
+				log.Fatal("Embeddings not configured. Run 'qmd embed' first.")
+			}
+			checkFormat()
+			checkPaging()
+			collection, path, err := util.SplitDocPath(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			results, err := globalStore.SimilarDocuments(collection, path, resultOffset+resultLimit, similarKeywords, searchFilter())
+			if err != nil {
+				log.Fatal(err)
+			}
+			results = store.PageResults(results, resultOffset, resultLimit)
+
+			if emitResults(results, func(r store.SearchResult) string { return r.Snippet }) {
+				return
+			}
+			defer printPageFooter(len(results), func() (int, error) { return globalStore.CountSimilar(searchFilter()) })
+			for _, r := range results {
+				fmt.Printf("[%.4f] %s - %s\n", r.Score, highlight(r.Filepath), r.Title)
+			}
+		},
+	}
+	addPagingFlags(cmdSimilar)
+	cmdSimilar.Flags().BoolVarP(&similarKeywords, "keywords", "k", false, "Fuse with a BM25 search on the document's most frequent terms")
+	cmdSimilar.Flags().StringVarP(&collectionName, "collection", "c", "", "Only return documents of this collection")
+	cmdSimilar.Flags().StringSliceVarP(&filterTags, "tag", "t", nil, "Only return documents with this tag, nested tags included (repeatable)")
+	addOutputFlags(cmdSimilar)
+
+	var cmdTags = &cobra.Command{
+		Use:   "tags",
+		Short: "List tags with their document counts",
+		Long:  "Lists the tags found in front matter 'tags:' fields and inline #tags. Nested tags (#area/sub) also count for their parents.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			tags, err := globalStore.ListTags(collectionName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(tags) == 0 {
+				fmt.Println("No tags found.")
+				return
+			}
+			for _, t := range tags {
+				depth := strings.Count(t.Tag, "/")
+				fmt.Printf("%5d  %s#%s\n", t.Count, strings.Repeat("  ", depth), t.Tag)
+			}
+		},
+	}
+	cmdTags.Flags().StringVarP(&collectionName, "collection", "c", "", "Only count documents of this collection")
+
+	var cmdLinks = &cobra.Command{
+		Use:   "links [collection/path]",
+		Short: "Show the links of a document, or broken links with --broken",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if brokenOnly {
+				links, err := globalStore.GetBrokenLinks(collectionName)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if len(links) == 0 {
+					fmt.Println("No broken links.")
+					return
+				}
+				for _, l := range links {
+					fmt.Printf("%s -> %s\n", highlight(fmt.Sprintf("%s:%d", l.Source, l.Line)), formatLinkTarget(l))
+				}
+				return
+			}
+
+			if len(args) == 0 {
+				log.Fatal("Document path required (or use --broken)")
+			}
+			collection, path, err := util.SplitDocPath(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			links, err := globalStore.GetLinks(collection, path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(links) == 0 {
+				fmt.Println("No links.")
+				return
+			}
+			for _, l := range links {
+				fmt.Printf("%4d  %s\n", l.Line, formatLinkTarget(l))
+			}
+		},
+	}
+	cmdLinks.Flags().BoolVar(&brokenOnly, "broken", false, "List links that do not resolve to an indexed document")
+	cmdLinks.Flags().StringVarP(&collectionName, "collection", "c", "", "Restrict --broken to a collection")
+
+	var cmdBacklinks = &cobra.Command{
+		Use:   "backlinks [collection/path]",
+		Short: "Show the documents linking to a document",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			collection, path, err := util.SplitDocPath(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			links, err := globalStore.GetBacklinks(collection, path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(links) == 0 {
+				fmt.Println("No backlinks.")
+				return
+			}
+			for _, l := range links {
+				fmt.Printf("%s  %s\n", highlight(fmt.Sprintf("%s:%d", l.Source, l.Line)), formatLinkTarget(l))
+			}
+		},
+	}
+
+	var cmdOutline = &cobra.Command{
+		Use:   "outline [collection/path]",
+		Short: "Show the heading tree of a document with line numbers",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			collection, path, err := util.SplitDocPath(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			sections, err := globalStore.GetOutline(collection, path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(sections) == 0 {
+				fmt.Println("No headings.")
+				return
+			}
+			for _, sec := range sections {
+				fmt.Printf("%5d-%-5d %s%s %s\n", sec.Line, sec.EndLine, strings.Repeat("  ", len(sec.Path)-1), strings.Repeat("#", sec.Level), sec.Heading)
+			}
+		},
+	}
+
+	var cmdGet = &cobra.Command{
+		Use:   "get [collection/path]",
+		Short: "Print a document, one of its sections or a line range",
+		Long:  "Prints a document, or part of it. On a terminal the markdown is rendered with glamour, unless --raw or --line-numbers is set.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			collection, path, err := util.SplitDocPath(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			if sectionPath != "" && lineRange != "" {
+				log.Fatal("--section and --lines are mutually exclusive")
+			}
+			content, err := globalStore.GetDocument(collection, path)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			start, end := 1, 0
+			if sectionPath != "" {
+				sec, err := globalStore.FindSection(collection, path, sectionPath)
+				if err != nil {
+					log.Fatal(err)
+				}
+				start, end = sec.Line, sec.EndLine
+			} else if lineRange != "" {
+				if start, end, err = parseLineRange(lineRange); err != nil {
+					log.Fatal(err)
+				}
+			}
+			content = util.Paginate(content, util.PageRequest{StartLine: start, EndLine: end}).Text
+
+			switch {
+			case lineNumbers:
+				content = util.NumberLines(content, start)
+			case !rawOutput && isTerminal():
+				rendered, err := renderMarkdown(content)
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Print(rendered)
+				return
+			}
+			fmt.Println(strings.TrimSuffix(content, "\n"))
+		},
+	}
+	cmdGet.Flags().StringVarP(&sectionPath, "section", "s", "", "Only print this section, by heading path (e.g. \"Deploy/Rollback\")")
+	cmdGet.Flags().StringVar(&lineRange, "lines", "", "Only print these lines: START-END, START- or LINE")
+	cmdGet.Flags().BoolVar(&rawOutput, "raw", false, "Print the markdown source instead of rendering it")
+	cmdGet.Flags().BoolVar(&lineNumbers, "line-numbers", false, "Prefix lines with their number (implies --raw)")
+
+	var cmdLs = &cobra.Command{
+		Use:   "ls [collection[/prefix]]",
+		Short: "List indexed documents",
+		Long:  "Lists indexed documents with their title, size, last indexing time and embedding status. A path prefix restricts the listing to a folder, --glob filters paths with git-like patterns.",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			checkFormat()
+			var collection, prefix string
+			if len(args) == 1 {
+				collection, prefix, _ = strings.Cut(strings.TrimPrefix(args[0], "qmd://"), "/")
+			}
+			docs, err := globalStore.ListDocuments(collection, prefix)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			var matched []store.DocumentInfo
+			for _, d := range docs {
+				if len(lsGlobs) > 0 {
+					if ok, _ := util.MatchGlobs(d.Path, lsGlobs); !ok {
+						continue
+					}
+				}
+				matched = append(matched, d)
+			}
+
+			switch {
+			case filesOnly:
+				for _, d := range matched {
+					fmt.Println(diskPath(d.Filepath()))
+				}
+			case outputFormat != formatText:
+				header := []string{"filepath", "title", "size", "modified_at", "embedded"}
+				err := writeRecords(outputFormat, matched, header, func(d store.DocumentInfo) []string {
+					return []string{d.Filepath(), d.Title, strconv.Itoa(d.Size), d.ModifiedAt.Format(time.RFC3339), strconv.FormatBool(d.Embedded)}
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+			case len(matched) == 0:
+				fmt.Println("No documents found.")
+			default:
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				for _, d := range matched {
+					status := "-"
+					if globalConfig.EmbeddingsConfigured {
+						status = "pending"
+						if d.Embedded {
+							status = "embedded"
+						}
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.Filepath(), d.Title, formatSize(d.Size), d.ModifiedAt.Local().Format("2006-01-02 15:04"), status)
+				}
+				w.Flush()
+			}
+			if len(matched) == 0 {
+				exitCode = exitNoMatch
+			}
+		},
+	}
+	cmdLs.Flags().StringSliceVarP(&lsGlobs, "glob", "g", nil, "Only list paths matching this glob (repeatable, e.g. '*.md', 'docs/*')")
+	addOutputFlags(cmdLs)
 
 	var cmdServer = &cobra.Command{
 		Use:   "server",
@@ -489,6 +865,8 @@ func main() {
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			// Validation
+			checkFormat()
+			checkPaging()
 			if !globalConfig.EmbeddingsConfigured {
 				log.Fatal("Embeddings not configured. Run 'qmd embed' first.")
 			}
@@ -515,7 +893,9 @@ func main() {
 			// Generate Embedding for the query
 			// Note: We perform this synchronously. In a more advanced version with query expansion,
 			// we would generate multiple variations here.
-			fmt.Printf("Analyzing query: %q...\n", query)
+			if outputFormat == formatText && !filesOnly {
+				fmt.Printf("Analyzing query: %q...\n", query)
+			}
 			qVec, err := embedder.Embed(query, true)
 			if err != nil {
 				log.Fatal(err)
@@ -523,21 +903,30 @@ func main() {
 
 			// Perform Hybrid Search
 			// Defaulting to 1 context line for CLI usage to maintain previous behavior
-			results, err := globalStore.SearchHybrid(query, qVec, 10, 1)
+			results, err := globalStore.SearchHybrid(query, qVec, resultOffset+resultLimit, 1, store.HybridOptions{
+				Graph:         graphBoost,
+				Filter:        searchFilter(),
+				MinBM25:       minBM25,
+				MinSimilarity: minSimilarity,
+				MinScore:      minScore,
+				Explain:       explainRanking,
+			})
 			if err != nil {
 				log.Fatal(err)
 			}
+			results = store.PageResults(results, resultOffset, resultLimit)
 
 			// Output Results
-			if len(results) == 0 {
-				fmt.Println("No results found.")
+			if emitResults(results, func(r store.SearchResult) string { return r.Snippet }) {
 				return
 			}
 
+			defer printPageFooter(len(results), func() (int, error) { return globalStore.CountHybrid(query, searchFilter()) })
+
 			fmt.Println("\nHybrid Search Results (RRF):")
 			for i, r := range results {
 				// Visual separator
-				fmt.Printf("\n%d. \033[1;36m%s\033[0m (Score: %.4f)\n", i+1, r.Filepath, r.Score)
+				fmt.Printf("\n%d. %s (Score: %.4f)\n", resultOffset+i+1, highlight(r.Filepath), r.Score)
 				fmt.Printf("   Title: %s\n", r.Title)
 
 				// Prefer showing specific matches if available (from FTS), otherwise snippet
@@ -553,52 +942,576 @@ func main() {
 					}
 					fmt.Printf("   %s\n", snippet)
 				}
+				if r.Explain != nil {
+					printExplanation(r.Explain)
+				}
 			}
 		},
 	}
+	cmdQuery.Flags().StringVarP(&collectionName, "collection", "c", "", "Only return documents of this collection")
+	cmdQuery.Flags().StringSliceVarP(&filterTags, "tag", "t", nil, "Only return documents with this tag, nested tags included (repeatable)")
+	addOutputFlags(cmdQuery)
+	addPagingFlags(cmdQuery)
+	cmdQuery.Flags().BoolVar(&graphBoost, "graph", false, "Boost documents central in the link graph and include documents linked to the top results")
+	cmdQuery.Flags().BoolVar(&explainRanking, "explain", false, "Show the BM25 and vector scores and ranks of each result and their contribution to the fused score")
+	cmdQuery.Flags().Float64Var(&minBM25, "min-bm25", 0, "Drop keyword candidates with a BM25 score below this value")
+	cmdQuery.Flags().Float64Var(&minSimilarity, "min-similarity", 0, "Drop vector candidates with a cosine similarity below this value (0-1)")
+	cmdQuery.Flags().Float64Var(&minScore, "min-score", 0, "Drop results with a fused RRF score below this value")
 
 	var cmdChat = &cobra.Command{
 		Use:   "chat",
-		Short: "Chat with your notes using Ollama and MCP tools",
+		Short: "Chat with your notes using Ollama or an OpenAI-compatible server and MCP tools",
+		Long:  "Starts an interactive chat with your notes. The chat settings given as flags (provider, URL, model, context size, temperature) are saved in the database and reused by later sessions.",
 		Run: func(cmd *cobra.Command, args []string) {
 			// Reuse the already initialized globalStore
 			// PersistentPreRun initialized it
 
-			var embedder llm.Embedder
-			var err error
+			// Update and persist the chat settings
+			applyChatFlags(cmd)
+			backend, err := chatBackend()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if closer, ok := backend.(io.Closer); ok {
+				defer closer.Close()
+			}
+			if err := globalStore.SaveConfig(globalConfig); err != nil {
+				log.Fatal(err)
+			}
+
+			mcpSrv, closeTools := chatTools()
+			defer closeTools()
+			external := connectMCPServers()
+			defer closeMCPServers(external)
+
+			// Initialize Chat Session
+			var collections []string
+			for _, c := range globalConfig.Collections {
+				collections = append(collections, c.Name)
+			}
+			session, err := chat.NewSession(backend, globalStore, mcpSrv, chat.SessionOptions{
+				Provider: globalConfig.ChatProvider,
+				Model:    chatModelName(),
+				ResumeID: chatResume,
+				Resolve: func(docPath string) string {
+					if p := diskPath(docPath); p != docPath {
+						return p
+					}
+					return ""
+				},
+				Collections: collections,
+				External:    external,
+			})
+			if err != nil {
+				log.Fatalf("Failed to initialize chat session: %v", err)
+			}
+
+			// Start Chat Loop
+			if err := session.Start(context.Background()); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	addChatFlags(cmdChat)
+	cmdChat.Flags().Int64Var(&chatResume, "resume", 0, "Continue the saved conversation with this ID (see chat list)")
+
+	var cmdChatList = &cobra.Command{
+		Use:   "list",
+		Short: "List saved conversations",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkFormat()
+			sessions, err := globalStore.ListChatSessions(chatListLimit)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if outputFormat != formatText {
+				header := []string{"id", "title", "provider", "model", "created_at", "updated_at", "message_count"}
+				err := writeRecords(outputFormat, sessions, header, func(cs store.ChatSession) []string {
+					return []string{strconv.FormatInt(cs.ID, 10), cs.Title, cs.Provider, cs.Model, cs.CreatedAt.Format(time.RFC3339), cs.UpdatedAt.Format(time.RFC3339), strconv.Itoa(cs.MessageCount)}
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			if len(sessions) == 0 {
+				fmt.Println("No saved conversations.")
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, cs := range sessions {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d msgs\n", cs.ID, cs.UpdatedAt.Local().Format("2006-01-02 15:04"), cs.Model, cs.Title, cs.MessageCount)
+			}
+			w.Flush()
+		},
+	}
+	cmdChatList.Flags().IntVarP(&chatListLimit, "limit", "n", 0, "Max number of conversations, most recent first (default all)")
+	cmdChatList.Flags().StringVar(&outputFormat, "format", formatText, "Output format: "+strings.Join(outputFormats, ", "))
+
+	var cmdChatExport = &cobra.Command{
+		Use:   "export <id>",
+		Short: "Export a saved conversation as markdown or JSON",
+		Long:  "Writes a saved conversation to stdout, with its messages, tool calls and tool results. The markdown export folds tool results in <details> sections.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid conversation ID %q", args[0])
+			}
+			if err := chat.Export(os.Stdout, globalStore, id, exportFormat); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	cmdChatExport.Flags().StringVar(&exportFormat, "format", chat.ExportMarkdown, "Export format: md or json")
 
+	var cmdChatMCP = &cobra.Command{
+		Use:   "mcp",
+		Short: "Manage the external MCP servers offering tools to the chat model",
+	}
+
+	var cmdChatMCPAdd = &cobra.Command{
+		Use:   "add <name> <command|url> [args...]",
+		Short: "Add an external MCP server, run as a command over stdio or reached over HTTP",
+		Long:  "Saves an external MCP server whose tools are offered to the model by 'qmd chat' and 'qmd ask', named \"<name>__<tool>\". A URL starting with http:// or https:// is a streamable HTTP server, anything else a command speaking MCP over stdio; put its arguments after -- so they are not read as qmd flags. ${VAR} references in --env and --header values are expanded when connecting, and fail if the variable is unset: quote them to keep secrets out of the database. Other $ signs are kept as they are. Adding an existing name replaces it.",
+		Example: `  qmd chat mcp add git -- uvx mcp-server-git --repository ~/notes
+  qmd chat mcp add fs -- npx -y @modelcontextprotocol/server-filesystem ~/notes
+  qmd chat mcp add issues https://mcp.example.com/mcp --header 'Authorization=Bearer ${ISSUES_TOKEN}'`,
+		Args: cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			srv := config.MCPServer{Name: args[0], Env: mcpEnv}
+			if chat.IsServerURL(args[1]) && len(args) == 2 {
+				srv.URL = args[1]
+			} else {
+				srv.Command, srv.Args = args[1], args[2:]
+			}
+			if len(mcpHeaders) > 0 {
+				srv.Headers = make(map[string]string)
+				for _, h := range mcpHeaders {
+					k, v, ok := strings.Cut(h, "=")
+					if !ok {
+						log.Fatalf("invalid header %q, expected NAME=VALUE", h)
+					}
+					srv.Headers[k] = v
+				}
+			}
+			if err := chat.ValidateServer(srv); err != nil {
+				log.Fatal(err)
+			}
+
+			verb := "Added"
+			servers := globalConfig.ChatMCPServers[:0]
+			for _, s := range globalConfig.ChatMCPServers {
+				if s.Name == srv.Name {
+					verb = "Updated"
+					continue
+				}
+				servers = append(servers, s)
+			}
+			globalConfig.ChatMCPServers = append(servers, srv)
+			if err := globalStore.SaveConfig(globalConfig); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%s MCP server %s\n", verb, srv.Name)
+		},
+	}
+	cmdChatMCPAdd.Flags().StringArrayVar(&mcpEnv, "env", nil, "Environment variable of the command, as KEY=VALUE (repeatable)")
+	cmdChatMCPAdd.Flags().StringArrayVar(&mcpHeaders, "header", nil, "HTTP header sent to the server, as NAME=VALUE (repeatable)")
+
+	var cmdChatMCPList = &cobra.Command{
+		Use:   "list",
+		Short: "List the external MCP servers",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkFormat()
+			servers := globalConfig.ChatMCPServers
+			if outputFormat != formatText {
+				servers = make([]config.MCPServer, 0, len(globalConfig.ChatMCPServers))
+				for _, s := range globalConfig.ChatMCPServers {
+					servers = append(servers, s.Redacted())
+				}
+				header := []string{"name", "command", "args", "url"}
+				err := writeRecords(outputFormat, servers, header, func(s config.MCPServer) []string {
+					return []string{s.Name, s.Command, strings.Join(s.Args, " "), s.URL}
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			if len(servers) == 0 {
+				fmt.Println("No MCP servers, add one with 'qmd chat mcp add'.")
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, s := range servers {
+				target := s.URL
+				if target == "" {
+					target = strings.Join(append([]string{s.Command}, s.Args...), " ")
+				}
+				fmt.Fprintf(w, "%s\t%s\n", s.Name, target)
+			}
+			w.Flush()
+		},
+	}
+	cmdChatMCPList.Flags().StringVar(&outputFormat, "format", formatText, "Output format: "+strings.Join(outputFormats, ", "))
+
+	var cmdChatMCPRemove = &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove an external MCP server",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			servers := globalConfig.ChatMCPServers[:0]
+			for _, s := range globalConfig.ChatMCPServers {
+				if s.Name != args[0] {
+					servers = append(servers, s)
+				}
+			}
+			if len(servers) == len(globalConfig.ChatMCPServers) {
+				log.Fatalf("No MCP server named %s", args[0])
+			}
+			globalConfig.ChatMCPServers = servers
+			if err := globalStore.SaveConfig(globalConfig); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Removed MCP server %s\n", args[0])
+		},
+	}
+
+	cmdChatMCP.AddCommand(cmdChatMCPAdd, cmdChatMCPList, cmdChatMCPRemove)
+	cmdChat.AddCommand(cmdChatList, cmdChatExport, cmdChatMCP)
+
+	var cmdAsk = &cobra.Command{
+		Use:   "ask [question]",
+		Short: "Answer a question from your notes, without the chat UI",
+		Long:  "Answers one question with the chat model and the same tools as 'qmd chat', and prints the answer with the sources it cites. The question is read from stdin when not given or given as '-'. The chat flags apply to this call only; without them the settings saved by 'qmd chat' are used. Exits with a non-zero status when no answer could be produced.",
+		Example: `  qmd ask "How do consumer groups rebalance?"
+  echo "What did we decide about retries?" | qmd ask --format json`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if outputFormat != formatText && outputFormat != formatJSON {
+				log.Fatalf("unknown format %q (valid: %s, %s)", outputFormat, formatText, formatJSON)
+			}
+			question, err := askQuestion(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			applyChatFlags(cmd)
+			backend, err := chatBackend()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if closer, ok := backend.(io.Closer); ok {
+				defer closer.Close()
+			}
+			mcpSrv, closeTools := chatTools()
+			defer closeTools()
+
+			external := connectMCPServers()
+			defer closeMCPServers(external)
+
+			client := chat.NewClient(backend, mcpSrv)
+			for _, srv := range external {
+				client.AddServer(srv)
+			}
+			client.Provider = globalConfig.ChatProvider
+			client.Model = chatModelName()
+			client.Collection = collectionName
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			if askTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, askTimeout)
+				defer cancel()
+			}
+			answer, err := client.Ask(ctx, question, nil)
+			if err != nil {
+				log.Fatalf("Unable to answer: %v", err)
+			}
+
+			switch {
+			case outputFormat == formatJSON:
+				err = writeJSON(formatJSON, answer)
+			case !rawOutput && isTerminal():
+				var rendered string
+				if rendered, err = renderMarkdown(answer.Answer + answer.Footer); err == nil {
+					fmt.Print(rendered)
+				}
+			default:
+				fmt.Println(strings.TrimSpace(answer.Answer + answer.Footer))
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	addChatFlags(cmdAsk)
+	cmdAsk.Flags().StringVarP(&collectionName, "collection", "c", "", "Only search and read documents of this collection")
+	cmdAsk.Flags().StringVar(&outputFormat, "format", formatText, "Output format: text, or json with the cited and retrieved sources and the tool calls")
+	cmdAsk.Flags().BoolVar(&rawOutput, "raw", false, "Print the markdown answer instead of rendering it")
+	cmdAsk.Flags().DurationVar(&askTimeout, "timeout", 0, "Give up answering after this duration, e.g. 2m (default no limit)")
+
+	var cmdTui = &cobra.Command{
+		Use:   "tui",
+		Short: "Interactive search as you type",
+		Long:  "Opens a full screen search: results update as you type, with a rendered preview of the selected document scrolled to the match. Tab cycles the search mode (bm25, vector, hybrid), Ctrl+F the collection filter, Ctrl+T the tag filter, and Enter opens the document in $EDITOR at the matching line.",
+		Run: func(cmd *cobra.Command, args []string) {
+			switch tuiMode {
+			case tui.ModeBM25, tui.ModeVector, tui.ModeHybrid:
+			default:
+				log.Fatalf("Invalid mode %q: use bm25, vector or hybrid", tuiMode)
+			}
+			defer globalStore.DB.Close()
+
+			var embedder llm.Embedder
 			if globalConfig.EmbeddingsConfigured {
+				var err error
 				embedder, err = getEmbedder()
 				if err != nil {
-					log.Printf("Warning: Failed to initialize embedder: %v. Vector search tool will fail.", err)
+					log.Printf("Warning: Failed to initialize embedder: %v. Only keyword search is available.", err)
+					embedder = nil
 				} else {
 					defer embedder.Close()
 				}
 			}
 
-			// Initialize Internal MCP Server with config
-			mcpSrv := mcpserver.NewServer(globalStore, embedder, globalConfig)
+			if embedder == nil && tuiMode != tui.ModeBM25 {
+				tuiMode = tui.ModeBM25
+			}
 
-			// Initialize Chat Session
-			session, err := chat.NewSession(chatURL, chatModel, globalStore, mcpSrv)
+			var collections []string
+			for _, c := range globalConfig.Collections {
+				collections = append(collections, c.Name)
+			}
+			tagCounts, err := globalStore.ListTags("")
 			if err != nil {
-				log.Fatalf("Failed to initialize chat session: %v", err)
+				log.Fatal(err)
+			}
+			var tags []string
+			for _, t := range tagCounts {
+				tags = append(tags, t.Tag)
 			}
 
-			// Start Chat Loop
-			if err := session.Start(context.Background()); err != nil {
+			err = tui.Run(globalStore, embedder, tui.Options{
+				Mode:        tuiMode,
+				Collections: collections,
+				Tags:        tags,
+				Filter:      searchFilter(),
+				Resolve: func(docPath string) string {
+					if p := diskPath(docPath); p != docPath {
+						return p
+					}
+					return ""
+				},
+			})
+			if err != nil {
 				log.Fatal(err)
 			}
 		},
 	}
+	cmdTui.Flags().StringVar(&tuiMode, "mode", tui.ModeHybrid, "Initial search mode: bm25, vector or hybrid")
+	cmdTui.Flags().StringVarP(&collectionName, "collection", "c", "", "Initial collection filter")
+	cmdTui.Flags().StringSliceVarP(&filterTags, "tag", "t", nil, "Initial tag filter")
 
-	cmdChat.Flags().StringVarP(&chatURL, "url", "u", "http://127.0.0.1:11434", "Ollama server URL")
-	cmdChat.Flags().StringVarP(&chatModel, "model", "m", "llama3", "Ollama model name to use")
-
-	rootCmd.AddCommand(cmdAdd, cmdUpdate, cmdInfo, cmdEmbed, cmdSearch, cmdVSearch, cmdQuery, cmdServer, cmdChat)
+	rootCmd.AddCommand(cmdAdd, cmdUpdate, cmdInfo, cmdEmbed, cmdSearch, cmdVSearch, cmdQuery, cmdSimilar, cmdTags, cmdLinks, cmdBacklinks, cmdOutline, cmdGet, cmdLs, cmdServer, cmdChat, cmdAsk, cmdTui)
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
+	os.Exit(exitCode)
+}
+
+// chatBackend returns the chat backend configured in globalConfig. The
+// OpenAI-compatible backend reads its API key from OPENAI_API_KEY.
+func chatBackend() (chat.ChatBackend, error) {
+	return chat.NewBackend(chat.BackendConfig{
+		Provider:    globalConfig.ChatProvider,
+		URL:         globalConfig.ChatURL,
+		Model:       globalConfig.ChatModel,
+		ContextSize: globalConfig.ChatContextSize,
+		Temperature: globalConfig.ChatTemperature,
+		ModelPath:   globalConfig.ChatModelPath,
+		LibPath:     llamaLibPath(),
+	})
+}
+
+// addChatFlags adds the flags selecting the chat backend and its external
+// tools to cmd.
+func addChatFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&chatMCPServers, "mcp", nil, "Also offer the tools of this MCP server, as name=command [args...] or name=URL (repeatable, this session only)")
+	cmd.Flags().StringVar(&chatProvider, "provider", "", "Chat provider: ollama, openai (any OpenAI-compatible /v1/chat/completions server) or local")
+	cmd.Flags().StringVarP(&chatURL, "url", "u", "", "Chat server URL (default http://127.0.0.1:11434 for ollama, http://127.0.0.1:8080 for openai)")
+	cmd.Flags().StringVarP(&chatModel, "model", "m", "", "Chat model name to use (default llama3)")
+	cmd.Flags().IntVar(&chatContextSize, "num-ctx", 0, "Context window size in tokens: requested from Ollama and local models, and used to fit long conversations (default 8192)")
+	cmd.Flags().BoolVar(&localMode, "local", false, "Run the chat model in-process with llama.cpp (same as --provider local)")
+	cmd.Flags().StringVar(&localModelPath, "model-path", "", "Path to the GGUF chat model (local)")
+	cmd.Flags().StringVar(&localLibPath, "lib-path", "", "Path to llama.cpp library (local). Defaults to the embedding setting or the YZMA_LIB env var")
+	cmd.Flags().Float64Var(&chatTemperature, "temperature", 0, "Sampling temperature (default: the model's)")
+}
+
+// applyChatFlags applies the chat flags given to cmd to globalConfig.
+func applyChatFlags(cmd *cobra.Command) {
+	if localMode {
+		if cmd.Flags().Changed("provider") && chatProvider != chat.ProviderLocal {
+			log.Fatalf("--local conflicts with --provider %s", chatProvider)
+		}
+		chatProvider = chat.ProviderLocal
+		cmd.Flags().Set("provider", chatProvider)
+	}
+	if cmd.Flags().Changed("provider") {
+		globalConfig.ChatProvider = chatProvider
+		// The URL of another provider does not apply
+		if !cmd.Flags().Changed("url") {
+			globalConfig.ChatURL = ""
+		}
+	}
+	if cmd.Flags().Changed("url") {
+		globalConfig.ChatURL = chatURL
+	}
+	if cmd.Flags().Changed("model") {
+		globalConfig.ChatModel = chatModel
+	}
+	if cmd.Flags().Changed("num-ctx") {
+		globalConfig.ChatContextSize = chatContextSize
+	}
+	if cmd.Flags().Changed("temperature") {
+		globalConfig.ChatTemperature = &chatTemperature
+	}
+	if cmd.Flags().Changed("model-path") {
+		globalConfig.ChatModelPath = localModelPath
+	}
+}
+
+// askQuestion returns the question given as arguments, or read from stdin
+// when there are none or the only one is "-".
+func askQuestion(args []string) (string, error) {
+	if len(args) > 0 && !(len(args) == 1 && args[0] == "-") {
+		return strings.Join(args, " "), nil
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("no question: give it as argument or on stdin")
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("reading the question: %w", err)
+	}
+	question := strings.TrimSpace(string(data))
+	if question == "" {
+		return "", fmt.Errorf("no question: stdin is empty")
+	}
+	return question, nil
+}
+
+// connectMCPServers connects to the external MCP servers saved with 'chat
+// mcp add' and given with --mcp. Servers failing to connect are skipped
+// with a warning.
+func connectMCPServers() []*chat.ExternalServer {
+	servers := append([]config.MCPServer(nil), globalConfig.ChatMCPServers...)
+	for _, spec := range chatMCPServers {
+		srv, err := chat.ParseServerSpec(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		servers = append(servers, srv)
+	}
+
+	var connected []*chat.ExternalServer
+	for _, srv := range servers {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ext, err := chat.ConnectServer(ctx, srv)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: %v, its tools are unavailable", err)
+			continue
+		}
+		connected = append(connected, ext)
+	}
+	return connected
+}
+
+func closeMCPServers(servers []*chat.ExternalServer) {
+	for _, srv := range servers {
+		srv.Close()
+	}
+}
+
+// chatModelName names the configured chat model: the model file for local
+// models.
+func chatModelName() string {
+	if globalConfig.ChatProvider == chat.ProviderLocal {
+		return filepath.Base(globalConfig.ChatModelPath)
+	}
+	return globalConfig.ChatModel
+}
+
+// chatTools returns the MCP server whose tools the chat model calls, and a
+// function releasing it. Vector search needs the configured embedder.
+func chatTools() (*mcpserver.Server, func()) {
+	var embedder llm.Embedder
+	if globalConfig.EmbeddingsConfigured {
+		var err error
+		embedder, err = getEmbedder()
+		if err != nil {
+			log.Printf("Warning: Failed to initialize embedder: %v. Vector search tool will fail.", err)
+		}
+	}
+	srv := mcpserver.NewServer(globalStore, embedder, globalConfig)
+	return srv, func() {
+		if embedder != nil {
+			embedder.Close()
+		}
+	}
+}
+
+// llamaLibPath returns the llama.cpp library used by the local chat model:
+// the --lib-path flag, the embedding setting or the YZMA_LIB env var.
+func llamaLibPath() string {
+	if localLibPath != "" {
+		return localLibPath
+	}
+	if globalConfig.LocalLibPath != "" {
+		return globalConfig.LocalLibPath
+	}
+	return os.Getenv("YZMA_LIB")
+}
+
+// searchFilter builds the search filter from the command line flags.
+func searchFilter() store.Filter {
+	return store.Filter{Collection: collectionName, Tags: filterTags}
+}
+
+// resolveLinks resolves the link graph once all collections are indexed.
+func resolveLinks() {
+	broken, err := globalStore.ResolveLinks()
+	if err != nil {
+		log.Printf("Error resolving links: %v", err)
+		return
+	}
+	if broken > 0 {
+		fmt.Printf("%d broken links (see 'qmd links --broken')\n", broken)
+	}
+	if err := globalStore.ComputeGraphRank(); err != nil {
+		log.Printf("Error computing graph rank: %v", err)
+	}
+}
+
+// formatLinkTarget describes a link as written and what it resolves to.
+func formatLinkTarget(l store.Link) string {
+	written := l.Target
+	if l.Heading != "" {
+		written += "#" + l.Heading
+	}
+	if l.Kind == util.LinkWiki {
+		written = "[[" + written + "]]"
+	}
+	switch {
+	case l.Resolved == "":
+		return written + " (broken)"
+	case l.Ambiguous:
+		return written + " -> " + l.Resolved + " (ambiguous)"
+	}
+	return written + " -> " + l.Resolved
 }
 
 func reindex(col config.Collection) {
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/akhenakh/qmd/internal/config"
+	"github.com/akhenakh/qmd/internal/store"
+	"github.com/charmbracelet/glamour"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// isTerminal reports whether stdout is an interactive terminal.
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// renderMarkdown renders markdown for the terminal, wrapped to its width.
+func renderMarkdown(content string) (string, error) {
+	width := 100
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 && w < width {
+		width = w
+	}
+	renderer, err := glamour.NewTermRenderer(
+		glamour.WithAutoStyle(),
+		glamour.WithWordWrap(width),
+	)
+	if err != nil {
+		return "", err
+	}
+	return renderer.Render(content)
+}
+
+// parseLineRange parses "START-END", "START-" (to the end) or "LINE".
+func parseLineRange(s string) (int, int, error) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	start, err := strconv.Atoi(strings.TrimSpace(startStr))
+	if err != nil || start < 1 {
+		return 0, 0, fmt.Errorf("invalid line range %q (expected START-END, START- or LINE)", s)
+	}
+	if !isRange {
+		return start, start, nil
+	}
+	if strings.TrimSpace(endStr) == "" {
+		return start, 0, nil
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(endStr))
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid line range %q (expected START-END, START- or LINE)", s)
+	}
+	return start, end, nil
+}
+
+// formatSize formats a byte count for humans.
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Output formats of the --format flag
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+	formatTSV   = "tsv"
+)
+
+var outputFormats = []string{formatText, formatJSON, formatJSONL, formatCSV, formatTSV}
+
+// exitNoMatch is the exit code of search commands finding nothing, like grep.
+const exitNoMatch = 1
+
+// exitCode is the status main exits with once the command has returned, so
+// deferred calls and PersistentPostRun still run.
+var exitCode int
+
+// colorEnabled is false when stdout is piped or NO_COLOR is set.
+var colorEnabled = isTerminal() && os.Getenv("NO_COLOR") == ""
+
+// highlight colors a file path in bold cyan.
+func highlight(s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return "\033[1;36m" + s + "\033[0m"
+}
+
+// addOutputFlags registers --format and --files-only on a command.
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&outputFormat, "format", formatText, "Output format: "+strings.Join(outputFormats, ", "))
+	cmd.Flags().BoolVarP(&filesOnly, "files-only", "l", false, "Only print the path of matching files, one per line")
+}
+
+// checkFormat validates the --format flag.
+func checkFormat() {
+	if !slices.Contains(outputFormats, outputFormat) {
+		log.Fatalf("unknown format %q (valid: %s)", outputFormat, strings.Join(outputFormats, ", "))
+	}
+}
+
+// writeRecords writes items as a JSON array, JSON lines, or CSV/TSV rows
+// with a header.
+func writeRecords[T any](format string, items []T, header []string, row func(T) []string) error {
+	switch format {
+	case formatJSON:
+		if items == nil {
+			items = []T{}
+		}
+		return writeJSON(format, items)
+	case formatJSONL:
+		for _, item := range items {
+			if err := writeJSON(format, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case formatCSV, formatTSV:
+		w := csv.NewWriter(os.Stdout)
+		if format == formatTSV {
+			w.Comma = '\t'
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := w.Write(row(item)); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	}
+	return fmt.Errorf("format %q is not supported here", format)
+}
+
+// writeJSON writes a value as indented JSON, or on a single line for jsonl.
+func writeJSON(format string, v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	if format == formatJSON {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(v)
+}
+
+// emitResults prints search results in the machine readable format chosen
+// with --format, or their file paths with --files-only, and sets exitCode
+// to exitNoMatch if there are none. It returns false when the text output is
+// to be printed by the caller. snippet selects the text shown for a result.
+func emitResults(results []store.SearchResult, snippet func(store.SearchResult) string) bool {
+	switch {
+	case filesOnly:
+		seen := make(map[string]bool)
+		for _, r := range results {
+			if !seen[r.Filepath] {
+				seen[r.Filepath] = true
+				fmt.Println(diskPath(r.Filepath))
+			}
+		}
+	case outputFormat != formatText:
+		records := make([]store.SearchResultJSON, len(results))
+		for i, r := range results {
+			records[i] = store.SearchResultJSON{
+				Filepath: r.Filepath,
+				Title:    r.Title,
+				Score:    r.Score,
+				Size:     r.Size,
+				Snippet:  snippet(r),
+				Matches:  r.Matches,
+				Explain:  r.Explain,
+			}
+		}
+		header := []string{"filepath", "title", "score", "size", "snippet"}
+		err := writeRecords(outputFormat, records, header, func(r store.SearchResultJSON) []string {
+			return []string{r.Filepath, r.Title, strconv.FormatFloat(r.Score, 'f', 4, 64), strconv.Itoa(r.Size), r.Snippet}
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		if len(results) == 0 {
+			fmt.Println("No results found.")
+			exitCode = exitNoMatch
+			return true
+		}
+		return false
+	}
+
+	if len(results) == 0 {
+		exitCode = exitNoMatch
+	}
+	return true
+}
+
+// diskPath returns the file path of a "collection/path" document for
+// collections indexed from a folder, and the document path otherwise
+// (archives).
+func diskPath(docPath string) string {
+	collection, rel, _ := strings.Cut(docPath, "/")
+	for _, c := range globalConfig.Collections {
+		if c.Name != collection {
+			continue
+		}
+		if info, err := os.Stat(c.Path); err == nil && info.IsDir() {
+			return filepath.Join(c.Path, filepath.FromSlash(rel))
+		}
+	}
+	return docPath
+}
+
+// infoJSON is the --format json output of the info command.
+type infoJSON struct {
+	Database string         `json:"database"`
+	Config   *config.Config `json:"config"`
+	Stats    *store.Stats   `json:"stats"`
+}
+
+// addPagingFlags registers --limit and --offset on a search command.
+func addPagingFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&resultLimit, "limit", "n", 10, "Max number of results")
+	cmd.Flags().IntVar(&resultOffset, "offset", 0, "Skip this many results, to fetch the next pages")
+}
+
+// checkPaging validates the --limit and --offset flags.
+func checkPaging() {
+	if resultLimit < 1 || resultOffset < 0 {
+		log.Fatal("--limit must be positive and --offset not negative")
+	}
+}
+
+// printPageFooter tells how to fetch the next page of text output when the
+// current page is full.
+func printPageFooter(shown int, total func() (int, error)) {
+	if shown < resultLimit {
+		return
+	}
+	n, err := total()
+	if err != nil || n <= resultOffset+shown {
+		return
+	}
+	fmt.Printf("\nResults %d-%d of about %d. Next page: --offset %d\n", resultOffset+1, resultOffset+shown, n, resultOffset+shown)
+}
+
+// printExplanation prints the ranking breakdown of a hybrid search result.
+func printExplanation(e *store.Explanation) {
+	if e.BM25 != nil {
+		fmt.Printf("   bm25:   rank %d, score %.4f\n", e.BM25.Rank, e.BM25.Score)
+	} else {
+		fmt.Println("   bm25:   no match")
+	}
+	if e.Vector != nil {
+		fmt.Printf("   vector: rank %d, similarity %.4f, chunk %d\n", e.Vector.Rank, e.Vector.Score, *e.ChunkSeq)
+	} else {
+		fmt.Println("   vector: not among candidates")
+	}
+	var parts []string
+	for _, source := range []string{store.SourceBM25, store.SourceVector, store.SourceAuthority, store.SourceExpansion} {
+		if c, ok := e.Contributions[source]; ok {
+			parts = append(parts, fmt.Sprintf("%s %.4f", source, c))
+		}
+	}
+	fmt.Printf("   rrf:    %s\n", strings.Join(parts, " + "))
+}